@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	database "authservice/internal/database"
+	"authservice/pkg/mailer"
 	"authservice/pkg/service"
+	"authservice/pkg/utils"
 	authv1 "authservice/proto/auth/v1"
 
 	"google.golang.org/grpc"
@@ -18,6 +22,11 @@ import (
 )
 
 func main() {
+	// Credentials are hashed with the pepper from the first migration on
+	if err := utils.CheckTokenHashPepper(); err != nil {
+		log.Fatalf("Failed to configure credential hashing: %v", err)
+	}
+
 	listen, err := net.Listen("tcp", ":8080")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
@@ -30,10 +39,41 @@ func main() {
 	cleanupService := service.NewCleanupService(dbConnection.DB)
 	cleanupService.Start()
 
+	// Rate limits run after logging, so limited calls are logged too
+	interceptors := []grpc.UnaryServerInterceptor{unaryInterceptor}
+	limiter, err := service.RateLimiterFromEnv(dbConnection.DB)
+	if err != nil {
+		log.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	if limiter != nil {
+		interceptors = append(interceptors, limiter.UnaryServerInterceptor(service.RateLimitCaller))
+	}
+	interceptors = append(interceptors, service.AdminAuthInterceptor)
+
 	grpcserver := grpc.NewServer(
-		grpc.UnaryInterceptor(unaryInterceptor),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	)
-	authv1.RegisterAuthServiceServer(grpcserver, service.NewAuthServiceServer(dbConnection.DB))
+	authServer := service.NewAuthServiceServer(dbConnection.DB)
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	authServer.SetMailer(mail)
+	authv1.RegisterAuthServiceServer(grpcserver, authServer)
+	authv1.RegisterAdminServiceServer(grpcserver, service.NewAdminServiceServer(authServer))
+
+	// Load the signing key ring and use it for every token we issue or verify
+	keyRing := authServer.KeyRing()
+	loadCtx, loadCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := keyRing.Load(loadCtx); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	loadCancel()
+	if sealing, _ := utils.SigningKeyEncryptionEnabled(); !sealing {
+		log.Printf("SIGNING_KEY_ENCRYPTION_KEY is not set; private signing keys are stored in plaintext")
+	}
+	utils.SetKeyProvider(keyRing)
+	keyRing.Start()
 
 	// Enable reflection for grpcurl
 	reflection.Register(grpcserver)
@@ -45,6 +85,28 @@ func main() {
 		}
 	}()
 
+	// Optional HTTP listener for JWKS and other browser/standards facing endpoints
+	var httpServer *http.Server
+	if httpPort := os.Getenv("HTTP_PORT"); httpPort != "" {
+		// The HTTP endpoints check passwords and client secrets too, so they
+		// draw from the same limiter as the gRPC calls
+		httpHandler := service.NewHTTPHandler(authServer)
+		if limiter != nil {
+			httpHandler = limiter.HTTPMiddleware(httpHandler, service.RateLimitHTTPCaller)
+		}
+		httpServer = &http.Server{
+			Addr:              ":" + httpPort,
+			Handler:           httpHandler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("Starting the HTTP server on: %s", httpPort)
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to start HTTP server: %v", err)
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -61,6 +123,12 @@ func main() {
 		close(done)
 	}()
 
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("HTTP server shutdown error: %v", err)
+		}
+	}
+
 	select {
 	case <-done:
 		log.Println("Server stopped gracefully")
@@ -71,6 +139,7 @@ func main() {
 
 	// Stop background jobs
 	cleanupService.Stop()
+	keyRing.Stop()
 
 	// Close database after gRPC server stops accepting new connections
 	dbConnection.Close()
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	models "authservice/pkg/models"
+	"authservice/pkg/utils"
+)
+
+// dataMigration is a one-shot data conversion, recorded in schema_migrations
+// once applied so it never runs twice
+type dataMigration struct {
+	name string
+	run  func(tx *gorm.DB) error
+}
+
+var dataMigrations = []dataMigration{
+	{name: "0001_hash_credentials_at_rest", run: hashCredentialsAtRest},
+	{name: "0002_session_id_primary_key", run: sessionIDPrimaryKey},
+	{name: "0003_identity_pool_email_uniqueness", run: identityPoolEmailUniqueness},
+	{name: "0004_organization_member_per_client", run: organizationMemberPerClient},
+}
+
+func (dbCon *DBConnection) runDataMigrations() error {
+	for _, migration := range dataMigrations {
+		err := dbCon.Transaction(func(tx *gorm.DB) error {
+			var applied models.SchemaMigration
+			err := tx.Where("name = ?", migration.name).First(&applied).Error
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			log.Printf("Applying data migration %s...", migration.name)
+			if err := migration.run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.SchemaMigration{Name: migration.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			log.Printf("Error applying data migration %s: %v", migration.name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// hashCredentialsAtRest replaces plaintext refresh tokens and client secrets
+// with their keyed hashes. Live refresh tokens keep working because lookups
+// hash the presented token; clients keep their existing secrets.
+func hashCredentialsAtRest(tx *gorm.DB) error {
+	var sessions []models.Session
+	if err := tx.Unscoped().Find(&sessions).Error; err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := tx.Unscoped().Model(&models.Session{}).
+			Where("user_id = ? AND client_id = ?", session.UserID, session.ClientID).
+			Update("refresh_token", utils.HashToken(session.RefreshTokenHash)).Error; err != nil {
+			return err
+		}
+	}
+
+	var superseded []models.SupersededRefreshToken
+	if err := tx.Find(&superseded).Error; err != nil {
+		return err
+	}
+	for _, token := range superseded {
+		if err := tx.Model(&models.SupersededRefreshToken{}).
+			Where("refresh_token = ?", token.RefreshTokenHash).
+			Update("refresh_token", utils.HashToken(token.RefreshTokenHash)).Error; err != nil {
+			return err
+		}
+	}
+
+	var clients []models.Client
+	if err := tx.Unscoped().Find(&clients).Error; err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := tx.Unscoped().Model(&models.Client{}).
+			Where("client_id = ?", client.ClientID).
+			Update("client_secret", utils.HashToken(client.ClientSecretHash)).Error; err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Hashed %d refresh tokens, %d superseded refresh tokens and %d client secrets",
+		len(sessions), len(superseded), len(clients))
+	return nil
+}
+
+// sessionIDPrimaryKey moves sessions from the (user_id, client_id) composite
+// primary key to a per-session ID, so a user can hold several sessions per
+// client. AutoMigrate has already added the session_id column and the
+// user_id/client_id indexes the foreign keys need once the old key is gone.
+func sessionIDPrimaryKey(tx *gorm.DB) error {
+	if err := tx.Exec("UPDATE sessions SET session_id = UUID() WHERE session_id IS NULL OR session_id = ''").Error; err != nil {
+		return err
+	}
+
+	var primaryKeyColumns []string
+	if err := tx.Raw(`
+		SELECT column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE()
+		AND table_name = 'sessions'
+		AND constraint_name = 'PRIMARY'
+	`).Scan(&primaryKeyColumns).Error; err != nil {
+		return err
+	}
+	if len(primaryKeyColumns) == 1 && primaryKeyColumns[0] == "session_id" {
+		return nil
+	}
+
+	return tx.Exec("ALTER TABLE sessions DROP PRIMARY KEY, ADD PRIMARY KEY (session_id)").Error
+}
+
+// identityPoolEmailUniqueness scopes email uniqueness to identity pools.
+// Every existing client is a pool of its own, so existing users join their
+// client's pool; AutoMigrate has already added the (identity_pool_id, email)
+// unique index, which the old global one now contradicts.
+func identityPoolEmailUniqueness(tx *gorm.DB) error {
+	result := tx.Exec("UPDATE users SET identity_pool_id = client_id WHERE identity_pool_id = ''")
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("Assigned %d users to their client's identity pool", result.RowsAffected)
+
+	for _, index := range []string{"idx_users_email", "idx_users_email_id"} {
+		if tx.Migrator().HasIndex(&models.User{}, index) {
+			if err := tx.Migrator().DropIndex(&models.User{}, index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// organizationMemberPerClient moves organization membership from the user_id
+// primary key to (user_id, client_id), so a user of a shared identity pool
+// can belong to one organization in each of the pool's clients. AutoMigrate
+// has already added the client_id column, which is filled in from each
+// membership's organization.
+func organizationMemberPerClient(tx *gorm.DB) error {
+	result := tx.Exec(`
+		UPDATE organization_members m
+		JOIN organizations o ON o.organization_id = m.organization_id
+		SET m.client_id = o.client_id
+		WHERE m.client_id = ''
+	`)
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("Assigned %d organization members to their organization's client", result.RowsAffected)
+
+	var primaryKeyColumns []string
+	if err := tx.Raw(`
+		SELECT column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE()
+		AND table_name = 'organization_members'
+		AND constraint_name = 'PRIMARY'
+		ORDER BY ordinal_position
+	`).Scan(&primaryKeyColumns).Error; err != nil {
+		return err
+	}
+	if len(primaryKeyColumns) == 2 && primaryKeyColumns[0] == "user_id" && primaryKeyColumns[1] == "client_id" {
+		return nil
+	}
+
+	return tx.Exec("ALTER TABLE organization_members DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, client_id)").Error
+}
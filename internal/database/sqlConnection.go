@@ -136,10 +136,94 @@ func (dbCon *DBConnection) createTablesIfNotExist() error {
 	}
 	log.Println("Sessions table migration completed")
 
+	// Create SigningKey table (JWT key ring, no dependencies)
+	if err := dbCon.AutoMigrate(&models.SigningKey{}); err != nil {
+		log.Printf("Error migrating SigningKey table: %v", err)
+		return err
+	}
+	log.Println("Signing keys table migration completed")
+
+	// Create refresh token history and security event tables
+	if err := dbCon.AutoMigrate(&models.SupersededRefreshToken{}, &models.SecurityEvent{}); err != nil {
+		log.Printf("Error migrating refresh token history tables: %v", err)
+		return err
+	}
+	log.Println("Refresh token history and security event tables migration completed")
+
+	if err := dbCon.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		log.Printf("Error migrating SchemaMigration table: %v", err)
+		return err
+	}
+
+	// Create AuthorizationCode table (depends on User and Client)
+	if err := dbCon.AutoMigrate(&models.AuthorizationCode{}); err != nil {
+		log.Printf("Error migrating AuthorizationCode table: %v", err)
+		return err
+	}
+	log.Println("Authorization codes table migration completed")
+
+	// Create DeviceAuthorization table (depends on Client)
+	if err := dbCon.AutoMigrate(&models.DeviceAuthorization{}); err != nil {
+		log.Printf("Error migrating DeviceAuthorization table: %v", err)
+		return err
+	}
+	log.Println("Device authorizations table migration completed")
+
+	// Create RBAC tables (Role and Permission depend on Client)
+	if err := dbCon.AutoMigrate(&models.Role{}); err != nil {
+		log.Printf("Error migrating Role table: %v", err)
+		return err
+	}
+	log.Println("Roles table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.Permission{}); err != nil {
+		log.Printf("Error migrating Permission table: %v", err)
+		return err
+	}
+	log.Println("Permissions table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.RolePermission{}); err != nil {
+		log.Printf("Error migrating RolePermission table: %v", err)
+		return err
+	}
+	log.Println("Role permissions table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.UserRole{}); err != nil {
+		log.Printf("Error migrating UserRole table: %v", err)
+		return err
+	}
+	log.Println("User roles table migration completed")
+
+	// Create organization tables (Organization depends on Client)
+	if err := dbCon.AutoMigrate(&models.Organization{}); err != nil {
+		log.Printf("Error migrating Organization table: %v", err)
+		return err
+	}
+	log.Println("Organizations table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.OrganizationMember{}); err != nil {
+		log.Printf("Error migrating OrganizationMember table: %v", err)
+		return err
+	}
+	log.Println("Organization members table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.Invitation{}); err != nil {
+		log.Printf("Error migrating Invitation table: %v", err)
+		return err
+	}
+	log.Println("Invitations table migration completed")
+
+	if err := dbCon.AutoMigrate(&models.UserToken{}); err != nil {
+		log.Printf("Error migrating UserToken table: %v", err)
+		return err
+	}
+	log.Println("User tokens table migration completed")
+
 	// Add foreign key constraints if they don't exist
 	dbCon.addForeignKeyConstraintsIfNotExist()
 
-	return nil
+	// Apply one-shot data migrations that have not run yet
+	return dbCon.runDataMigrations()
 }
 
 func (dbCon *DBConnection) addForeignKeyConstraintsIfNotExist() {
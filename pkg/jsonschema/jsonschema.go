@@ -0,0 +1,445 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema (draft 2020-12) needed to describe small documents such as user
+// custom attributes: type, enum, const, properties, required,
+// additionalProperties, items, string length and pattern, numeric bounds and
+// array length. Schemas using any other validation keyword are rejected when
+// compiled rather than silently under-validating. Annotations such as title,
+// description and format are ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsupportedKeywords are validation keywords this package does not implement
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "allOf", "anyOf", "oneOf", "not", "if", "then", "else",
+	"dependentSchemas", "dependentRequired", "patternProperties", "propertyNames",
+	"unevaluatedProperties", "unevaluatedItems", "prefixItems", "contains",
+	"minContains", "maxContains", "uniqueItems", "multipleOf", "minProperties", "maxProperties",
+}
+
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Schema is a compiled schema
+type Schema struct {
+	reject bool // the false schema
+
+	types                []string
+	enum                 []any
+	hasConst             bool
+	constValue           any
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema // nil allows any additional property
+	items                *Schema
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minItems, maxItems   *int
+}
+
+// Compile parses a JSON Schema document
+func Compile(schema []byte) (*Schema, error) {
+	value, err := decode(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+	return compile(value, "")
+}
+
+// Validate checks a JSON document against the schema. The error names the
+// JSON pointer of the first violation found.
+func (s *Schema) Validate(document []byte) error {
+	value, err := decode(document)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return s.validate(value, "")
+}
+
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+func compile(value any, path string) (*Schema, error) {
+	switch v := value.(type) {
+	case bool:
+		return &Schema{reject: !v}, nil
+	case map[string]any:
+		return compileObject(v, path)
+	default:
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", pointer(path))
+	}
+}
+
+func compileObject(keywords map[string]any, path string) (*Schema, error) {
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := keywords[keyword]; ok {
+			return nil, fmt.Errorf("%s: keyword %q is not supported", pointer(path), keyword)
+		}
+	}
+
+	s := &Schema{}
+	var err error
+	if raw, ok := keywords["type"]; ok {
+		if s.types, err = compileTypes(raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", pointer(path), err)
+		}
+	}
+	if raw, ok := keywords["enum"]; ok {
+		values, ok := raw.([]any)
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("%s: enum must be a non-empty array", pointer(path))
+		}
+		s.enum = values
+	}
+	if raw, ok := keywords["const"]; ok {
+		s.hasConst, s.constValue = true, raw
+	}
+	if raw, ok := keywords["properties"]; ok {
+		properties, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: properties must be an object", pointer(path))
+		}
+		s.properties = make(map[string]*Schema, len(properties))
+		for name, property := range properties {
+			if s.properties[name], err = compile(property, path+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if raw, ok := keywords["required"]; ok {
+		names, ok := raw.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: required must be an array of strings", pointer(path))
+		}
+		for _, name := range names {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: required must be an array of strings", pointer(path))
+			}
+			s.required = append(s.required, str)
+		}
+	}
+	if raw, ok := keywords["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(raw, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := keywords["items"]; ok {
+		if s.items, err = compile(raw, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if s.pattern, err = compilePattern(keywords, path); err != nil {
+		return nil, err
+	}
+	for keyword, target := range map[string]**int{
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+	} {
+		if *target, err = nonNegativeInt(keywords, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if *target, err = number(keywords, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func compileTypes(raw any) ([]string, error) {
+	var names []any
+	switch v := raw.(type) {
+	case string:
+		names = []any{v}
+	case []any:
+		names = v
+	default:
+		return nil, errors.New("type must be a string or an array of strings")
+	}
+	types := make([]string, 0, len(names))
+	for _, name := range names {
+		str, ok := name.(string)
+		if !ok || !knownTypes[str] {
+			return nil, fmt.Errorf("unknown type %v", name)
+		}
+		types = append(types, str)
+	}
+	return types, nil
+}
+
+func compilePattern(keywords map[string]any, path string) (*regexp.Regexp, error) {
+	raw, ok := keywords["pattern"]
+	if !ok {
+		return nil, nil
+	}
+	str, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: pattern must be a string", pointer(path))
+	}
+	pattern, err := regexp.Compile(str)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid pattern: %w", pointer(path), err)
+	}
+	return pattern, nil
+}
+
+func nonNegativeInt(keywords map[string]any, keyword, path string) (*int, error) {
+	raw, ok := keywords[keyword]
+	if !ok {
+		return nil, nil
+	}
+	n, ok := raw.(json.Number)
+	value, err := strconv.Atoi(string(n))
+	if !ok || err != nil || value < 0 {
+		return nil, fmt.Errorf("%s: %s must be a non-negative integer", pointer(path), keyword)
+	}
+	return &value, nil
+}
+
+func number(keywords map[string]any, keyword, path string) (*float64, error) {
+	raw, ok := keywords[keyword]
+	if !ok {
+		return nil, nil
+	}
+	n, ok := raw.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s must be a number", pointer(path), keyword)
+	}
+	value, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s must be a number", pointer(path), keyword)
+	}
+	return &value, nil
+}
+
+func (s *Schema) validate(value any, path string) error {
+	if s.reject {
+		return violation(path, "no value is allowed here")
+	}
+	if len(s.types) > 0 && !s.matchesType(value) {
+		return violation(path, "must be of type "+strings.Join(s.types, " or "))
+	}
+	if s.enum != nil && !containsValue(s.enum, value) {
+		return violation(path, "must be one of the allowed values")
+	}
+	if s.hasConst && !equal(s.constValue, value) {
+		return violation(path, "must equal the constant value")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return s.validateObject(v, path)
+	case []any:
+		return s.validateArray(v, path)
+	case string:
+		return s.validateString(v, path)
+	case json.Number:
+		return s.validateNumber(v, path)
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(object map[string]any, path string) error {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			return violation(path, fmt.Sprintf("missing required property %q", name))
+		}
+	}
+	// Sorted so the reported violation is deterministic
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property := s.properties[name]
+		if property == nil {
+			property = s.additionalProperties
+		}
+		if property == nil {
+			continue
+		}
+		if err := property.validate(object[name], path+"/"+escape(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(array []any, path string) error {
+	if s.minItems != nil && len(array) < *s.minItems {
+		return violation(path, fmt.Sprintf("must have at least %d items", *s.minItems))
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		return violation(path, fmt.Sprintf("must have at most %d items", *s.maxItems))
+	}
+	if s.items != nil {
+		for i, item := range array {
+			if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(str, path string) error {
+	length := utf8.RuneCountInString(str)
+	if s.minLength != nil && length < *s.minLength {
+		return violation(path, fmt.Sprintf("must be at least %d characters", *s.minLength))
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		return violation(path, fmt.Sprintf("must be at most %d characters", *s.maxLength))
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return violation(path, "must match pattern "+s.pattern.String())
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(n json.Number, path string) error {
+	value, err := n.Float64()
+	if err != nil {
+		return violation(path, "must be a finite number")
+	}
+	if s.minimum != nil && value < *s.minimum {
+		return violation(path, fmt.Sprintf("must be at least %v", *s.minimum))
+	}
+	if s.maximum != nil && value > *s.maximum {
+		return violation(path, fmt.Sprintf("must be at most %v", *s.maximum))
+	}
+	if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+		return violation(path, fmt.Sprintf("must be greater than %v", *s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+		return violation(path, fmt.Sprintf("must be less than %v", *s.exclusiveMaximum))
+	}
+	return nil
+}
+
+func (s *Schema) matchesType(value any) bool {
+	for _, t := range s.types {
+		if typeOf(value) == t || (t == "number" && typeOf(value) == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON type of a decoded value; numbers without a
+// fractional part are integers, as in JSON Schema
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return "null"
+	}
+}
+
+func containsValue(values []any, value any) bool {
+	for _, candidate := range values {
+		if equal(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares decoded JSON values; numbers compare by value, so 1 equals 1.0
+func equal(a, b any) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := av.Float64()
+		bf, berr := bv.Float64()
+		return aerr == nil && berr == nil && af == bf
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			other, ok := bv[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func violation(path, message string) error {
+	return fmt.Errorf("%s: %s", pointer(path), message)
+}
+
+// pointer renders a JSON pointer, using "/" for the document root
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// escape encodes a property name as a JSON pointer reference token (RFC 6901)
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
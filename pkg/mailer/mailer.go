@@ -0,0 +1,90 @@
+// Package mailer sends the service's transactional email, such as
+// organization invitations and email verification links. Implementations are
+// selected with MAILER.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Send returns once the message has been handed
+// off; an error means it was not sent.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them. It is meant
+// for development: message bodies carry one-time tokens.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FromEnv returns the mailer selected by MAILER: "log" (the default), "file"
+// or "smtp"
+func FromEnv() (Mailer, error) {
+	switch kind := os.Getenv("MAILER"); kind {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		path := os.Getenv("MAILER_FILE")
+		if path == "" {
+			return nil, errors.New("MAILER_FILE is required for the file mailer")
+		}
+		return NewFileMailer(path), nil
+	case "smtp":
+		return smtpMailerFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported MAILER %q", kind)
+	}
+}
+
+func smtpMailerFromEnv() (*SMTPMailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	from := os.Getenv("MAIL_FROM")
+	if host == "" || from == "" {
+		return nil, errors.New("SMTP_HOST and MAIL_FROM are required for the smtp mailer")
+	}
+	port := 587
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid SMTP_PORT %q", value)
+		}
+		port = parsed
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
+
+// validateHeaders rejects addresses and subjects that would inject extra
+// headers into the message
+func validateHeaders(msg Message) error {
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("message headers must not contain line breaks")
+	}
+	return nil
+}
@@ -1,46 +1,403 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type Client struct {
-	ClientID     string         `gorm:"column:client_id;primaryKey;size:36" json:"client_id"`
-	ClientName   string         `gorm:"size:100;not null" json:"client_name"`
-	ClientSecret string         `gorm:"size:255;not null" json:"-"`
-	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ClientID                 string         `gorm:"column:client_id;primaryKey;size:36" json:"client_id"`
+	ClientName               string         `gorm:"size:100;not null" json:"client_name"`
+	ClientSecretHash         string         `gorm:"column:client_secret;size:255;not null" json:"-"`             // keyed hash, see utils.HashToken; empty for public clients
+	Public                   bool           `gorm:"not null;default:false" json:"public"`                        // has no secret; identified by client_id alone and must use PKCE
+	MaxSessionsPerUser       int            `gorm:"not null;default:0" json:"max_sessions_per_user"`             // 0 uses DEFAULT_MAX_SESSIONS_PER_USER
+	RedirectURIs             string         `gorm:"column:redirect_uris;type:text" json:"redirect_uris"`         // newline separated, exact match
+	AllowedScopes            string         `gorm:"column:allowed_scopes;type:text" json:"allowed_scopes"`       // space separated, as in the OAuth scope parameter
+	AllowTokenExchange       bool           `gorm:"not null;default:false" json:"allow_token_exchange"`          // may act on users' behalf via RFC 8693 token exchange
+	AllowedAudiences         string         `gorm:"column:allowed_audiences;type:text" json:"allowed_audiences"` // newline separated APIs the client's tokens may target
+	IdentityPoolID           string         `gorm:"size:36;index" json:"identity_pool_id"`                       // shared user pool; empty keeps the client's users to itself
+	RequireEmailVerification bool           `gorm:"not null;default:false" json:"require_email_verification"`    // users must verify their email before signing in
+	CustomAttributesSchema   string         `gorm:"type:text" json:"custom_attributes_schema"`                   // JSON Schema for users' custom attributes; empty accepts any object
+	EmbedCustomAttributes    bool           `gorm:"not null;default:false" json:"embed_custom_attributes"`       // copy users' custom attributes into access tokens
+	CreatedAt                time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt                time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt                gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// RedirectURIList returns the client's registered OAuth redirect URIs
+func (c *Client) RedirectURIList() []string {
+	return splitLines(c.RedirectURIs)
+}
+
+// AllowsRedirectURI reports whether uri exactly matches a registered redirect URI
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIList() {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedScopeList returns the scopes the client may be granted
+func (c *Client) AllowedScopeList() []string {
+	return strings.Fields(c.AllowedScopes)
+}
+
+// AllowsScope reports whether scope is one of the client's allowed scopes
+func (c *Client) AllowsScope(scope string) bool {
+	for _, allowed := range c.AllowedScopeList() {
+		if allowed == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityPool returns the pool the client's users live in. Clients with the
+// same pool share users; a client without one is a pool of its own.
+func (c *Client) IdentityPool() string {
+	if c.IdentityPoolID != "" {
+		return c.IdentityPoolID
+	}
+	return c.ClientID
+}
+
+// AllowedAudienceList returns the audiences the client's tokens may target
+func (c *Client) AllowedAudienceList() []string {
+	return splitLines(c.AllowedAudiences)
+}
+
+// User statuses. Every status but active refuses sign-in, refresh and token
+// validation.
+const (
+	UserStatusActive              = "active"
+	UserStatusDisabled            = "disabled"             // by an admin, until re-enabled
+	UserStatusLocked              = "locked"               // until User.LockedUntil, or until unlocked when it is nil
+	UserStatusPendingVerification = "pending_verification" // registered with a client requiring email verification
+)
+
+// User is an identity registered through ClientID. Emails are unique within
+// the identity pool of that client, so the same person can sign up to
+// unrelated clients separately.
 type User struct {
-	UserID    string         `gorm:"column:user_id;primaryKey;size:36" json:"user_id"`
-	UserName  string         `gorm:"column:user_name;size:100;not null" json:"username"`
-	Email     string         `gorm:"column:email_id;size:255;uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"size:255;not null" json:"-"`
-	ClientID  string         `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
-	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	UserID           string         `gorm:"column:user_id;primaryKey;size:36" json:"user_id"`
+	UserName         string         `gorm:"column:user_name;size:100;not null" json:"username"`
+	Email            string         `gorm:"column:email_id;size:255;not null;uniqueIndex:idx_users_identity_pool_email,priority:2" json:"email"`
+	Password         string         `gorm:"size:255;not null" json:"-"`
+	ClientID         string         `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
+	IdentityPoolID   string         `gorm:"column:identity_pool_id;size:36;not null;default:'';uniqueIndex:idx_users_identity_pool_email,priority:1" json:"identity_pool_id"` // Client.IdentityPool of ClientID
+	EmailVerified    bool           `gorm:"not null;default:false" json:"email_verified"`
+	DisplayName      string         `gorm:"size:100" json:"display_name"`
+	Locale           string         `gorm:"size:35" json:"locale"` // BCP 47 language tag
+	AvatarURL        string         `gorm:"column:avatar_url;size:2000" json:"avatar_url"`
+	CustomAttributes string         `gorm:"type:text" json:"custom_attributes"` // client-defined JSON object, see Client.CustomAttributesSchema
+	Status           string         `gorm:"size:30;not null;default:'active';index" json:"status"`
+	StatusReason     string         `gorm:"size:500" json:"status_reason"`
+	StatusChangedAt  *time.Time     `json:"status_changed_at,omitempty"`
+	LockedUntil      *time.Time     `json:"locked_until,omitempty"`
+	CreatedAt        time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// BeforeCreate places a user created without a pool in the pool of their
+// own client, which is right for every client that does not share users, and
+// makes new users active
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.IdentityPoolID == "" {
+		u.IdentityPoolID = u.ClientID
+	}
+	if u.Status == "" {
+		u.Status = UserStatusActive
+	}
+	return nil
+}
+
+// Session is one login of a user on one device; a user may hold many per client
 type Session struct {
-	UserID       string         `gorm:"column:user_id;primaryKey;size:36" json:"user_id"`
-	ClientID     string         `gorm:"column:client_id;primaryKey;size:36" json:"client_id"`
-	RefreshToken string         `gorm:"size:255;uniqueIndex;not null" json:"-"`
-	UserAgent    string         `gorm:"size:500" json:"user_agent"`
-	ExpiresAt    time.Time      `gorm:"not null" json:"expires_at"`
-	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	SessionID        string         `gorm:"column:session_id;primaryKey;size:36" json:"session_id"`
+	UserID           string         `gorm:"column:user_id;size:36;not null;index" json:"user_id"`
+	ClientID         string         `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
+	RefreshTokenHash string         `gorm:"column:refresh_token;size:255;uniqueIndex;not null" json:"-"` // keyed hash, see utils.HashToken
+	FamilyID         string         `gorm:"column:family_id;size:36;index" json:"family_id"`
+	UserAgent        string         `gorm:"size:500" json:"user_agent"`
+	IPAddress        string         `gorm:"column:ip_address;size:45" json:"ip_address"`
+	DeviceLabel      string         `gorm:"size:100" json:"device_label"`
+	Scope            string         `gorm:"type:text" json:"scope"`    // space separated scopes granted at login
+	Audience         string         `gorm:"type:text" json:"audience"` // newline separated audiences granted at login
+	LastUsedAt       *time.Time     `json:"last_used_at,omitempty"`
+	ExpiresAt        time.Time      `gorm:"not null" json:"expires_at"`
+	CreatedAt        time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// AudienceList returns the audiences granted to the session
+func (s *Session) AudienceList() []string {
+	return splitLines(s.Audience)
+}
+
+// SupersededRefreshToken remembers a refresh token that was rotated out of its
+// family, so a replay of it can be detected as token theft
+type SupersededRefreshToken struct {
+	RefreshTokenHash string    `gorm:"column:refresh_token;size:255;primaryKey" json:"-"`
+	FamilyID         string    `gorm:"column:family_id;size:36;not null;index" json:"family_id"`
+	UserID           string    `gorm:"column:user_id;size:36;not null" json:"user_id"`
+	ClientID         string    `gorm:"column:client_id;size:36;not null" json:"client_id"`
+	SupersededAt     time.Time `gorm:"not null" json:"superseded_at"`
+	ExpiresAt        time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// Security event types
+const (
+	SecurityEventRefreshTokenReuse      = "refresh_token_reuse"
+	SecurityEventAuthorizationCodeReuse = "authorization_code_reuse"
+	SecurityEventTokenExchange          = "token_exchange"
+	SecurityEventPasswordReset          = "password_reset"
+	SecurityEventEmailChanged           = "email_changed"
+	SecurityEventEmailChangeUndone      = "email_change_undone"
+	SecurityEventAccountDeleted         = "account_deleted"
+	SecurityEventAccountRestored        = "account_restored"
+	SecurityEventUserStatusChanged      = "user_status_changed"
+	SecurityEventPasswordResetForced    = "password_reset_forced"
+	SecurityEventSignInLockout          = "sign_in_lockout"
+	SecurityEventSignInUnlocked         = "sign_in_unlocked"
+)
+
+// SignInThrottle counts the recent failed password sign-ins of one account
+// or source address. While BlockedUntil is in the future, sign-in is refused
+// without checking the password.
+type SignInThrottle struct {
+	ThrottleKey   string     `gorm:"primaryKey;size:320" json:"throttle_key"` // "account:<identity pool>:<email>" or "ip:<address>"
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `gorm:"not null;index" json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+}
+
+// RateLimitBucket is a token bucket shared by every replica when
+// RATE_LIMIT_STORE=database
+type RateLimitBucket struct {
+	BucketKey  string    `gorm:"primaryKey;size:512" json:"bucket_key"` // method and the caller values the rule keys by
+	Tokens     float64   `gorm:"not null" json:"tokens"`
+	RefilledAt time.Time `gorm:"not null;index" json:"refilled_at"`
+}
+
+// SecurityEvent is an append-only audit record of security relevant activity
+type SecurityEvent struct {
+	EventID   string    `gorm:"column:event_id;primaryKey;size:36" json:"event_id"`
+	EventType string    `gorm:"size:50;not null;index" json:"event_type"`
+	UserID    string    `gorm:"column:user_id;size:36;index" json:"user_id"`
+	ClientID  string    `gorm:"column:client_id;size:36" json:"client_id"`
+	Details   string    `gorm:"type:text" json:"details"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// AuthorizationCode is a short-lived, single-use OAuth authorization code
+// bound to a PKCE challenge and redirect URI
+type AuthorizationCode struct {
+	CodeHash            string     `gorm:"column:code_hash;primaryKey;size:64" json:"-"`
+	ClientID            string     `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
+	UserID              string     `gorm:"column:user_id;size:36;not null;index" json:"user_id"`
+	RedirectURI         string     `gorm:"size:2000;not null" json:"redirect_uri"`
+	CodeChallenge       string     `gorm:"size:128;not null" json:"-"`
+	CodeChallengeMethod string     `gorm:"size:10;not null" json:"code_challenge_method"`
+	Scope               string     `gorm:"size:1000" json:"scope"`
+	Nonce               string     `gorm:"size:255" json:"-"` // OIDC nonce echoed in the ID token
+	AuthTime            time.Time  `gorm:"not null" json:"auth_time"`
+	ExpiresAt           time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt              *time.Time `json:"used_at,omitempty"`
+	SessionID           string     `gorm:"column:session_id;size:36" json:"session_id"` // session issued on exchange, revoked on replay
+	CreatedAt           time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Device authorization states
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+	DeviceAuthorizationConsumed = "consumed" // tokens were issued
+)
+
+// DeviceAuthorization is an OAuth device authorization grant (RFC 8628)
+// awaiting approval on a second device
+type DeviceAuthorization struct {
+	DeviceCodeHash string     `gorm:"column:device_code;primaryKey;size:64" json:"-"`
+	UserCode       string     `gorm:"size:16;not null;uniqueIndex" json:"user_code"`
+	ClientID       string     `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
+	Scope          string     `gorm:"size:1000" json:"scope"`
+	Status         string     `gorm:"size:20;not null" json:"status"`
+	UserID         string     `gorm:"column:user_id;size:36" json:"user_id"` // set once approved or denied
+	IntervalSecs   int        `gorm:"not null" json:"interval"`
+	LastPolledAt   *time.Time `json:"last_polled_at,omitempty"`
+	ExpiresAt      time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Role is a named set of permissions within a client. A role inherits every
+// permission of its parent, so a hierarchy such as admin > editor > viewer
+// grants each permission on the lowest role that needs it.
+type Role struct {
+	RoleID       string    `gorm:"column:role_id;primaryKey;size:36" json:"role_id"`
+	ClientID     string    `gorm:"column:client_id;size:36;not null;uniqueIndex:idx_roles_client_name" json:"client_id"`
+	Name         string    `gorm:"size:100;not null;uniqueIndex:idx_roles_client_name" json:"name"`
+	Description  string    `gorm:"size:500" json:"description"`
+	ParentRoleID string    `gorm:"column:parent_role_id;size:36;index" json:"parent_role_id"` // empty for a root role
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Permission is an action a client's services check, e.g. "orders:write"
+type Permission struct {
+	PermissionID string    `gorm:"column:permission_id;primaryKey;size:36" json:"permission_id"`
+	ClientID     string    `gorm:"column:client_id;size:36;not null;uniqueIndex:idx_permissions_client_name" json:"client_id"`
+	Name         string    `gorm:"size:100;not null;uniqueIndex:idx_permissions_client_name" json:"name"`
+	Description  string    `gorm:"size:500" json:"description"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	RoleID       string    `gorm:"column:role_id;primaryKey;size:36" json:"role_id"`
+	PermissionID string    `gorm:"column:permission_id;primaryKey;size:36;index" json:"permission_id"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// UserRole assigns a role to a user, either on every resource (empty
+// Resource) or on one resource or resource prefix such as "projects/*"
+type UserRole struct {
+	UserID    string    `gorm:"column:user_id;primaryKey;size:36" json:"user_id"`
+	RoleID    string    `gorm:"column:role_id;primaryKey;size:36;index" json:"role_id"`
+	Resource  string    `gorm:"primaryKey;size:255" json:"resource"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Organization membership roles, from most to least privileged
+const (
+	OrganizationRoleOwner  = "owner"  // manages members, including owners
+	OrganizationRoleAdmin  = "admin"  // manages admins and members
+	OrganizationRoleMember = "member" // no management rights
+)
+
+// Organization groups some of a client's users into a tenant, such as one
+// B2B customer of the client's application
+type Organization struct {
+	OrganizationID string    `gorm:"column:organization_id;primaryKey;size:36" json:"organization_id"`
+	ClientID       string    `gorm:"column:client_id;size:36;not null;index" json:"client_id"`
+	Name           string    `gorm:"size:100;not null" json:"name"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// OrganizationMember places a user in an organization. A user belongs to at
+// most one organization per client, which becomes the org_id claim of the
+// user's access tokens for that client; users of a shared identity pool can
+// join one in each of the pool's clients.
+type OrganizationMember struct {
+	UserID         string    `gorm:"column:user_id;primaryKey;size:36" json:"user_id"`
+	ClientID       string    `gorm:"column:client_id;primaryKey;size:36" json:"client_id"` // the organization's client
+	OrganizationID string    `gorm:"column:organization_id;size:36;not null;index" json:"organization_id"`
+	Role           string    `gorm:"size:20;not null" json:"role"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Invitation asks an email address to join an organization. The token is
+// mailed to the invitee and redeemed once, when registering.
+type Invitation struct {
+	InvitationID   string     `gorm:"column:invitation_id;primaryKey;size:36" json:"invitation_id"`
+	OrganizationID string     `gorm:"column:organization_id;size:36;not null;index" json:"organization_id"`
+	Email          string     `gorm:"size:255;not null" json:"email"`
+	Role           string     `gorm:"size:20;not null" json:"role"`
+	TokenHash      string     `gorm:"column:token_hash;size:64;not null;uniqueIndex" json:"-"` // keyed hash, see utils.HashToken
+	InvitedBy      string     `gorm:"column:invited_by;size:36" json:"invited_by"`             // user ID of the inviting owner or admin
+	ExpiresAt      time.Time  `gorm:"not null;index" json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// User token purposes
+const (
+	UserTokenEmailVerification = "email_verification"
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailChange       = "email_change"      // sent to the new address to confirm it
+	UserTokenEmailChangeUndo   = "email_change_undo" // sent to the old address to restore it
+)
+
+// UserToken is a single-use token mailed to a user, such as an email
+// verification or password reset link. It is bound to the user's address
+// when it was issued, so it stops working if the user's email changes.
+type UserToken struct {
+	TokenHash string     `gorm:"column:token_hash;primaryKey;size:64" json:"-"` // keyed hash, see utils.HashToken
+	UserID    string     `gorm:"column:user_id;size:36;not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:30;not null" json:"purpose"`
+	Email     string     `gorm:"size:255;not null" json:"email"`
+	NewEmail  string     `gorm:"size:255" json:"new_email"` // target address of an email change
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// SchemaMigration records one-shot data migrations that have been applied
+type SchemaMigration struct {
+	Name      string    `gorm:"primaryKey;size:100" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+// Signing key lifecycle states
+const (
+	SigningKeyStatusActive     = "active"      // signs new tokens and verifies
+	SigningKeyStatusVerifyOnly = "verify-only" // verifies until its grace period ends
+	SigningKeyStatusRetired    = "retired"     // no longer trusted
+)
+
+// SigningKey is one entry of the JWT key ring
+type SigningKey struct {
+	KeyID         string     `gorm:"column:key_id;primaryKey;size:64" json:"kid"`
+	Algorithm     string     `gorm:"size:10;not null" json:"alg"`
+	Status        string     `gorm:"size:20;not null;index" json:"status"`
+	PrivateKey    string     `gorm:"type:text" json:"-"` // PEM (or base64 secret for HS256), encrypted when SIGNING_KEY_ENCRYPTION_KEY is set; cleared on retirement
+	PublicKey     string     `gorm:"type:text" json:"public_key,omitempty"`
+	ActivatedAt   *time.Time `json:"activated_at,omitempty"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	VerifyUntil   *time.Time `json:"verify_until,omitempty"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
 func GetAllModels() []any {
 	return []any{
-		&Client{},  // Create clients table first (parent)
-		&User{},    // Then users table (references clients)
-		&Session{}, // Finally sessions table (references both users and clients)
+		&Client{},                 // Create clients table first (parent)
+		&User{},                   // Then users table (references clients)
+		&Session{},                // Then sessions table (references both users and clients)
+		&SigningKey{},             // JWT key ring (no dependencies)
+		&SupersededRefreshToken{}, // Rotated-out refresh tokens (reuse detection)
+		&SecurityEvent{},          // Security audit log
+		&SchemaMigration{},        // Applied one-shot data migrations
+		&AuthorizationCode{},      // OAuth authorization codes (references users and clients)
+		&DeviceAuthorization{},    // OAuth device authorization grants (references clients)
+		&Role{},                   // RBAC roles (references clients)
+		&Permission{},             // RBAC permissions (references clients)
+		&RolePermission{},         // Permissions granted to roles
+		&UserRole{},               // Roles assigned to users
+		&Organization{},           // Tenants within a client (references clients)
+		&OrganizationMember{},     // Organization membership (references users)
+		&Invitation{},             // Pending organization invitations
+		&UserToken{},              // Mailed single-use user tokens (references users)
+		&SignInThrottle{},         // Failed sign-in counters (no dependencies)
+		&RateLimitBucket{},        // Shared rate limit buckets (no dependencies)
+	}
+}
+
+// splitLines parses a newline separated list column, skipping blank entries
+func splitLines(value string) []string {
+	var values []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
 	}
+	return values
 }
@@ -0,0 +1,218 @@
+// Package ratelimit limits gRPC calls and HTTP requests with token buckets.
+// Rules set a budget per RPC method or HTTP path and choose whether it is charged to the calling client, the
+// caller's address, the user, or a combination. Buckets live in a Store, so a
+// shared store lets several replicas enforce one budget.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Dimensions a rule can key its buckets by
+const (
+	KeyClient = "client"
+	KeyIP     = "ip"
+	KeyUser   = "user"
+)
+
+// RetryAfterHeader is the response metadata telling a limited caller how many
+// seconds to wait
+const RetryAfterHeader = "retry-after"
+
+// Limit allows Requests calls per Per. Up to Requests calls may come at once;
+// the budget then refills evenly over Per.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rate is the number of tokens refilled per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Rule limits calls to a method
+type Rule struct {
+	Method string // a full method such as /auth.v1.AuthService/GetToken, an HTTP path such as /oauth/token, a bare method name, or "*" for every other method
+	Limit
+	KeyBy []string // KeyClient, KeyIP and/or KeyUser; callers sharing these values share a bucket
+}
+
+// Caller identifies who a call is charged to. Unknown fields are empty, and
+// all callers unknown in a dimension share its bucket.
+type Caller struct {
+	ClientID string
+	IP       string
+	UserID   string
+}
+
+// bucketKey names the bucket the rule charges the caller's call to
+func (r Rule) bucketKey(fullMethod string, caller Caller) string {
+	var key strings.Builder
+	key.WriteString(fullMethod)
+	for _, dimension := range r.KeyBy {
+		value := ""
+		switch dimension {
+		case KeyClient:
+			value = caller.ClientID
+		case KeyIP:
+			value = caller.IP
+		case KeyUser:
+			value = caller.UserID
+		}
+		key.WriteString("|" + dimension + "=" + value)
+	}
+	return key.String()
+}
+
+// ParseRules parses a comma separated list of rules written as
+// method=requests/period[@dimensions], e.g.
+//
+//	GetToken=5/15m@client+user,ValidateToken=1000/m@client,*=100/s@ip
+//
+// The period is a Go duration; a bare unit means one of it. Dimensions are
+// joined with "+" and default to client+ip+user.
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(entry string) (Rule, error) {
+	method, limit, ok := strings.Cut(entry, "=")
+	if !ok || method == "" {
+		return Rule{}, fmt.Errorf("expected method=requests/period")
+	}
+	limit, dimensions, hasDimensions := strings.Cut(limit, "@")
+	requests, period, ok := strings.Cut(limit, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected requests/period")
+	}
+
+	rule := Rule{Method: strings.TrimSpace(method), KeyBy: []string{KeyClient, KeyIP, KeyUser}}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Rule{}, fmt.Errorf("requests must be a positive integer")
+	}
+	rule.Requests = n
+	period = strings.TrimSpace(period)
+	if period != "" && !unicode.IsDigit(rune(period[0])) {
+		period = "1" + period
+	}
+	if rule.Per, err = time.ParseDuration(period); err != nil || rule.Per <= 0 {
+		return Rule{}, fmt.Errorf("period must be a positive duration")
+	}
+
+	if hasDimensions {
+		rule.KeyBy = nil
+		for _, dimension := range strings.Split(dimensions, "+") {
+			switch dimension = strings.TrimSpace(dimension); dimension {
+			case KeyClient, KeyIP, KeyUser:
+				rule.KeyBy = append(rule.KeyBy, dimension)
+			default:
+				return Rule{}, fmt.Errorf("unknown dimension %q", dimension)
+			}
+		}
+	}
+	return rule, nil
+}
+
+// Limiter applies rules to gRPC calls
+type Limiter struct {
+	store Store
+	rules map[string]Rule
+}
+
+// New returns a limiter keeping its buckets in store. When several rules
+// match a call, a full method beats a bare method name, which beats "*".
+func New(store Store, rules []Rule) *Limiter {
+	l := &Limiter{store: store, rules: make(map[string]Rule, len(rules))}
+	for _, rule := range rules {
+		l.rules[rule.Method] = rule
+	}
+	return l
+}
+
+// rule returns the rule limiting the method, if any
+func (l *Limiter) rule(fullMethod string) (Rule, bool) {
+	if rule, ok := l.rules[fullMethod]; ok {
+		return rule, true
+	}
+	if rule, ok := l.rules[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return rule, true
+	}
+	rule, ok := l.rules["*"]
+	return rule, ok
+}
+
+// take charges a call to the bucket its rule and caller pick and returns the
+// whole seconds to wait when it is over the limit, or 0 when it may proceed.
+// If the store fails, calls are let through: an outage of a shared store
+// should not take the service down with it.
+func (l *Limiter) take(ctx context.Context, method string, identify func() Caller) int {
+	rule, ok := l.rule(method)
+	if !ok {
+		return 0
+	}
+
+	retryAfter, err := l.store.Take(ctx, rule.bucketKey(method, identify()), rule.Limit)
+	if err != nil {
+		log.Printf("Error checking rate limit for %s: %v", method, err)
+		return 0
+	}
+	return int(math.Ceil(retryAfter.Seconds()))
+}
+
+// UnaryServerInterceptor charges every call to the bucket its rule and the
+// caller identify picks. Calls over the limit fail with ResourceExhausted and
+// a retry-after header.
+func (l *Limiter) UnaryServerInterceptor(identify func(ctx context.Context, req any) Caller) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		seconds := l.take(ctx, info.FullMethod, func() Caller { return identify(ctx, req) })
+		if seconds > 0 {
+			if err := grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.Itoa(seconds))); err != nil {
+				log.Printf("Error setting retry-after header: %v", err)
+			}
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded; retry after %d seconds", seconds)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// HTTPMiddleware charges every request to the bucket the rule for its path
+// and the caller identify picks. Requests over the limit fail with 429 Too
+// Many Requests and a Retry-After header.
+func (l *Limiter) HTTPMiddleware(next http.Handler, identify func(r *http.Request) Caller) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seconds := l.take(r.Context(), r.URL.Path, func() Caller { return identify(r) })
+		if seconds > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, fmt.Sprintf("rate limit exceeded; retry after %d seconds", seconds), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
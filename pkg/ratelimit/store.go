@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps token buckets. Take draws one token from the bucket at key,
+// creating it full if it does not exist. It returns zero when the call is
+// allowed, or how long until the bucket has a token again.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (time.Duration, error)
+}
+
+// Bucket is the state of one token bucket, for stores to persist
+type Bucket struct {
+	Tokens     float64
+	RefilledAt time.Time
+}
+
+// Take refills the bucket for the time since it was last refilled and
+// removes a token, or, when it is empty, returns how long until it has one
+func (b *Bucket) Take(limit Limit, now time.Time) time.Duration {
+	// Replica clocks may disagree; time never runs backwards for a bucket
+	if now.After(b.RefilledAt) {
+		b.Tokens = min(float64(limit.Requests), b.Tokens+now.Sub(b.RefilledAt).Seconds()*limit.rate())
+		b.RefilledAt = now
+	}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return 0
+	}
+	return time.Duration((1 - b.Tokens) / limit.rate() * float64(time.Second))
+}
+
+// memorySweepInterval is how many takes pass between sweeps of full buckets
+const memorySweepInterval = 10000
+
+// MemoryStore keeps buckets in process memory, so each replica enforces its
+// own budget
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	takes   int
+}
+
+type memoryBucket struct {
+	Bucket
+	limit Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.takes++; s.takes%memorySweepInterval == 0 {
+		s.sweep(now)
+	}
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{Bucket: Bucket{Tokens: float64(limit.Requests), RefilledAt: now}}
+		s.buckets[key] = bucket
+	}
+	bucket.limit = limit
+	return bucket.Take(limit, now), nil
+}
+
+// sweep drops buckets that have refilled completely; they behave exactly
+// like the new bucket Take would create in their place
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.RefilledAt) >= bucket.limit.Per {
+			delete(s.buckets, key)
+		}
+	}
+}
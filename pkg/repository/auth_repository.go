@@ -2,10 +2,17 @@ package repository
 
 import (
 	"authservice/pkg/models"
+	"authservice/pkg/ratelimit"
+	"authservice/pkg/utils"
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AuthRepository struct {
@@ -21,9 +28,10 @@ func (r *AuthRepository) CreateUser(ctx context.Context, user *models.User) erro
 	return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *AuthRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+// GetUserByEmail finds a user by email within an identity pool
+func (r *AuthRepository) GetUserByEmail(ctx context.Context, identityPoolID, email string) (*models.User, error) {
 	var user models.User
-	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	err := r.db.WithContext(ctx).Where("identity_pool_id = ? AND email_id = ?", identityPoolID, email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -39,10 +47,25 @@ func (r *AuthRepository) GetUserByID(ctx context.Context, userID string) (*model
 	return &user, nil
 }
 
+// ListUsersByIDs returns the users with the given IDs, in no particular order
+func (r *AuthRepository) ListUsersByIDs(ctx context.Context, userIDs []string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).Where("user_id IN ?", userIDs).Find(&users).Error
+	return users, err
+}
+
 func (r *AuthRepository) UpdateUser(ctx context.Context, user *models.User) error {
 	return r.db.WithContext(ctx).Save(user).Error
 }
 
+// UpdateUserProfile saves the user's self-service profile fields, leaving
+// credentials and account state untouched
+func (r *AuthRepository) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Model(user).
+		Select("user_name", "display_name", "locale", "avatar_url", "custom_attributes").
+		Updates(user).Error
+}
+
 func (r *AuthRepository) DeleteUser(ctx context.Context, userID string) error {
 	return r.db.WithContext(ctx).Delete(&models.User{}, "user_id = ?", userID).Error
 }
@@ -61,53 +84,144 @@ func (r *AuthRepository) GetClientByID(ctx context.Context, clientID string) (*m
 	return &client, nil
 }
 
+// ErrInvalidClientSecret is returned by ValidateClient when the secret does not match
+var ErrInvalidClientSecret = errors.New("invalid client secret")
+
+// ValidateClient loads the client and compares the secret's hash in constant time
 func (r *AuthRepository) ValidateClient(ctx context.Context, clientID, clientSecret string) (*models.Client, error) {
-	var client models.Client
-	err := r.db.WithContext(ctx).Where("client_id = ? AND client_secret = ?", clientID, clientSecret).First(&client).Error
+	client, err := r.GetClientByID(ctx, clientID)
 	if err != nil {
 		return nil, err
 	}
-	return &client, nil
+	// Public clients have no secret to present
+	if client.Public || !utils.TokenMatchesHash(clientSecret, client.ClientSecretHash) {
+		return nil, ErrInvalidClientSecret
+	}
+	return client, nil
+}
+
+// UpdateClientRedirectURIs replaces the client's registered redirect URIs
+func (r *AuthRepository) UpdateClientRedirectURIs(ctx context.Context, clientID string, redirectURIs []string) error {
+	return r.db.WithContext(ctx).Model(&models.Client{}).
+		Where("client_id = ?", clientID).
+		Update("redirect_uris", strings.Join(redirectURIs, "\n")).Error
 }
 
-// UpdateClientSecret updates the client's secret value
+// UpdateClientCustomAttributesSchema replaces the client's custom attributes schema
+func (r *AuthRepository) UpdateClientCustomAttributesSchema(ctx context.Context, clientID, schema string) error {
+	return r.db.WithContext(ctx).Model(&models.Client{}).
+		Where("client_id = ?", clientID).
+		Update("custom_attributes_schema", schema).Error
+}
+
+// UpdateClientSecret stores the hash of the client's new secret
 func (r *AuthRepository) UpdateClientSecret(ctx context.Context, clientID, newSecret string) error {
 	return r.db.WithContext(ctx).
 		Model(&models.Client{}).
 		Where("client_id = ?", clientID).
-		Update("client_secret", newSecret).Error
+		Update("client_secret", utils.HashToken(newSecret)).Error
 }
 
 // Session operations
 func (r *AuthRepository) CreateOrUpdateSession(ctx context.Context, session *models.Session) error {
-	// This will either create or update based on the composite primary key (UserId + ClientId)
+	// This will either create or update based on the session ID
+	if session.SessionID == "" {
+		session.SessionID = utils.GenerateUUID()
+	}
 	return r.db.WithContext(ctx).Save(session).Error
 }
 
+// CreateSessionWithLimit creates a new session and, when maxSessions is
+// positive, evicts the user's oldest sessions for the client so that at most
+// maxSessions remain
+func (r *AuthRepository) CreateSessionWithLimit(ctx context.Context, session *models.Session, maxSessions int) error {
+	if session.SessionID == "" {
+		session.SessionID = utils.GenerateUUID()
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if maxSessions > 0 {
+			var existing []models.Session
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ? AND client_id = ? AND expires_at > ?", session.UserID, session.ClientID, time.Now()).
+				Order("created_at ASC").
+				Find(&existing).Error; err != nil {
+				return err
+			}
+
+			if excess := len(existing) - maxSessions + 1; excess > 0 {
+				evicted := make([]string, 0, excess)
+				for _, old := range existing[:excess] {
+					evicted = append(evicted, old.SessionID)
+				}
+				if err := tx.Delete(&models.Session{}, "session_id IN ?", evicted).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Create(session).Error
+	})
+}
+
+// GetSessionByUserAndClient returns the most recently created active session for the pair
 func (r *AuthRepository) GetSessionByUserAndClient(ctx context.Context, userID, clientID string) (*models.Session, error) {
 	var session models.Session
-	err := r.db.WithContext(ctx).Where("user_id = ? AND client_id = ? AND expires_at > ?", userID, clientID, time.Now()).First(&session).Error
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND client_id = ? AND expires_at > ?", userID, clientID, time.Now()).
+		Order("created_at DESC").
+		First(&session).Error
 	if err != nil {
 		return nil, err
 	}
 	return &session, nil
 }
 
+func (r *AuthRepository) GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.WithContext(ctx).Where("session_id = ? AND expires_at > ?", sessionID, time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessionByRefreshToken looks the session up by the hash of the raw refresh token
 func (r *AuthRepository) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*models.Session, error) {
 	var session models.Session
-	err := r.db.WithContext(ctx).Where("refresh_token = ? AND expires_at > ?", refreshToken, time.Now()).First(&session).Error
+	err := r.db.WithContext(ctx).Where("refresh_token = ? AND expires_at > ?", utils.HashToken(refreshToken), time.Now()).First(&session).Error
 	if err != nil {
 		return nil, err
 	}
 	return &session, nil
 }
 
+// ListActiveSessionsByUser returns the user's unexpired sessions, most recently used first
+func (r *AuthRepository) ListActiveSessionsByUser(ctx context.Context, userID string) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC, created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// DeleteUserSession deletes one session, scoped to its owner; it returns the number of sessions removed
+func (r *AuthRepository) DeleteUserSession(ctx context.Context, userID, sessionID string) (int64, error) {
+	result := r.db.WithContext(ctx).Delete(&models.Session{}, "user_id = ? AND session_id = ?", userID, sessionID)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteOtherUserSessions deletes every session of the user except keepSessionID
+func (r *AuthRepository) DeleteOtherUserSessions(ctx context.Context, userID, keepSessionID string) (int64, error) {
+	result := r.db.WithContext(ctx).Delete(&models.Session{}, "user_id = ? AND session_id <> ?", userID, keepSessionID)
+	return result.RowsAffected, result.Error
+}
+
 func (r *AuthRepository) DeleteSessionByUserAndClient(ctx context.Context, userID, clientID string) error {
 	return r.db.WithContext(ctx).Delete(&models.Session{}, "user_id = ? AND client_id = ?", userID, clientID).Error
 }
 
 func (r *AuthRepository) DeleteSessionByRefreshToken(ctx context.Context, refreshToken string) error {
-	return r.db.WithContext(ctx).Delete(&models.Session{}, "refresh_token = ?", refreshToken).Error
+	return r.db.WithContext(ctx).Delete(&models.Session{}, "refresh_token = ?", utils.HashToken(refreshToken)).Error
 }
 
 func (r *AuthRepository) DeleteAllUserSessions(ctx context.Context, userID string) error {
@@ -118,10 +232,1132 @@ func (r *AuthRepository) DeleteExpiredSessions(ctx context.Context) error {
 	return r.db.WithContext(ctx).Delete(&models.Session{}, "expires_at < ?", time.Now()).Error
 }
 
+// ErrRefreshTokenNotCurrent is returned when a refresh token was rotated by a
+// concurrent request between lookup and rotation
+var ErrRefreshTokenNotCurrent = errors.New("refresh token is no longer current")
+
+// RotateRefreshToken replaces the session's current refresh token and records
+// the old one as superseded within its token family
+func (r *AuthRepository) RotateRefreshToken(ctx context.Context, session *models.Session, newRefreshToken string, expiresAt time.Time) error {
+	oldRefreshTokenHash := session.RefreshTokenHash
+	newRefreshTokenHash := utils.HashToken(newRefreshToken)
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Session{}).
+			Where("session_id = ? AND refresh_token = ?", session.SessionID, oldRefreshTokenHash).
+			Updates(map[string]interface{}{
+				"refresh_token": newRefreshTokenHash,
+				"family_id":     session.FamilyID,
+				"ip_address":    session.IPAddress,
+				"last_used_at":  session.LastUsedAt,
+				"expires_at":    expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenNotCurrent
+		}
+
+		if err := tx.Create(&models.SupersededRefreshToken{
+			RefreshTokenHash: oldRefreshTokenHash,
+			FamilyID:         session.FamilyID,
+			UserID:           session.UserID,
+			ClientID:         session.ClientID,
+			SupersededAt:     time.Now(),
+			ExpiresAt:        expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		session.RefreshTokenHash = newRefreshTokenHash
+		session.ExpiresAt = expiresAt
+		return nil
+	})
+}
+
+// Refresh token family operations
+func (r *AuthRepository) GetSupersededRefreshToken(ctx context.Context, refreshToken string) (*models.SupersededRefreshToken, error) {
+	var superseded models.SupersededRefreshToken
+	err := r.db.WithContext(ctx).Where("refresh_token = ?", utils.HashToken(refreshToken)).First(&superseded).Error
+	if err != nil {
+		return nil, err
+	}
+	return &superseded, nil
+}
+
+// RevokeTokenFamily deletes every session belonging to the token family
+func (r *AuthRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return nil
+	}
+	return r.db.WithContext(ctx).Delete(&models.Session{}, "family_id = ?", familyID).Error
+}
+
+func (r *AuthRepository) DeleteExpiredSupersededRefreshTokens(ctx context.Context) error {
+	return r.db.WithContext(ctx).Delete(&models.SupersededRefreshToken{}, "expires_at < ?", time.Now()).Error
+}
+
+// Authorization code operations
+func (r *AuthRepository) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	return r.db.WithContext(ctx).Create(code).Error
+}
+
+// ErrAuthorizationCodeUsed is returned when an authorization code is presented
+// a second time; the returned record identifies the session to revoke
+var ErrAuthorizationCodeUsed = errors.New("authorization code already used")
+
+// ConsumeAuthorizationCode looks up an unexpired code and marks it used in a
+// single conditional update, so concurrent exchanges cannot both succeed
+func (r *AuthRepository) ConsumeAuthorizationCode(ctx context.Context, code string) (*models.AuthorizationCode, error) {
+	var authCode models.AuthorizationCode
+	codeHash := utils.HashToken(code)
+	if err := r.db.WithContext(ctx).Where("code_hash = ? AND expires_at > ?", codeHash, time.Now()).First(&authCode).Error; err != nil {
+		return nil, err
+	}
+	if authCode.UsedAt != nil {
+		return &authCode, ErrAuthorizationCodeUsed
+	}
+
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.AuthorizationCode{}).
+		Where("code_hash = ? AND used_at IS NULL", codeHash).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &authCode, ErrAuthorizationCodeUsed
+	}
+	authCode.UsedAt = &now
+	return &authCode, nil
+}
+
+// SetAuthorizationCodeSession remembers which session a code was exchanged for
+func (r *AuthRepository) SetAuthorizationCodeSession(ctx context.Context, codeHash, sessionID string) error {
+	return r.db.WithContext(ctx).Model(&models.AuthorizationCode{}).
+		Where("code_hash = ?", codeHash).
+		Update("session_id", sessionID).Error
+}
+
+func (r *AuthRepository) DeleteExpiredAuthorizationCodes(ctx context.Context) error {
+	return r.db.WithContext(ctx).Delete(&models.AuthorizationCode{}, "expires_at < ?", time.Now()).Error
+}
+
+// Device authorization operations
+func (r *AuthRepository) CreateDeviceAuthorization(ctx context.Context, authorization *models.DeviceAuthorization) error {
+	return r.db.WithContext(ctx).Create(authorization).Error
+}
+
+func (r *AuthRepository) GetDeviceAuthorizationByDeviceCode(ctx context.Context, deviceCode string) (*models.DeviceAuthorization, error) {
+	var authorization models.DeviceAuthorization
+	err := r.db.WithContext(ctx).Where("device_code = ?", utils.HashToken(deviceCode)).First(&authorization).Error
+	if err != nil {
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+// GetPendingDeviceAuthorization finds an unexpired grant still awaiting the user's decision
+func (r *AuthRepository) GetPendingDeviceAuthorization(ctx context.Context, userCode string) (*models.DeviceAuthorization, error) {
+	var authorization models.DeviceAuthorization
+	err := r.db.WithContext(ctx).
+		Where("user_code = ? AND status = ? AND expires_at > ?", userCode, models.DeviceAuthorizationPending, time.Now()).
+		First(&authorization).Error
+	if err != nil {
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+// RecordDevicePoll stores the poll time and the (possibly slowed down) interval
+func (r *AuthRepository) RecordDevicePoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, intervalSecs int) error {
+	return r.db.WithContext(ctx).Model(&models.DeviceAuthorization{}).
+		Where("device_code = ?", deviceCodeHash).
+		Updates(map[string]interface{}{"last_polled_at": polledAt, "interval_secs": intervalSecs}).Error
+}
+
+// ResolveDeviceAuthorization records the user's decision on a pending grant.
+// It reports false when the grant was no longer pending.
+func (r *AuthRepository) ResolveDeviceAuthorization(ctx context.Context, deviceCodeHash, userID, status string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.DeviceAuthorization{}).
+		Where("device_code = ? AND status = ?", deviceCodeHash, models.DeviceAuthorizationPending).
+		Updates(map[string]interface{}{"status": status, "user_id": userID})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ConsumeDeviceAuthorization marks an approved grant as used; only one poll
+// can win, so tokens are issued once
+func (r *AuthRepository) ConsumeDeviceAuthorization(ctx context.Context, deviceCodeHash string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.DeviceAuthorization{}).
+		Where("device_code = ? AND status = ?", deviceCodeHash, models.DeviceAuthorizationApproved).
+		Update("status", models.DeviceAuthorizationConsumed)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *AuthRepository) DeleteExpiredDeviceAuthorizations(ctx context.Context) error {
+	return r.db.WithContext(ctx).Delete(&models.DeviceAuthorization{}, "expires_at < ?", time.Now()).Error
+}
+
+// RBAC operations
+
+// ErrRoleHasChildren is returned when deleting a role other roles inherit from
+var ErrRoleHasChildren = errors.New("role has child roles")
+
+func (r *AuthRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	return r.db.WithContext(ctx).Create(role).Error
+}
+
+func (r *AuthRepository) GetRoleByID(ctx context.Context, roleID string) (*models.Role, error) {
+	var role models.Role
+	err := r.db.WithContext(ctx).Where("role_id = ?", roleID).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *AuthRepository) UpdateRole(ctx context.Context, role *models.Role) error {
+	return r.db.WithContext(ctx).Save(role).Error
+}
+
+// DeleteRole removes a role with its grants and assignments; roles that
+// inherit from it must be re-parented or deleted first
+func (r *AuthRepository) DeleteRole(ctx context.Context, roleID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var children int64
+		if err := tx.Model(&models.Role{}).Where("parent_role_id = ?", roleID).Count(&children).Error; err != nil {
+			return err
+		}
+		if children > 0 {
+			return ErrRoleHasChildren
+		}
+		if err := tx.Delete(&models.RolePermission{}, "role_id = ?", roleID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.UserRole{}, "role_id = ?", roleID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Role{}, "role_id = ?", roleID).Error
+	})
+}
+
+func (r *AuthRepository) ListRolesByClient(ctx context.Context, clientID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.WithContext(ctx).Where("client_id = ?", clientID).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *AuthRepository) CreatePermission(ctx context.Context, permission *models.Permission) error {
+	return r.db.WithContext(ctx).Create(permission).Error
+}
+
+func (r *AuthRepository) GetPermissionByID(ctx context.Context, permissionID string) (*models.Permission, error) {
+	var permission models.Permission
+	err := r.db.WithContext(ctx).Where("permission_id = ?", permissionID).First(&permission).Error
+	if err != nil {
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// DeletePermission removes a permission and revokes it from every role
+func (r *AuthRepository) DeletePermission(ctx context.Context, permissionID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.RolePermission{}, "permission_id = ?", permissionID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Permission{}, "permission_id = ?", permissionID).Error
+	})
+}
+
+func (r *AuthRepository) ListPermissionsByClient(ctx context.Context, clientID string) ([]models.Permission, error) {
+	var permissions []models.Permission
+	err := r.db.WithContext(ctx).Where("client_id = ?", clientID).Order("name").Find(&permissions).Error
+	return permissions, err
+}
+
+// GrantPermission grants a permission to a role; granting it twice is a no-op
+func (r *AuthRepository) GrantPermission(ctx context.Context, roleID, permissionID string) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RolePermission{RoleID: roleID, PermissionID: permissionID}).Error
+}
+
+func (r *AuthRepository) RevokePermission(ctx context.Context, roleID, permissionID string) (int64, error) {
+	result := r.db.WithContext(ctx).Delete(&models.RolePermission{}, "role_id = ? AND permission_id = ?", roleID, permissionID)
+	return result.RowsAffected, result.Error
+}
+
+// ListRolePermissionNames returns the names of the permissions granted
+// directly to each of the given roles, keyed by role ID
+func (r *AuthRepository) ListRolePermissionNames(ctx context.Context, roleIDs []string) (map[string][]string, error) {
+	var rows []struct {
+		RoleID string
+		Name   string
+	}
+	err := r.db.WithContext(ctx).Table("role_permissions").
+		Select("role_permissions.role_id, permissions.name").
+		Joins("JOIN permissions ON permissions.permission_id = role_permissions.permission_id").
+		Where("role_permissions.role_id IN ?", roleIDs).
+		Order("permissions.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string][]string, len(roleIDs))
+	for _, row := range rows {
+		names[row.RoleID] = append(names[row.RoleID], row.Name)
+	}
+	return names, nil
+}
+
+// AssignRole assigns a role to a user; assigning it twice is a no-op
+func (r *AuthRepository) AssignRole(ctx context.Context, assignment *models.UserRole) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(assignment).Error
+}
+
+func (r *AuthRepository) UnassignRole(ctx context.Context, userID, roleID, resource string) (int64, error) {
+	result := r.db.WithContext(ctx).Delete(&models.UserRole{}, "user_id = ? AND role_id = ? AND resource = ?", userID, roleID, resource)
+	return result.RowsAffected, result.Error
+}
+
+func (r *AuthRepository) ListUserRoles(ctx context.Context, userID string) ([]models.UserRole, error) {
+	var assignments []models.UserRole
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&assignments).Error
+	return assignments, err
+}
+
+// Organization operations
+
+// ErrLastOwner is returned when a change would leave an organization without an owner
+var ErrLastOwner = errors.New("organization must keep at least one owner")
+
+// ErrInvitationUsed is returned when an invitation is redeemed a second time
+var ErrInvitationUsed = errors.New("invitation already accepted")
+
+// CreateOrganization creates an organization and, when owner is not nil, its first member
+func (r *AuthRepository) CreateOrganization(ctx context.Context, org *models.Organization, owner *models.OrganizationMember) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		if owner == nil {
+			return nil
+		}
+		return tx.Create(owner).Error
+	})
+}
+
+func (r *AuthRepository) GetOrganizationByID(ctx context.Context, organizationID string) (*models.Organization, error) {
+	var org models.Organization
+	err := r.db.WithContext(ctx).Where("organization_id = ?", organizationID).First(&org).Error
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (r *AuthRepository) ListOrganizationsByClient(ctx context.Context, clientID string) ([]models.Organization, error) {
+	var orgs []models.Organization
+	err := r.db.WithContext(ctx).Where("client_id = ?", clientID).Order("name").Find(&orgs).Error
+	return orgs, err
+}
+
+// GetOrganizationMember returns the user's membership among the client's
+// organizations; users belong to at most one organization per client
+func (r *AuthRepository) GetOrganizationMember(ctx context.Context, userID, clientID string) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	err := r.db.WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// AddOrganizationMember fails if the user already belongs to an organization
+// of the member's client
+func (r *AuthRepository) AddOrganizationMember(ctx context.Context, member *models.OrganizationMember) error {
+	return r.db.WithContext(ctx).Create(member).Error
+}
+
+func (r *AuthRepository) ListOrganizationMembers(ctx context.Context, organizationID string) ([]models.OrganizationMember, error) {
+	var members []models.OrganizationMember
+	err := r.db.WithContext(ctx).Where("organization_id = ?", organizationID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// UpdateOrganizationMemberRole changes a member's role, refusing to demote
+// the organization's last owner; it returns the number of members updated
+func (r *AuthRepository) UpdateOrganizationMemberRole(ctx context.Context, organizationID, userID, role string) (int64, error) {
+	var updated int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if role != models.OrganizationRoleOwner {
+			if err := ensureOtherOwner(tx, organizationID, userID); err != nil {
+				return err
+			}
+		}
+		result := tx.Model(&models.OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ?", organizationID, userID).
+			Update("role", role)
+		updated = result.RowsAffected
+		return result.Error
+	})
+	return updated, err
+}
+
+// RemoveOrganizationMember removes a member, refusing to remove the
+// organization's last owner; it returns the number of members removed
+func (r *AuthRepository) RemoveOrganizationMember(ctx context.Context, organizationID, userID string) (int64, error) {
+	var removed int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ensureOtherOwner(tx, organizationID, userID); err != nil {
+			return err
+		}
+		result := tx.Delete(&models.OrganizationMember{}, "organization_id = ? AND user_id = ?", organizationID, userID)
+		removed = result.RowsAffected
+		return result.Error
+	})
+	return removed, err
+}
+
+// ensureOtherOwner returns ErrLastOwner when userID is the organization's only owner
+func ensureOtherOwner(tx *gorm.DB, organizationID, userID string) error {
+	var owners []models.OrganizationMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND role = ?", organizationID, models.OrganizationRoleOwner).
+		Find(&owners).Error
+	if err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0].UserID == userID {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (r *AuthRepository) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	return r.db.WithContext(ctx).Create(invitation).Error
+}
+
+func (r *AuthRepository) DeleteInvitation(ctx context.Context, invitationID string) error {
+	return r.db.WithContext(ctx).Delete(&models.Invitation{}, "invitation_id = ?", invitationID).Error
+}
+
+// GetPendingInvitation finds an unexpired, unaccepted invitation by its raw token
+func (r *AuthRepository) GetPendingInvitation(ctx context.Context, token string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// CreateUserFromInvitation creates the user and adds them to the inviting
+// organization, marking the invitation accepted in the same transaction so
+// it can only be redeemed once
+func (r *AuthRepository) CreateUserFromInvitation(ctx context.Context, user *models.User, invitation *models.Invitation) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Invitation{}).
+			Where("invitation_id = ? AND accepted_at IS NULL", invitation.InvitationID).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvitationUsed
+		}
+		var org models.Organization
+		if err := tx.Where("organization_id = ?", invitation.OrganizationID).First(&org).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{
+			UserID:         user.UserID,
+			ClientID:       org.ClientID,
+			OrganizationID: org.OrganizationID,
+			Role:           invitation.Role,
+		}).Error
+	})
+}
+
+func (r *AuthRepository) DeleteExpiredInvitations(ctx context.Context) error {
+	return r.db.WithContext(ctx).Delete(&models.Invitation{}, "expires_at < ?", time.Now()).Error
+}
+
+// User token operations
+
+// ErrUserTokenInvalid is returned when a mailed token is unknown, expired,
+// already used or no longer matches the user's email address
+var ErrUserTokenInvalid = errors.New("invalid or expired token")
+
+// CreateUserToken stores a new token and discards the user's unused tokens
+// for the same purpose, so only the most recently mailed one works
+func (r *AuthRepository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.UserToken{}, "user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// VerifyUserEmail redeems an email verification token and marks the address
+// it was sent to as verified; it returns the ID of the verified user
+func (r *AuthRepository) VerifyUserEmail(ctx context.Context, token string) (string, error) {
+	var userID string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, models.UserTokenEmailVerification, token)
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.User{}).
+			Where("user_id = ? AND email_id = ?", userToken.UserID, userToken.Email).
+			Updates(emailVerifiedUpdates())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserTokenInvalid
+		}
+		userID = userToken.UserID
+		return nil
+	})
+	return userID, err
+}
+
+// ResetUserPassword redeems a password reset token, replaces the password
+// and revokes every session of the user in one transaction; it returns the
+// ID of the user. Receiving the token proves control of the address, so the
+// email is marked verified as well.
+func (r *AuthRepository) ResetUserPassword(ctx context.Context, token, hashedPassword string) (string, error) {
+	var userID string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, models.UserTokenPasswordReset, token)
+		if err != nil {
+			return err
+		}
+		updates := emailVerifiedUpdates()
+		updates["password"] = hashedPassword
+		result := tx.Model(&models.User{}).
+			Where("user_id = ? AND email_id = ?", userToken.UserID, userToken.Email).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserTokenInvalid
+		}
+		if err := tx.Delete(&models.Session{}, "user_id = ?", userToken.UserID).Error; err != nil {
+			return err
+		}
+		userID = userToken.UserID
+		return nil
+	})
+	return userID, err
+}
+
+// ErrEmailTaken is returned when an email change would give the user an
+// address another user of the identity pool already has
+var ErrEmailTaken = errors.New("email already registered")
+
+// CreateEmailChangeTokens stores the confirmation and undo tokens of an email
+// change. The confirmation replaces any pending change; earlier undo tokens
+// are kept, so every address the account had can still be restored.
+func (r *AuthRepository) CreateEmailChangeTokens(ctx context.Context, confirmation, undo *models.UserToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.UserToken{}, "user_id = ? AND purpose = ? AND used_at IS NULL", confirmation.UserID, models.UserTokenEmailChange).Error; err != nil {
+			return err
+		}
+		return tx.Create([]*models.UserToken{confirmation, undo}).Error
+	})
+}
+
+// ConfirmEmailChange redeems an email change token and moves the user to the
+// new address, which becomes the verified one. It fails if the user's email
+// changed since the token was issued or the new address was taken meanwhile.
+func (r *AuthRepository) ConfirmEmailChange(ctx context.Context, token string) (*models.UserToken, error) {
+	var userToken *models.UserToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		userToken, err = consumeUserToken(tx, models.UserTokenEmailChange, token)
+		if err != nil {
+			return err
+		}
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "user_id = ?", userToken.UserID).Error; err != nil {
+			return err
+		}
+		if user.Email != userToken.Email {
+			return ErrUserTokenInvalid
+		}
+		return changeUserEmail(tx, &user, userToken.NewEmail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userToken, nil
+}
+
+// UndoEmailChange redeems an undo token, sent to the address an email change
+// moved away from, and restores that address whatever the user's email is
+// now. Pending changes, newer undo tokens and every session of the user are
+// revoked; older undo tokens stay valid so the original owner can still roll
+// back further.
+func (r *AuthRepository) UndoEmailChange(ctx context.Context, token string) (*models.UserToken, error) {
+	var userToken *models.UserToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		userToken, err = consumeUserToken(tx, models.UserTokenEmailChangeUndo, token)
+		if err != nil {
+			return err
+		}
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "user_id = ?", userToken.UserID).Error; err != nil {
+			return err
+		}
+		if user.Email != userToken.Email {
+			if err := changeUserEmail(tx, &user, userToken.Email); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.UserToken{}, "user_id = ? AND used_at IS NULL AND (purpose = ? OR (purpose = ? AND created_at >= ?))",
+			user.UserID, models.UserTokenEmailChange, models.UserTokenEmailChangeUndo, userToken.CreatedAt).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Session{}, "user_id = ?", user.UserID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userToken, nil
+}
+
+// changeUserEmail moves the user to a verified email, keeping emails unique
+// within the identity pool
+func changeUserEmail(tx *gorm.DB, user *models.User, email string) error {
+	var taken int64
+	if err := tx.Unscoped().Model(&models.User{}).
+		Where("identity_pool_id = ? AND email_id = ? AND user_id <> ?", user.IdentityPoolID, email, user.UserID).
+		Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrEmailTaken
+	}
+	updates := emailVerifiedUpdates()
+	updates["email_id"] = email
+	return tx.Model(user).Updates(updates).Error
+}
+
+// emailVerifiedUpdates marks a user's email verified, activating the user if
+// they were waiting for it
+func emailVerifiedUpdates() map[string]any {
+	return map[string]any{
+		"email_verified": true,
+		"status": gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END",
+			models.UserStatusPendingVerification, models.UserStatusActive),
+	}
+}
+
+// consumeUserToken marks an unexpired, unused token of the purpose used in a
+// single conditional update, so concurrent redemptions cannot both succeed
+func consumeUserToken(tx *gorm.DB, purpose, token string) (*models.UserToken, error) {
+	var userToken models.UserToken
+	tokenHash := utils.HashToken(token)
+	err := tx.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()).
+		First(&userToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserTokenInvalid
+		}
+		return nil, err
+	}
+
+	result := tx.Model(&models.UserToken{}).
+		Where("token_hash = ? AND used_at IS NULL", tokenHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUserTokenInvalid
+	}
+	return &userToken, nil
+}
+
+func (r *AuthRepository) DeleteExpiredUserTokens(ctx context.Context) error {
+	return r.db.WithContext(ctx).Delete(&models.UserToken{}, "expires_at < ?", time.Now()).Error
+}
+
+// Account deletion operations
+
+// DeleteUserAccount soft-deletes the user, which blocks sign-in, and revokes
+// every session and pending emailed token in one transaction. The rest of the
+// user's data stays until PurgeDeletedUsers, so an admin can still restore the
+// account. Unless allowLastOwner is set, the last owner of any organization
+// cannot be deleted.
+func (r *AuthRepository) DeleteUserAccount(ctx context.Context, userID string, allowLastOwner bool) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if !allowLastOwner {
+			var memberships []models.OrganizationMember
+			if err := tx.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
+				return err
+			}
+			for _, member := range memberships {
+				if err := ensureOtherOwner(tx, member.OrganizationID, userID); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, model := range []any{&models.Session{}, &models.SupersededRefreshToken{}, &models.UserToken{}} {
+			if err := tx.Unscoped().Delete(model, "user_id = ?", userID).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Delete(&models.User{}, "user_id = ?", userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// RestoreUser undoes DeleteUserAccount for a user that was not purged yet;
+// it returns the number of users restored
+func (r *AuthRepository) RestoreUser(ctx context.Context, userID string) (int64, error) {
+	result := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Update("deleted_at", nil)
+	return result.RowsAffected, result.Error
+}
+
+// PurgeDeletedUsers erases users deleted before the cutoff together with
+// every record that refers to them; it returns the number of users purged.
+// A user that cannot be purged does not hold up the others: their errors are
+// joined and returned once every user has been tried.
+func (r *AuthRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var userIDs []string
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	var errs []error
+	for _, userID := range userIDs {
+		if err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return purgeUser(tx, userID)
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("purging user %s: %w", userID, err))
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(errs...)
+}
+
+// emailAddressPattern matches the email addresses security event details
+// may mention
+var emailAddressPattern = regexp.MustCompile(`[^\s@]+@[^\s@;,]+`)
+
+// purgeUser hard-deletes a user and the records referring to them. Invitations
+// the user sent belong to their organization, so only the sender is cleared.
+// Security events stay in the audit log, anonymized: they lose the user ID and
+// any email address in their details.
+func purgeUser(tx *gorm.DB, userID string) error {
+	for _, model := range []any{
+		&models.Session{},
+		&models.SupersededRefreshToken{},
+		&models.AuthorizationCode{},
+		&models.DeviceAuthorization{},
+		&models.UserRole{},
+		&models.OrganizationMember{},
+		&models.UserToken{},
+	} {
+		if err := tx.Unscoped().Delete(model, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+	}
+
+	var events []models.SecurityEvent
+	if err := tx.Where("user_id = ?", userID).Find(&events).Error; err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := tx.Model(&models.SecurityEvent{}).Where("event_id = ?", event.EventID).Updates(map[string]any{
+			"user_id": "",
+			"details": emailAddressPattern.ReplaceAllString(event.Details, "[removed]"),
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.Invitation{}).Where("invited_by = ?", userID).Update("invited_by", "").Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&models.User{}, "user_id = ?", userID).Error
+}
+
+// UserData is everything stored about a user, as exported to them
+type UserData struct {
+	User                    *models.User                    `json:"user"`
+	Sessions                []models.Session                `json:"sessions"`
+	OrganizationMemberships []models.OrganizationMember     `json:"organization_memberships"`
+	Roles                   []models.UserRole               `json:"roles"`
+	InvitationsSent         []models.Invitation             `json:"invitations_sent"`
+	PendingTokens           []models.UserToken              `json:"pending_tokens"`
+	AuthorizationCodes      []models.AuthorizationCode      `json:"authorization_codes"`
+	DeviceAuthorizations    []models.DeviceAuthorization    `json:"device_authorizations"`
+	SupersededTokens        []models.SupersededRefreshToken `json:"superseded_refresh_tokens"`
+	SecurityEvents          []models.SecurityEvent          `json:"security_events"`
+}
+
+// GetUserData collects the user's data for export, including revoked
+// sessions that are still stored. Secrets such as password and token hashes
+// are left out by the models' JSON encoding.
+func (r *AuthRepository) GetUserData(ctx context.Context, userID string) (*UserData, error) {
+	db := r.db.WithContext(ctx)
+	data := &UserData{}
+
+	var user models.User
+	if err := db.Where("user_id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	data.User = &user
+
+	for _, query := range []struct {
+		dest   any
+		column string
+		order  string
+	}{
+		{&data.Sessions, "user_id", "created_at"},
+		{&data.OrganizationMemberships, "user_id", "created_at"},
+		{&data.Roles, "user_id", "created_at"},
+		{&data.InvitationsSent, "invited_by", "created_at"},
+		{&data.PendingTokens, "user_id", "created_at"},
+		{&data.AuthorizationCodes, "user_id", "created_at"},
+		{&data.DeviceAuthorizations, "user_id", "created_at"},
+		{&data.SupersededTokens, "user_id", "superseded_at"},
+		{&data.SecurityEvents, "user_id", "created_at"},
+	} {
+		if err := db.Unscoped().Where(query.column+" = ?", userID).Order(query.order).Find(query.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Admin user operations
+
+// UserStatusDeleted selects deleted users awaiting purge in a UserFilter
+const UserStatusDeleted = "deleted"
+
+// UserFilter selects the users of an identity pool; zero fields match everything
+type UserFilter struct {
+	IdentityPoolID string
+	EmailPrefix    string
+	CreatedAfter   time.Time // inclusive
+	CreatedBefore  time.Time // exclusive
+	Status         string    // a models.UserStatus value or UserStatusDeleted; empty matches all but deleted users
+}
+
+// UserCursor is the position of the last user of a ListUsers page
+type UserCursor struct {
+	CreatedAt time.Time
+	UserID    string
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, using ! as the escape
+// character because it needs no quoting in MySQL or SQLite
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// ListUsers returns up to limit users matching the filter, newest first,
+// starting after the cursor when it is not nil
+func (r *AuthRepository) ListUsers(ctx context.Context, filter UserFilter, after *UserCursor, limit int) ([]models.User, error) {
+	query := r.db.WithContext(ctx).Where("identity_pool_id = ?", filter.IdentityPoolID)
+	switch filter.Status {
+	case "":
+	case UserStatusDeleted:
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	default:
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.EmailPrefix != "" {
+		query = query.Where("email_id LIKE ? ESCAPE '!'", likeEscaper.Replace(filter.EmailPrefix)+"%")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+	if after != nil {
+		query = query.Where("created_at < ? OR (created_at = ? AND user_id < ?)", after.CreatedAt, after.CreatedAt, after.UserID)
+	}
+
+	var users []models.User
+	err := query.Order("created_at DESC, user_id DESC").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+// GetUserIncludingDeleted finds a user by ID, including one deleted but not purged yet
+func (r *AuthRepository) GetUserIncludingDeleted(ctx context.Context, userID string) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Unscoped().Where("user_id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserStatus changes the user's status; lockedUntil only applies to the
+// locked status. Any status but active also revokes every session of the user
+// in the same transaction. It returns the number of users updated.
+func (r *AuthRepository) SetUserStatus(ctx context.Context, userID, status, reason string, lockedUntil *time.Time) (int64, error) {
+	var updated int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("user_id = ?", userID).Updates(map[string]any{
+			"status":            status,
+			"status_reason":     reason,
+			"status_changed_at": time.Now(),
+			"locked_until":      lockedUntil,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		updated = result.RowsAffected
+		if updated == 0 || status == models.UserStatusActive {
+			return nil
+		}
+		return tx.Delete(&models.Session{}, "user_id = ?", userID).Error
+	})
+	return updated, err
+}
+
+// UnlockExpiredUsers returns users whose lock has ended to active, so
+// listings show their effective status; it returns the number of users unlocked
+func (r *AuthRepository) UnlockExpiredUsers(ctx context.Context) (int64, error) {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("status = ? AND locked_until IS NOT NULL AND locked_until <= ?", models.UserStatusLocked, now).
+		Updates(map[string]any{"status": models.UserStatusActive, "status_reason": "", "status_changed_at": now, "locked_until": nil})
+	return result.RowsAffected, result.Error
+}
+
+// ForcePasswordReset replaces the user's password with an unusable hash and
+// revokes every session of the user, so only a password reset lets them back in
+func (r *AuthRepository) ForcePasswordReset(ctx context.Context, userID, unusableHash string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("user_id = ?", userID).Update("password", unusableHash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Delete(&models.Session{}, "user_id = ?", userID).Error
+	})
+}
+
+// Sign-in throttle operations
+
+// GetActiveSignInThrottles returns the throttles among keys that currently block sign-in
+func (r *AuthRepository) GetActiveSignInThrottles(ctx context.Context, keys []string) ([]models.SignInThrottle, error) {
+	var throttles []models.SignInThrottle
+	err := r.db.WithContext(ctx).
+		Where("throttle_key IN ? AND blocked_until > ?", keys, time.Now()).
+		Find(&throttles).Error
+	return throttles, err
+}
+
+// RecordSignInFailure counts a failed sign-in against the key and blocks it
+// for the delay backoff gives for the new count. Failures older than memory
+// are forgotten first. It returns the updated throttle.
+func (r *AuthRepository) RecordSignInFailure(ctx context.Context, key string, memory time.Duration, backoff func(failures int) time.Duration) (*models.SignInThrottle, error) {
+	var throttle models.SignInThrottle
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.SignInThrottle{ThrottleKey: key, LastFailureAt: now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&throttle, "throttle_key = ?", key).Error; err != nil {
+			return err
+		}
+
+		if now.Sub(throttle.LastFailureAt) > memory {
+			throttle.Failures = 0
+		}
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		throttle.BlockedUntil = nil
+		if delay := backoff(throttle.Failures); delay > 0 {
+			blockedUntil := now.Add(delay)
+			throttle.BlockedUntil = &blockedUntil
+		}
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// ClearSignInThrottle forgets the failures counted against the key
+func (r *AuthRepository) ClearSignInThrottle(ctx context.Context, key string) error {
+	return r.db.WithContext(ctx).Delete(&models.SignInThrottle{}, "throttle_key = ?", key).Error
+}
+
+// DeleteStaleSignInThrottles removes throttles without a failure since before
+func (r *AuthRepository) DeleteStaleSignInThrottles(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Delete(&models.SignInThrottle{}, "last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", before, time.Now()).Error
+}
+
+// Rate limit operations
+
+// TakeRateLimitToken draws a token from the bucket at key like
+// ratelimit.Bucket.Take, holding a row lock so replicas share the bucket
+func (r *AuthRepository) TakeRateLimitToken(ctx context.Context, key string, limit ratelimit.Limit) (time.Duration, error) {
+	var retryAfter time.Duration
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RateLimitBucket{BucketKey: key, Tokens: float64(limit.Requests), RefilledAt: now}).Error; err != nil {
+			return err
+		}
+		var row models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&row, "bucket_key = ?", key).Error; err != nil {
+			return err
+		}
+
+		bucket := ratelimit.Bucket{Tokens: row.Tokens, RefilledAt: row.RefilledAt}
+		retryAfter = bucket.Take(limit, now)
+		return tx.Model(&row).Updates(map[string]any{"tokens": bucket.Tokens, "refilled_at": bucket.RefilledAt}).Error
+	})
+	return retryAfter, err
+}
+
+// DeleteIdleRateLimitBuckets removes buckets last refilled before the time
+func (r *AuthRepository) DeleteIdleRateLimitBuckets(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Delete(&models.RateLimitBucket{}, "refilled_at < ?", before).Error
+}
+
+// Security event operations
+func (r *AuthRepository) CreateSecurityEvent(ctx context.Context, event *models.SecurityEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r *AuthRepository) ListSecurityEventsByUser(ctx context.Context, userID string) ([]models.SecurityEvent, error) {
+	var events []models.SecurityEvent
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&events).Error
+	return events, err
+}
+
+// Signing key operations
+func (r *AuthRepository) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+// ListSigningKeys returns all keys, newest first
+func (r *AuthRepository) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.WithContext(ctx).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// ListUsableSigningKeys returns the active key and all verify-only keys still inside their grace period
+func (r *AuthRepository) ListUsableSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.WithContext(ctx).
+		Where("status = ? OR (status = ? AND verify_until > ?)", models.SigningKeyStatusActive, models.SigningKeyStatusVerifyOnly, time.Now()).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// RotateSigningKey demotes the active key to verify-only until verifyUntil and
+// activates newKey. When the current active key was activated at or after
+// activatedBefore the rotation is skipped and false is returned, which lets
+// several replicas run the rotation schedule without rotating twice.
+func (r *AuthRepository) RotateSigningKey(ctx context.Context, newKey *models.SigningKey, verifyUntil, activatedBefore time.Time) (bool, error) {
+	rotated := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var active []models.SigningKey
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ?", models.SigningKeyStatusActive).
+			Find(&active).Error; err != nil {
+			return err
+		}
+
+		for _, key := range active {
+			if key.ActivatedAt != nil && !key.ActivatedAt.Before(activatedBefore) {
+				return nil
+			}
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.SigningKey{}).
+			Where("status = ?", models.SigningKeyStatusActive).
+			Updates(map[string]interface{}{
+				"status":         models.SigningKeyStatusVerifyOnly,
+				"deactivated_at": now,
+				"verify_until":   verifyUntil,
+			}).Error; err != nil {
+			return err
+		}
+
+		newKey.Status = models.SigningKeyStatusActive
+		newKey.ActivatedAt = &now
+		if err := tx.Create(newKey).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// UpdateSigningKeyPrivateKey replaces a key's stored private material, unless
+// it has changed from current since it was read
+func (r *AuthRepository) UpdateSigningKeyPrivateKey(ctx context.Context, keyID, current, privateKey string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.SigningKey{}).
+		Where("key_id = ? AND private_key = ?", keyID, current).
+		Update("private_key", privateKey).Error
+}
+
+// RetireExpiredSigningKeys retires verify-only keys whose grace period has ended
+// and wipes their private material
+func (r *AuthRepository) RetireExpiredSigningKeys(ctx context.Context) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.SigningKey{}).
+		Where("status = ? AND verify_until <= ?", models.SigningKeyStatusVerifyOnly, time.Now()).
+		Updates(map[string]interface{}{
+			"status":      models.SigningKeyStatusRetired,
+			"private_key": "",
+		})
+	return result.RowsAffected, result.Error
+}
+
 // Utility functions
-func (r *AuthRepository) IsEmailExists(ctx context.Context, email string) (bool, error) {
+// IsEmailExists reports whether the email is taken within an identity pool
+func (r *AuthRepository) IsEmailExists(ctx context.Context, identityPoolID, email string) (bool, error) {
 	var count int64
-	err := r.db.WithContext(ctx).Model(&models.User{}).Where("email_id = ?", email).Count(&count).Error
+	// Deleted users keep their address until they are purged
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).Where("identity_pool_id = ? AND email_id = ?", identityPoolID, email).Count(&count).Error
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// userDataExportVersion identifies the layout of ExportMyData documents
+const userDataExportVersion = 2
+
+// userDataExport is the document returned by ExportMyData
+type userDataExport struct {
+	FormatVersion int       `json:"format_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	*repository.UserData
+}
+
+// accountDeletionGracePeriod is how long a deleted account can be restored
+// before the cleanup service purges its data
+func accountDeletionGracePeriod() time.Duration {
+	return time.Duration(envInt("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour
+}
+
+func (s *AuthServiceServerImpl) DeleteAccount(ctx context.Context, req *authv1.DeleteAccountRequest) (*authv1.DeleteAccountResponse, error) {
+	log.Printf("DeleteAccount request received")
+
+	if req.CurrentPassword == "" {
+		return &authv1.DeleteAccountResponse{Success: false, Message: "Current password is required"}, nil
+	}
+	user, session, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.DeleteAccountResponse{Success: false, Message: err.Error()}, nil
+	}
+	if !utils.CheckPasswordHash(req.CurrentPassword, user.Password) {
+		return &authv1.DeleteAccountResponse{Success: false, Message: "Current password is incorrect"}, nil
+	}
+
+	if err := s.repo.DeleteUserAccount(ctx, user.UserID, false); err != nil {
+		if errors.Is(err, repository.ErrLastOwner) {
+			return &authv1.DeleteAccountResponse{Success: false, Message: "Transfer ownership of your organization before deleting your account"}, nil
+		}
+		log.Printf("Error deleting account: %v", err)
+		return &authv1.DeleteAccountResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	gracePeriod := accountDeletionGracePeriod()
+	s.recordSecurityEvent(ctx, models.SecurityEventAccountDeleted, user.UserID, session.ClientID,
+		fmt.Sprintf("account deleted; data purged after %s", gracePeriod))
+	log.Printf("Account deleted for user: %s", user.UserID)
+	return &authv1.DeleteAccountResponse{
+		Success:    true,
+		Message:    "Account deleted successfully",
+		PurgeAfter: timestamppb.New(time.Now().Add(gracePeriod)),
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) ExportMyData(ctx context.Context, req *authv1.ExportMyDataRequest) (*authv1.ExportMyDataResponse, error) {
+	log.Printf("ExportMyData request received")
+
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.ExportMyDataResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	data, err := s.repo.GetUserData(ctx, user.UserID)
+	if err != nil {
+		log.Printf("Error collecting user data: %v", err)
+		return &authv1.ExportMyDataResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	document, err := json.MarshalIndent(userDataExport{
+		FormatVersion: userDataExportVersion,
+		ExportedAt:    time.Now().UTC(),
+		UserData:      data,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding user data: %v", err)
+		return &authv1.ExportMyDataResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Data exported for user: %s", user.UserID)
+	return &authv1.ExportMyDataResponse{
+		Success: true,
+		Message: "Data exported successfully",
+		Data:    string(document),
+	}, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestDeleteAccount_PurgedAfterGracePeriod(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	repo := repository.NewAuthRepository(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	if err := repo.CreateOrganization(context.Background(), &models.Organization{OrganizationID: "org-1", ClientID: "client-1", Name: "Acme"},
+		&models.OrganizationMember{UserID: "user-1", ClientID: "client-1", OrganizationID: "org-1", Role: models.OrganizationRoleOwner}); err != nil {
+		t.Fatalf("failed to seed organization: %v", err)
+	}
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	deleteAccount := func(password string) *authv1.DeleteAccountResponse {
+		resp, _ := svc.DeleteAccount(context.Background(), &authv1.DeleteAccountRequest{AccessToken: session.AccessToken, CurrentPassword: password})
+		return resp
+	}
+	if resp := deleteAccount("wrong-password"); resp.Success {
+		t.Fatalf("expected the password to be required")
+	}
+	if resp := deleteAccount("password123"); resp.Success {
+		t.Fatalf("expected the last owner of an organization to be refused")
+	}
+	db.Model(&models.OrganizationMember{}).Where("user_id = ?", "user-1").Update("role", models.OrganizationRoleMember)
+	if resp := deleteAccount("password123"); !resp.Success || resp.PurgeAfter == nil {
+		t.Fatalf("expected the account to be deleted, got %v", resp)
+	}
+
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: session.AccessToken}); validate.Valid {
+		t.Fatalf("expected the deleted account's tokens to stop working")
+	}
+	if login, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"}); login.Success {
+		t.Fatalf("expected the deleted account to be unable to sign in")
+	}
+	register := func() *authv1.RegisterUserResponse {
+		resp, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{Username: "alice", Email: "alice@example.com", Password: "password123", ClientId: "client-1"})
+		return resp
+	}
+	if resp := register(); resp.Success || resp.Message != "Email already registered" {
+		t.Fatalf("expected the address to stay reserved during the grace period, got %v", resp)
+	}
+
+	// Accounts deleted within the grace period are kept
+	if purged, err := repo.PurgeDeletedUsers(context.Background(), time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Fatalf("expected nothing to be purged yet, got %d (%v)", purged, err)
+	}
+	if purged, err := repo.PurgeDeletedUsers(context.Background(), time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Fatalf("expected the account to be purged, got %d (%v)", purged, err)
+	}
+	for _, model := range []any{&models.User{}, &models.Session{}, &models.OrganizationMember{}, &models.SecurityEvent{}} {
+		var count int64
+		db.Unscoped().Model(model).Where("user_id = ?", "user-1").Count(&count)
+		if count != 0 {
+			t.Fatalf("expected no %T rows left for the purged user, found %d", model, count)
+		}
+	}
+	if resp := register(); !resp.Success {
+		t.Fatalf("expected the address to be free after the purge, got %v", resp)
+	}
+}
+
+func TestPurgeDeletedUsers_AnonymizesAuditLogAndContinues(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	repo := repository.NewAuthRepository(db)
+	seedClient(t, db, "client-1")
+	for _, id := range []string{"user-1", "user-2"} {
+		seedUser(t, db, id, "client-1", id+"@example.com", id, "password123")
+		svc.recordSecurityEvent(context.Background(), models.SecurityEventEmailChanged, id, "",
+			"email changed from "+id+"@example.com to new-"+id+"@example.com; all sessions revoked")
+		if err := repo.DeleteUserAccount(context.Background(), id, false); err != nil {
+			t.Fatalf("failed to delete %s: %v", id, err)
+		}
+	}
+	// user-1 cannot be purged; user-2 must be purged regardless
+	if err := db.Exec(`CREATE TRIGGER keep_user_1 BEFORE DELETE ON users WHEN old.user_id = 'user-1'
+		BEGIN SELECT RAISE(ABORT, 'purge refused'); END`).Error; err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+
+	purged, err := repo.PurgeDeletedUsers(context.Background(), time.Now().Add(time.Hour))
+	if purged != 1 || err == nil || !strings.Contains(err.Error(), "user-1") {
+		t.Fatalf("expected user-2 to be purged and user-1 reported, got %d (%v)", purged, err)
+	}
+
+	eventOf := func(userID string) models.SecurityEvent {
+		var event models.SecurityEvent
+		db.Where("event_type = ? AND user_id = ?", models.SecurityEventEmailChanged, userID).First(&event)
+		return event
+	}
+	if event := eventOf(""); event.EventID == "" || strings.Contains(event.Details, "@") {
+		t.Fatalf("expected the purged user's event to be kept anonymized, got %+v", event)
+	}
+	if event := eventOf("user-1"); !strings.Contains(event.Details, "user-1@example.com") {
+		t.Fatalf("expected the unpurged user's event to be untouched, got %+v", event)
+	}
+}
+
+func TestExportMyData(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	export, _ := svc.ExportMyData(context.Background(), &authv1.ExportMyDataRequest{AccessToken: session.AccessToken})
+	if !export.Success {
+		t.Fatalf("expected the export to succeed, got %v", export)
+	}
+	var document struct {
+		FormatVersion int `json:"format_version"`
+		User          struct {
+			Email string `json:"email"`
+		} `json:"user"`
+		Sessions []struct {
+			DeviceLabel string `json:"device_label"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal([]byte(export.Data), &document); err != nil {
+		t.Fatalf("expected a JSON document, got %v", err)
+	}
+	if document.FormatVersion != 2 || document.User.Email != "alice@example.com" || len(document.Sessions) != 1 || document.Sessions[0].DeviceLabel != "laptop" {
+		t.Fatalf("expected the user and their session, got %s", export.Data)
+	}
+	if strings.Contains(export.Data, "password") || strings.Contains(export.Data, session.RefreshToken) {
+		t.Fatalf("expected secrets to be left out of the export")
+	}
+
+}
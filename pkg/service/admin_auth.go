@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// adminKeyMetadata is the gRPC metadata header carrying the admin API key
+const adminKeyMetadata = "x-admin-key"
+
+// authorizeAdmin checks the caller presented ADMIN_API_KEY, failing with
+// UNAUTHENTICATED otherwise. Admin RPCs are disabled entirely when no key is
+// configured.
+func authorizeAdmin(ctx context.Context) error {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		return status.Error(codes.Unauthenticated, "admin API is disabled")
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "admin credentials are required")
+	}
+	values := md.Get(adminKeyMetadata)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(adminKey)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid admin credentials")
+	}
+	return nil
+}
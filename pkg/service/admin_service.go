@@ -0,0 +1,370 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// AdminServiceServerImpl implements the operator API on top of the auth
+// service's repository and mailer. Its handlers do not check credentials;
+// AdminAuthInterceptor authorizes every call before they run.
+type AdminServiceServerImpl struct {
+	authv1.UnimplementedAdminServiceServer
+	auth *AuthServiceServerImpl
+}
+
+func NewAdminServiceServer(auth *AuthServiceServerImpl) *AdminServiceServerImpl {
+	return &AdminServiceServerImpl{auth: auth}
+}
+
+// AdminAuthInterceptor rejects AdminService calls without admin credentials.
+// Calls to other services pass through untouched.
+func AdminAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, "/"+authv1.AdminService_ServiceDesc.ServiceName+"/") {
+		if err := authorizeAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (a *AdminServiceServerImpl) ListUsers(ctx context.Context, req *authv1.ListUsersRequest) (*authv1.ListUsersResponse, error) {
+	log.Printf("ListUsers request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.ListUsersResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+	if req.PageSize < 0 || req.PageSize > maxUserPageSize {
+		return &authv1.ListUsersResponse{Success: false, Message: fmt.Sprintf("page_size must be between 0 and %d", maxUserPageSize)}, nil
+	}
+	switch req.Status {
+	case "", models.UserStatusActive, models.UserStatusDisabled, models.UserStatusLocked,
+		models.UserStatusPendingVerification, repository.UserStatusDeleted:
+	default:
+		return &authv1.ListUsersResponse{Success: false, Message: fmt.Sprintf("unknown status: %q", req.Status)}, nil
+	}
+	cursor, err := decodeUserPageToken(req.PageToken)
+	if err != nil {
+		return &authv1.ListUsersResponse{Success: false, Message: "Invalid page token"}, nil
+	}
+
+	client, err := a.auth.repo.GetClientByID(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.ListUsersResponse{Success: false, Message: "Invalid client ID"}, nil
+		}
+		log.Printf("Error checking client existence: %v", err)
+		return &authv1.ListUsersResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	filter := repository.UserFilter{
+		IdentityPoolID: client.IdentityPool(),
+		EmailPrefix:    req.EmailPrefix,
+		Status:         req.Status,
+	}
+	if req.CreatedAfter != nil {
+		filter.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+	if req.CreatedBefore != nil {
+		filter.CreatedBefore = req.CreatedBefore.AsTime()
+	}
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = defaultUserPageSize
+	}
+
+	// One extra row tells whether another page follows
+	users, err := a.auth.repo.ListUsers(ctx, filter, cursor, pageSize+1)
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		return &authv1.ListUsersResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	resp := &authv1.ListUsersResponse{Success: true, Message: "Users retrieved successfully"}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		last := users[len(users)-1]
+		resp.NextPageToken = encodeUserPageToken(&repository.UserCursor{CreatedAt: last.CreatedAt, UserID: last.UserID})
+	}
+	for i := range users {
+		resp.Users = append(resp.Users, adminUser(&users[i]))
+	}
+	return resp, nil
+}
+
+func (a *AdminServiceServerImpl) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.GetUserResponse, error) {
+	log.Printf("GetUser request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.GetUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+	user, err := a.auth.repo.GetUserIncludingDeleted(ctx, req.UserId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.GetUserResponse{Success: false, Message: "User not found"}, nil
+		}
+		log.Printf("Error getting user by ID: %v", err)
+		return &authv1.GetUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	sessions, err := a.auth.repo.ListActiveSessionsByUser(ctx, user.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		return &authv1.GetUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	throttles, err := a.auth.repo.GetActiveSignInThrottles(ctx, []string{accountThrottleKey(user.IdentityPoolID, user.Email)})
+	if err != nil {
+		log.Printf("Error checking sign-in throttles: %v", err)
+		return &authv1.GetUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	resp := &authv1.GetUserResponse{
+		Success:  true,
+		Message:  "User retrieved successfully",
+		User:     adminUser(user),
+		Sessions: sessionInfos(sessions, ""),
+	}
+	if len(throttles) > 0 {
+		resp.SignInBlockedUntil = timestamppb.New(*throttles[0].BlockedUntil)
+	}
+	return resp, nil
+}
+
+func (a *AdminServiceServerImpl) DisableUser(ctx context.Context, req *authv1.DisableUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("DisableUser request received for user: %s", req.UserId)
+
+	if len(req.Reason) > 500 {
+		return &authv1.AdminUserResponse{Success: false, Message: "reason must be at most 500 characters"}, nil
+	}
+	return a.setUserStatus(ctx, req.UserId, models.UserStatusDisabled, req.Reason, nil, "User disabled successfully")
+}
+
+func (a *AdminServiceServerImpl) LockUser(ctx context.Context, req *authv1.LockUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("LockUser request received for user: %s", req.UserId)
+
+	if len(req.Reason) > 500 {
+		return &authv1.AdminUserResponse{Success: false, Message: "reason must be at most 500 characters"}, nil
+	}
+	var lockedUntil *time.Time
+	if req.LockedUntil != nil {
+		until := req.LockedUntil.AsTime()
+		if !until.After(time.Now()) {
+			return &authv1.AdminUserResponse{Success: false, Message: "locked_until must be in the future"}, nil
+		}
+		lockedUntil = &until
+	}
+	return a.setUserStatus(ctx, req.UserId, models.UserStatusLocked, req.Reason, lockedUntil, "User locked successfully")
+}
+
+func (a *AdminServiceServerImpl) EnableUser(ctx context.Context, req *authv1.AdminUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("EnableUser request received for user: %s", req.UserId)
+
+	return a.setUserStatus(ctx, req.UserId, models.UserStatusActive, "", nil, "User enabled successfully")
+}
+
+func (a *AdminServiceServerImpl) UnlockSignIn(ctx context.Context, req *authv1.AdminUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("UnlockSignIn request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.AdminUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+	user, err := a.auth.repo.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.AdminUserResponse{Success: false, Message: "User not found"}, nil
+		}
+		log.Printf("Error getting user by ID: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	// Only the account's counter is cleared; the addresses that failed stay throttled
+	if err := a.auth.repo.ClearSignInThrottle(ctx, accountThrottleKey(user.IdentityPoolID, user.Email)); err != nil {
+		log.Printf("Error clearing sign-in throttle: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	a.auth.recordSecurityEvent(ctx, models.SecurityEventSignInUnlocked, user.UserID, "", "sign-in lockout lifted by an admin")
+	log.Printf("Admin lifted the sign-in lockout of user: %s", user.UserID)
+	return &authv1.AdminUserResponse{Success: true, Message: "Sign-in unlocked successfully"}, nil
+}
+
+// setUserStatus moves a user to the status and records it as a security event
+func (a *AdminServiceServerImpl) setUserStatus(ctx context.Context, userID, userStatus, reason string, lockedUntil *time.Time, message string) (*authv1.AdminUserResponse, error) {
+	if userID == "" {
+		return &authv1.AdminUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	updated, err := a.auth.repo.SetUserStatus(ctx, userID, userStatus, reason, lockedUntil)
+	if err != nil {
+		log.Printf("Error setting user status: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if updated == 0 {
+		return &authv1.AdminUserResponse{Success: false, Message: "User not found"}, nil
+	}
+
+	details := "status set to " + userStatus + " by an admin"
+	if lockedUntil != nil {
+		details += " until " + lockedUntil.UTC().Format(time.RFC3339)
+	}
+	if reason != "" {
+		details += ": " + reason
+	}
+	a.auth.recordSecurityEvent(ctx, models.SecurityEventUserStatusChanged, userID, "", details)
+	log.Printf("User %s is now %s", userID, userStatus)
+	return &authv1.AdminUserResponse{Success: true, Message: message}, nil
+}
+
+func (a *AdminServiceServerImpl) ForcePasswordReset(ctx context.Context, req *authv1.AdminUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("ForcePasswordReset request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.AdminUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	// A hash of a random secret nobody knows; it keeps sign-in timing unchanged
+	secret, err := utils.GenerateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating unusable password: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	unusableHash, err := utils.HashPassword(secret)
+	if err != nil {
+		log.Printf("Error hashing unusable password: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if err := a.auth.repo.ForcePasswordReset(ctx, req.UserId, unusableHash); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.AdminUserResponse{Success: false, Message: "User not found"}, nil
+		}
+		log.Printf("Error forcing password reset: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	a.auth.recordSecurityEvent(ctx, models.SecurityEventPasswordResetForced, req.UserId, "", "password invalidated by an admin; all sessions revoked")
+
+	user, err := a.auth.repo.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	ttl := time.Duration(envInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute
+	if err := a.auth.mailUserToken(ctx, user, models.UserTokenPasswordReset, ttl, passwordResetMessage); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Password invalidated, but the reset email could not be sent"}, nil
+	}
+
+	log.Printf("Admin forced a password reset for user: %s", req.UserId)
+	return &authv1.AdminUserResponse{Success: true, Message: "Password reset email sent"}, nil
+}
+
+func (a *AdminServiceServerImpl) DeleteUser(ctx context.Context, req *authv1.AdminUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("DeleteUser request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.AdminUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	// Admins may remove an organization's last owner; they can appoint another
+	// with AddOrganizationMember
+	if err := a.auth.repo.DeleteUserAccount(ctx, req.UserId, true); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.AdminUserResponse{Success: false, Message: "User not found"}, nil
+		}
+		log.Printf("Error deleting user: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	gracePeriod := accountDeletionGracePeriod()
+	a.auth.recordSecurityEvent(ctx, models.SecurityEventAccountDeleted, req.UserId, "",
+		fmt.Sprintf("account deleted by an admin; data purged after %s", gracePeriod))
+	log.Printf("Admin deleted user: %s", req.UserId)
+	return &authv1.AdminUserResponse{Success: true, Message: "User deleted successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) RestoreUser(ctx context.Context, req *authv1.AdminUserRequest) (*authv1.AdminUserResponse, error) {
+	log.Printf("RestoreUser request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.AdminUserResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	restored, err := a.auth.repo.RestoreUser(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Error restoring user: %v", err)
+		return &authv1.AdminUserResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if restored == 0 {
+		return &authv1.AdminUserResponse{Success: false, Message: "No deleted account found for this user"}, nil
+	}
+
+	a.auth.recordSecurityEvent(ctx, models.SecurityEventAccountRestored, req.UserId, "", "account restored by an admin")
+	log.Printf("Admin restored user: %s", req.UserId)
+	return &authv1.AdminUserResponse{Success: true, Message: "Account restored successfully"}, nil
+}
+
+// adminUser converts a user to the operator view of it
+func adminUser(user *models.User) *authv1.AdminUser {
+	result := &authv1.AdminUser{
+		UserId:         user.UserID,
+		ClientId:       user.ClientID,
+		IdentityPoolId: user.IdentityPoolID,
+		Username:       user.UserName,
+		Email:          user.Email,
+		EmailVerified:  user.EmailVerified,
+		Status:         user.Status,
+		StatusReason:   user.StatusReason,
+		CreatedAt:      timestamppb.New(user.CreatedAt),
+	}
+	if user.StatusChangedAt != nil {
+		result.StatusChangedAt = timestamppb.New(*user.StatusChangedAt)
+	}
+	if user.DeletedAt.Valid {
+		result.DeletedAt = timestamppb.New(user.DeletedAt.Time)
+	}
+	if user.LockedUntil != nil {
+		result.LockedUntil = timestamppb.New(*user.LockedUntil)
+	}
+	return result
+}
+
+// encodeUserPageToken makes an opaque page token from a cursor
+func encodeUserPageToken(cursor *repository.UserCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(cursor.CreatedAt.UnixNano(), 10) + "|" + cursor.UserID))
+}
+
+// decodeUserPageToken parses a page token; an empty token is the first page
+func decodeUserPageToken(token string) (*repository.UserCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	nanos, userID, ok := strings.Cut(string(raw), "|")
+	if !ok || userID == "" {
+		return nil, errors.New("malformed page token")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.UserCursor{CreatedAt: time.Unix(0, n), UserID: userID}, nil
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAdminAuthInterceptor(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	call := func(ctx context.Context, method string) (bool, error) {
+		called := false
+		_, err := AdminAuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			called = true
+			return nil, nil
+		})
+		return called, err
+	}
+
+	if called, err := call(context.Background(), "/auth.v1.AdminService/ListUsers"); called || status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected admin calls without the key to be rejected, got called=%v err=%v", called, err)
+	}
+	if called, err := call(adminContext(t), "/auth.v1.AdminService/ListUsers"); !called || err != nil {
+		t.Fatalf("expected admin calls with the key to pass, got called=%v err=%v", called, err)
+	}
+	if called, err := call(context.Background(), "/auth.v1.AuthService/GetToken"); !called || err != nil {
+		t.Fatalf("expected public calls to pass untouched, got called=%v err=%v", called, err)
+	}
+}
+
+// callAdmin runs an AdminService handler behind AdminAuthInterceptor, as the server does
+func callAdmin[T any](ctx context.Context, method string, handler func(context.Context) (T, error)) (T, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/" + authv1.AdminService_ServiceDesc.ServiceName + "/" + method}
+	resp, err := AdminAuthInterceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return handler(ctx)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return resp.(T), nil
+}
+
+func TestListUsers_PaginationAndFilters(t *testing.T) {
+	db := newTestDB(t)
+	admin := NewAdminServiceServer(NewAuthServiceServer(db))
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	for i := 1; i <= 5; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		seedUser(t, db, userID, "client-1", fmt.Sprintf("user_%d@example.com", i), userID, "password123")
+		db.Model(&models.User{}).Where("user_id = ?", userID).Update("created_at", start.Add(time.Duration(i)*time.Hour))
+	}
+	seedUser(t, db, "userx1", "client-1", "userx1@example.com", "userx1", "password123")
+	seedUser(t, db, "other", "client-2", "other@example.com", "other", "password123")
+
+	list := func(req *authv1.ListUsersRequest) *authv1.ListUsersResponse {
+		req.ClientId = "client-1"
+		resp, _ := admin.ListUsers(context.Background(), req)
+		if !resp.Success {
+			t.Fatalf("expected users to be listed, got %v", resp)
+		}
+		return resp
+	}
+	ids := func(resp *authv1.ListUsersResponse) string {
+		var ids []string
+		for _, user := range resp.Users {
+			ids = append(ids, user.UserId)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	// The underscore is matched literally, so userx1 is left out
+	var pages []string
+	req := &authv1.ListUsersRequest{PageSize: 2, EmailPrefix: "user_"}
+	for {
+		resp := list(req)
+		pages = append(pages, ids(resp))
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if fmt.Sprint(pages) != "[[user-5 user-4] [user-3 user-2] [user-1]]" {
+		t.Fatalf("expected three pages newest first, got %v", pages)
+	}
+
+	ranged := list(&authv1.ListUsersRequest{
+		CreatedAfter:  timestamppb.New(start.Add(2 * time.Hour)),
+		CreatedBefore: timestamppb.New(start.Add(4 * time.Hour)),
+	})
+	if ids(ranged) != "[user-3 user-2]" {
+		t.Fatalf("expected the created range to be half-open, got %v", ids(ranged))
+	}
+
+	if resp, _ := admin.DisableUser(context.Background(), &authv1.DisableUserRequest{UserId: "user-2", Reason: "chargeback"}); !resp.Success {
+		t.Fatalf("expected the user to be disabled, got %v", resp)
+	}
+	if resp, _ := admin.DeleteUser(context.Background(), &authv1.AdminUserRequest{UserId: "user-4"}); !resp.Success {
+		t.Fatalf("expected the user to be deleted, got %v", resp)
+	}
+	disabled := list(&authv1.ListUsersRequest{Status: models.UserStatusDisabled})
+	if ids(disabled) != "[user-2]" || disabled.Users[0].StatusReason != "chargeback" || disabled.Users[0].StatusChangedAt == nil {
+		t.Fatalf("expected the disabled user with its reason, got %v", disabled)
+	}
+	if deleted := list(&authv1.ListUsersRequest{Status: "deleted"}); ids(deleted) != "[user-4]" || deleted.Users[0].DeletedAt == nil {
+		t.Fatalf("expected the deleted user, got %v", deleted)
+	}
+	if all := list(&authv1.ListUsersRequest{EmailPrefix: "user_"}); ids(all) != "[user-5 user-3 user-2 user-1]" {
+		t.Fatalf("expected deleted users to be left out by default, got %v", ids(all))
+	}
+
+	if resp, _ := admin.ListUsers(context.Background(), &authv1.ListUsersRequest{ClientId: "client-1", PageToken: "not-a-token"}); resp.Success {
+		t.Fatalf("expected a malformed page token to be rejected")
+	}
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	admin := NewAdminServiceServer(svc)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	if user, _ := admin.GetUser(context.Background(), &authv1.GetUserRequest{UserId: "user-1"}); !user.Success || user.User.Status != models.UserStatusActive || len(user.Sessions) != 1 {
+		t.Fatalf("expected the active user with one session, got %v", user)
+	}
+
+	if resp, _ := admin.DisableUser(context.Background(), &authv1.DisableUserRequest{UserId: "user-1"}); !resp.Success {
+		t.Fatalf("expected the user to be disabled, got %v", resp)
+	}
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: session.AccessToken}); validate.Valid {
+		t.Fatalf("expected disabling to revoke the user's sessions")
+	}
+	login := func(password string) *authv1.GetTokenResponse {
+		resp, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: password, ClientId: "client-1"})
+		return resp
+	}
+	if resp := login("wrong-password"); resp.Message != "Invalid credentials" {
+		t.Fatalf("expected a wrong password not to reveal the status, got %v", resp)
+	}
+	if resp := login("password123"); resp.Success || resp.Message != "Account disabled" {
+		t.Fatalf("expected a disabled user to be refused, got %v", resp)
+	}
+	if resp, _ := admin.EnableUser(context.Background(), &authv1.AdminUserRequest{UserId: "user-1"}); !resp.Success {
+		t.Fatalf("expected the user to be enabled, got %v", resp)
+	}
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	if resp, _ := admin.ForcePasswordReset(context.Background(), &authv1.AdminUserRequest{UserId: "user-1"}); !resp.Success {
+		t.Fatalf("expected the password reset to be forced, got %v", resp)
+	}
+	if resp := login("password123"); resp.Success {
+		t.Fatalf("expected the old password to stop working")
+	}
+	token := mailedToken(t, mail, "alice@example.com", passwordResetTokenPattern)
+	if resp, _ := svc.ResetPassword(context.Background(), &authv1.ResetPasswordRequest{Token: token, NewPassword: "new-password456"}); !resp.Success {
+		t.Fatalf("expected the mailed token to reset the password, got %v", resp)
+	}
+	loginAs(t, svc, "alice@example.com", "new-password456", "client-1", "laptop")
+
+	if resp, _ := admin.GetUser(context.Background(), &authv1.GetUserRequest{UserId: "missing"}); resp.Success {
+		t.Fatalf("expected an unknown user to be reported")
+	}
+}
+
+func TestLockUser_EnforcedUntilLapsed(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	lock := func(until time.Time) *authv1.AdminUserResponse {
+		resp, _ := admin.LockUser(context.Background(), &authv1.LockUserRequest{UserId: "user-1", Reason: "suspicious activity", LockedUntil: timestamppb.New(until)})
+		return resp
+	}
+	if resp := lock(time.Now().Add(-time.Minute)); resp.Success {
+		t.Fatalf("expected a lock ending in the past to be rejected")
+	}
+	if resp := lock(time.Now().Add(time.Hour)); !resp.Success {
+		t.Fatalf("expected the user to be locked, got %v", resp)
+	}
+
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: session.AccessToken}); validate.Valid {
+		t.Fatalf("expected a locked user's access token to be refused")
+	}
+	if refresh, _ := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{RefreshToken: session.RefreshToken, ClientId: "client-1", ClientSecret: "secret"}); refresh.Success {
+		t.Fatalf("expected a locked user's refresh token to be refused")
+	}
+	if login, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"}); login.Success || login.Message != "Account locked" {
+		t.Fatalf("expected a locked user to be refused, got %v", login)
+	}
+	user, _ := admin.GetUser(context.Background(), &authv1.GetUserRequest{UserId: "user-1"})
+	if user.User.Status != models.UserStatusLocked || user.User.LockedUntil == nil || user.User.StatusReason != "suspicious activity" {
+		t.Fatalf("expected the lock to be reported, got %v", user.User)
+	}
+
+	// A lock that has run out no longer applies, even before the cleanup service clears it
+	db.Model(&models.User{}).Where("user_id = ?", "user-1").Update("locked_until", time.Now().Add(-time.Minute))
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	if unlocked, err := svc.repo.UnlockExpiredUsers(context.Background()); err != nil || unlocked != 1 {
+		t.Fatalf("expected the lapsed lock to be cleared, got %d (%v)", unlocked, err)
+	}
+	if user, _ := admin.GetUser(context.Background(), &authv1.GetUserRequest{UserId: "user-1"}); user.User.Status != models.UserStatusActive || user.User.LockedUntil != nil {
+		t.Fatalf("expected the user to be active again, got %v", user.User)
+	}
+}
+
+func TestRestoreUser_RequiresAdmin(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	if resp, _ := svc.DeleteAccount(context.Background(), &authv1.DeleteAccountRequest{AccessToken: session.AccessToken, CurrentPassword: "password123"}); !resp.Success {
+		t.Fatalf("expected the account to be deleted, got %v", resp)
+	}
+
+	restore := func(ctx context.Context) (*authv1.AdminUserResponse, error) {
+		return callAdmin(ctx, "RestoreUser", func(ctx context.Context) (*authv1.AdminUserResponse, error) {
+			return admin.RestoreUser(ctx, &authv1.AdminUserRequest{UserId: "user-1"})
+		})
+	}
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	if _, err := restore(context.Background()); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected restoring to require admin credentials, got %v", err)
+	}
+	if login, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"}); login.Success {
+		t.Fatalf("expected the rejected restore to leave the account deleted")
+	}
+	if resp, err := restore(adminContext(t)); err != nil || !resp.Success {
+		t.Fatalf("expected the account to be restored, got %v (%v)", resp, err)
+	}
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+}
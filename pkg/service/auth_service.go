@@ -1,17 +1,21 @@
 package service
 
 import (
+	"authservice/pkg/mailer"
 	"authservice/pkg/models"
 	"authservice/pkg/repository"
 	"authservice/pkg/utils"
 	authv1 "authservice/proto/auth/v1"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
@@ -19,15 +23,30 @@ import (
 
 type AuthServiceServerImpl struct {
 	authv1.UnimplementedAuthServiceServer
-	repo *repository.AuthRepository
+	repo    *repository.AuthRepository
+	keyRing *KeyRing
+	mailer  mailer.Mailer
 }
 
 func NewAuthServiceServer(db *gorm.DB) *AuthServiceServerImpl {
 	return &AuthServiceServerImpl{
-		repo: repository.NewAuthRepository(db),
+		repo:    repository.NewAuthRepository(db),
+		keyRing: NewKeyRing(db),
+		mailer:  mailer.LogMailer{},
 	}
 }
 
+// SetMailer replaces the mailer used for invitations and verification emails;
+// the default logs messages
+func (s *AuthServiceServerImpl) SetMailer(m mailer.Mailer) {
+	s.mailer = m
+}
+
+// KeyRing returns the signing key ring managed by the admin key RPCs
+func (s *AuthServiceServerImpl) KeyRing() *KeyRing {
+	return s.keyRing
+}
+
 func (s *AuthServiceServerImpl) HealthCheck(ctx context.Context, in *emptypb.Empty) (*authv1.HealthCheckResponse, error) {
 	return &authv1.HealthCheckResponse{
 		Status:  authv1.HealthCheckResponse_SERVING,
@@ -51,23 +70,23 @@ func (s *AuthServiceServerImpl) RegisterUser(ctx context.Context, req *authv1.Re
 	}
 
 	// Check if client exists
-	clientExists, err := s.repo.IsClientExists(ctx, req.ClientId)
+	client, err := s.repo.GetClientByID(ctx, req.ClientId)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.RegisterUserResponse{
+				Success: false,
+				Message: "Invalid client ID",
+			}, nil
+		}
 		log.Printf("Error checking client existence: %v", err)
 		return &authv1.RegisterUserResponse{
 			Success: false,
 			Message: "Internal server error",
 		}, nil
 	}
-	if !clientExists {
-		return &authv1.RegisterUserResponse{
-			Success: false,
-			Message: "Invalid client ID",
-		}, nil
-	}
 
-	// Check if email already exists
-	emailExists, err := s.repo.IsEmailExists(ctx, req.Email)
+	// Check if email already exists; emails are unique within the client's identity pool
+	emailExists, err := s.repo.IsEmailExists(ctx, client.IdentityPool(), req.Email)
 	if err != nil {
 		log.Printf("Error checking email existence: %v", err)
 		return &authv1.RegisterUserResponse{
@@ -82,6 +101,19 @@ func (s *AuthServiceServerImpl) RegisterUser(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
+	// An invitation must be valid before anything is created
+	var invitation *models.Invitation
+	if req.InvitationToken != "" {
+		invitation, err = s.pendingInvitation(ctx, req.InvitationToken, req.ClientId, req.Email)
+		if err != nil {
+			log.Printf("Invitation rejected: %v", err)
+			return &authv1.RegisterUserResponse{
+				Success: false,
+				Message: "Invalid invitation token",
+			}, nil
+		}
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -95,11 +127,43 @@ func (s *AuthServiceServerImpl) RegisterUser(ctx context.Context, req *authv1.Re
 	// Create user
 	userID := utils.GenerateUUID()
 	user := &models.User{
-		UserID:   userID,
-		UserName: req.Username,
-		Email:    req.Email,
-		Password: hashedPassword,
-		ClientID: req.ClientId,
+		UserID:         userID,
+		UserName:       req.Username,
+		Email:          req.Email,
+		Password:       hashedPassword,
+		ClientID:       req.ClientId,
+		IdentityPoolID: client.IdentityPool(),
+	}
+	if client.RequireEmailVerification {
+		user.Status = models.UserStatusPendingVerification
+	}
+
+	if invitation != nil {
+		// The invitation was delivered to this address, which proves the user controls it
+		user.EmailVerified = true
+		user.Status = models.UserStatusActive
+
+		// Joining the organization and redeeming the invitation happen with the user's creation
+		if err := s.repo.CreateUserFromInvitation(ctx, user, invitation); err != nil {
+			if errors.Is(err, repository.ErrInvitationUsed) {
+				return &authv1.RegisterUserResponse{
+					Success: false,
+					Message: "Invalid invitation token",
+				}, nil
+			}
+			log.Printf("Error creating invited user: %v", err)
+			return &authv1.RegisterUserResponse{
+				Success: false,
+				Message: "Failed to create user",
+			}, nil
+		}
+		log.Printf("User %s registered and joined organization %s", userID, invitation.OrganizationID)
+		return &authv1.RegisterUserResponse{
+			Success:        true,
+			Message:        "User registered successfully",
+			UserId:         userID,
+			OrganizationId: invitation.OrganizationID,
+		}, nil
 	}
 
 	if err := s.repo.CreateUser(ctx, user); err != nil {
@@ -110,6 +174,11 @@ func (s *AuthServiceServerImpl) RegisterUser(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
+	// A failed send is not fatal: the user can ask for another with SendVerificationEmail
+	if err := s.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+
 	log.Printf("User registered successfully: %s", userID)
 	return &authv1.RegisterUserResponse{
 		Success: true,
@@ -130,175 +199,394 @@ func (s *AuthServiceServerImpl) GetToken(ctx context.Context, req *authv1.GetTok
 	}
 
 	// Check if client exists
-	clientExists, err := s.repo.IsClientExists(ctx, req.ClientId)
+	client, err := s.repo.GetClientByID(ctx, req.ClientId)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.GetTokenResponse{
+				Success: false,
+				Message: "Invalid client ID",
+			}, nil
+		}
 		log.Printf("Error checking client existence: %v", err)
 		return &authv1.GetTokenResponse{
 			Success: false,
 			Message: "Internal server error",
 		}, nil
 	}
-	if !clientExists {
+
+	// Verify the user's credentials against the client
+	user, err := s.authenticateUser(ctx, client, req.Email, req.Password)
+	if err != nil {
 		return &authv1.GetTokenResponse{
 			Success: false,
-			Message: "Invalid client ID",
+			Message: signInErrorMessage(err),
 		}, nil
 	}
 
-	// Get user by email
-	user, err := s.repo.GetUserByEmail(ctx, req.Email)
+	// Grant the requested scopes and audiences the client allows
+	scope, err := resolveUserScope(client, req.Scope)
+	var audience []string
+	if err == nil {
+		audience, err = resolveAudience(client.AllowedAudienceList(), req.Audience)
+	}
 	if err != nil {
-		log.Printf("Error getting user by email: %v", err)
 		return &authv1.GetTokenResponse{
 			Success: false,
-			Message: "Invalid credentials",
+			Message: err.Error(),
 		}, nil
 	}
 
-	// Check if user belongs to the client
-	if user.ClientID != req.ClientId {
+	resp, err := s.issueSessionTokens(ctx, user, client, scope, audience, req.UserAgent, req.DeviceLabel)
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
 		return &authv1.GetTokenResponse{
 			Success: false,
-			Message: "Invalid credentials",
+			Message: "Internal server error",
 		}, nil
 	}
 
-	// Verify password
-	if !utils.CheckPasswordHash(req.Password, user.Password) {
+	if err := s.attachIDToken(resp, user, client.ClientID, req.Nonce, time.Now()); err != nil {
+		log.Printf("Error issuing ID token: %v", err)
 		return &authv1.GetTokenResponse{
 			Success: false,
-			Message: "Invalid credentials",
+			Message: "Internal server error",
 		}, nil
 	}
 
+	log.Printf("User logged in successfully: %s", user.UserID)
+	return resp, nil
+}
+
+// errInvalidCredentials is deliberately vague so callers cannot tell which check failed
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// errEmailNotVerified is returned for the right credentials when the client
+// requires a verified email address
+var errEmailNotVerified = errors.New("email address not verified")
+
+// errAccountDisabled is returned for the right credentials of a disabled user
+var errAccountDisabled = errors.New("account disabled")
+
+// errAccountLocked is returned for the right credentials of a locked user
+var errAccountLocked = errors.New("account locked")
+
+// errSignInThrottled is returned, without checking the password, while too
+// many failed sign-ins block the account or the caller's address
+var errSignInThrottled = errors.New("too many failed sign-in attempts")
+
+// userStatusError returns the error keeping the user from signing in or
+// using their tokens, or nil when the user is active. A lock with an end
+// lapses on its own.
+func userStatusError(user *models.User) error {
+	switch user.Status {
+	case models.UserStatusDisabled:
+		return errAccountDisabled
+	case models.UserStatusLocked:
+		if user.LockedUntil == nil || time.Now().Before(*user.LockedUntil) {
+			return errAccountLocked
+		}
+	case models.UserStatusPendingVerification:
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// signInErrorMessage is the response message for an authenticateUser or
+// userStatusError error
+func signInErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errEmailNotVerified):
+		return "Email address not verified"
+	case errors.Is(err, errAccountDisabled):
+		return "Account disabled"
+	case errors.Is(err, errAccountLocked):
+		return "Account locked"
+	case errors.Is(err, errSignInThrottled):
+		return "Too many failed sign-in attempts, try again later"
+	default:
+		return "Invalid credentials"
+	}
+}
+
+// authenticateUser checks an email/password pair for a user of the client.
+// Only with the right password does it report errEmailNotVerified, so the
+// verification state is not disclosed to anyone guessing. Failures are
+// throttled per account and per address alike whether or not the email
+// exists, and take as long either way.
+func (s *AuthServiceServerImpl) authenticateUser(ctx context.Context, client *models.Client, email, password string) (*models.User, error) {
+	keys := newSignInThrottleKeys(ctx, client, email)
+	if s.signInBlocked(ctx, keys) {
+		return nil, errSignInThrottled
+	}
+
+	// Get user by email; only users of the client's identity pool can sign in
+	user, err := s.repo.GetUserByEmail(ctx, client.IdentityPool(), email)
+	if err != nil {
+		log.Printf("Error getting user by email: %v", err)
+		utils.CheckPasswordHash(password, dummyPasswordHash())
+		s.recordSignInFailure(ctx, keys, client, nil)
+		return nil, errInvalidCredentials
+	}
+
+	// Verify password
+	if !utils.CheckPasswordHash(password, user.Password) {
+		s.recordSignInFailure(ctx, keys, client, user)
+		return nil, errInvalidCredentials
+	}
+	if err := s.repo.ClearSignInThrottle(ctx, keys.account); err != nil {
+		log.Printf("Error clearing sign-in throttle: %v", err)
+	}
+
+	if err := userStatusError(user); err != nil {
+		return nil, err
+	}
+
+	if client.RequireEmailVerification && !user.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	return user, nil
+}
+
+// loginErrorText is what the browser login pages show for an authenticateUser error
+func loginErrorText(err error) string {
+	switch {
+	case errors.Is(err, errEmailNotVerified):
+		return "Verify your email address before signing in"
+	case errors.Is(err, errAccountDisabled):
+		return "This account has been disabled"
+	case errors.Is(err, errAccountLocked):
+		return "This account is locked"
+	case errors.Is(err, errSignInThrottled):
+		return "Too many failed attempts. Try again later"
+	default:
+		return "Invalid email or password"
+	}
+}
+
+// issueSessionTokens creates a new session for the user and returns the
+// access/refresh token pair for it. Every login gets its own session (one per
+// device) and starts a new refresh token family; the client's session cap
+// evicts the oldest. The granted scope and audience are kept on the session
+// for later refreshes.
+func (s *AuthServiceServerImpl) issueSessionTokens(ctx context.Context, user *models.User, client *models.Client, scope string, audience []string, userAgent, deviceLabel string) (*authv1.GetTokenResponse, error) {
 	// Generate refresh token
 	refreshToken, err := utils.GenerateRefreshToken()
 	if err != nil {
-		log.Printf("Error generating refresh token: %v", err)
-		return &authv1.GetTokenResponse{
-			Success: false,
-			Message: "Internal server error",
-		}, nil
+		return nil, fmt.Errorf("generating refresh token: %w", err)
 	}
 
-	// Generate JWT token with refresh token in payload
-	accessToken, expiresAt, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, refreshToken)
+	grant, err := s.userTokenGrant(ctx, user, client.ClientID, scope, audience)
 	if err != nil {
-		log.Printf("Error generating JWT token: %v", err)
-		return &authv1.GetTokenResponse{
-			Success: false,
-			Message: "Internal server error",
-		}, nil
+		return nil, err
 	}
 
-	// Create or update session (only one session per user-client pair)
-	session := &models.Session{
-		UserID:       user.UserID,
-		ClientID:     user.ClientID,
-		RefreshToken: refreshToken,
-		UserAgent:    req.UserAgent,
-		ExpiresAt:    time.Now().Add(7 * 24 * time.Hour), // 7 days
+	// The token is bound to the session by ID; in a shared identity pool it
+	// belongs to the client signed in to, not the user's own
+	sessionID := utils.GenerateUUID()
+	accessToken, expiresAt, err := utils.GenerateScopedJWTToken(user.UserID, user.UserName, client.ClientID, sessionID, grant)
+	if err != nil {
+		return nil, fmt.Errorf("generating JWT token: %w", err)
 	}
 
-	if err := s.repo.CreateOrUpdateSession(ctx, session); err != nil {
-		log.Printf("Error creating/updating session: %v", err)
-		return &authv1.GetTokenResponse{
-			Success: false,
-			Message: "Internal server error",
-		}, nil
+	now := time.Now()
+	session := &models.Session{
+		SessionID:        sessionID,
+		UserID:           user.UserID,
+		ClientID:         client.ClientID,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		FamilyID:         utils.GenerateUUID(),
+		UserAgent:        userAgent,
+		IPAddress:        clientIP(ctx),
+		DeviceLabel:      deviceLabel,
+		Scope:            scope,
+		Audience:         strings.Join(audience, "\n"),
+		LastUsedAt:       &now,
+		ExpiresAt:        now.Add(7 * 24 * time.Hour), // 7 days
 	}
 
-	userProfile := &authv1.UserProfile{
-		UserId:    user.UserID,
-		Username:  user.UserName,
-		Email:     user.Email,
-		ClientId:  user.ClientID,
-		CreatedAt: timestamppb.New(user.CreatedAt),
+	if err := s.repo.CreateSessionWithLimit(ctx, session, maxSessionsPerUser(client)); err != nil {
+		return nil, fmt.Errorf("creating session: %w", err)
 	}
 
-	log.Printf("User logged in successfully: %s", user.UserID)
 	return &authv1.GetTokenResponse{
 		Success:      true,
 		Message:      "Login successful",
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		ExpiresAt:    timestamppb.New(expiresAt),
-		User:         userProfile,
+		User:         userProfile(user),
+		SessionId:    session.SessionID,
+		Scope:        scope,
+		Audience:     audience,
+	}, nil
+}
+
+// userTokenGrant completes a user's scope and audience with the role,
+// organization and custom attribute claims of their access tokens for the client
+func (s *AuthServiceServerImpl) userTokenGrant(ctx context.Context, user *models.User, clientID, scope string, audience []string) (utils.TokenGrant, error) {
+	roles, err := s.tokenRoles(ctx, user, clientID)
+	if err != nil {
+		return utils.TokenGrant{}, fmt.Errorf("loading role claims: %w", err)
+	}
+	organizationID, err := s.userOrganizationID(ctx, user.UserID, clientID)
+	if err != nil {
+		return utils.TokenGrant{}, fmt.Errorf("loading organization claim: %w", err)
+	}
+	customAttributes, err := s.tokenCustomAttributes(ctx, user, clientID)
+	if err != nil {
+		return utils.TokenGrant{}, fmt.Errorf("loading custom attributes claim: %w", err)
+	}
+	return utils.TokenGrant{
+		Scope:            scope,
+		Audience:         audience,
+		Roles:            roles,
+		OrganizationID:   organizationID,
+		CustomAttributes: customAttributes,
 	}, nil
 }
 
+// userBelongsToClient reports whether the user may sign in to the client:
+// the client registered the user or shares the user's identity pool
+func (s *AuthServiceServerImpl) userBelongsToClient(ctx context.Context, user *models.User, clientID string) bool {
+	if user.ClientID == clientID {
+		return true
+	}
+	client, err := s.repo.GetClientByID(ctx, clientID)
+	return err == nil && client.IdentityPool() == user.IdentityPoolID
+}
+
 func (s *AuthServiceServerImpl) ValidateToken(ctx context.Context, req *authv1.ValidateTokenRequest) (*authv1.ValidateTokenResponse, error) {
 	log.Printf("ValidateToken request received")
 
-	if req.AccessToken == "" {
-		return &authv1.ValidateTokenResponse{
+	claims, resp := s.validateAccessToken(ctx, req.AccessToken)
+	if claims == nil {
+		return resp, nil
+	}
+	if rejection := checkTokenRequirements(claims, req.RequiredScope, req.RequiredAudience); rejection != nil {
+		return rejection, nil
+	}
+	return resp, nil
+}
+
+// validateAccessToken verifies an access token's signature and checks that its
+// principal and session are still live. The claims are returned only when the
+// token is valid.
+func (s *AuthServiceServerImpl) validateAccessToken(ctx context.Context, accessToken string) (*utils.Claims, *authv1.ValidateTokenResponse) {
+	if accessToken == "" {
+		return nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "Access token is required",
-		}, nil
+		}
 	}
 
-	claims, err := utils.ValidateJWTToken(req.AccessToken)
+	claims, err := utils.ValidateJWTToken(accessToken)
 	if err != nil {
 		log.Printf("Error validating JWT token: %v", err)
-		return &authv1.ValidateTokenResponse{
+		return nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "Invalid token",
-		}, nil
+		}
+	}
+
+	if claims.IsClientPrincipal() {
+		resp := s.validateClientToken(ctx, claims)
+		if !resp.Valid {
+			return nil, resp
+		}
+		return claims, resp
+	}
+
+	_, _, resp := s.validateUserToken(ctx, claims)
+	if !resp.Valid {
+		return nil, resp
 	}
+	return claims, resp
+}
 
+// validateUserToken checks the user and session behind verified user token
+// claims, returning them with the ValidateToken response
+func (s *AuthServiceServerImpl) validateUserToken(ctx context.Context, claims *utils.Claims) (*models.User, *models.Session, *authv1.ValidateTokenResponse) {
 	// Check if user still exists
 	user, err := s.repo.GetUserByID(ctx, claims.UserID)
 	if err != nil {
 		log.Printf("Error getting user by ID: %v", err)
-		return &authv1.ValidateTokenResponse{
+		return nil, nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "User not found",
-		}, nil
+		}
+	}
+
+	if err := userStatusError(user); err != nil {
+		return nil, nil, &authv1.ValidateTokenResponse{
+			Valid:   false,
+			Message: signInErrorMessage(err),
+		}
 	}
 
 	// Validate username matches
 	if user.UserName != claims.Username {
 		log.Printf("Username mismatch in token claims")
-		return &authv1.ValidateTokenResponse{
+		return nil, nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "Invalid token claims",
-		}, nil
+		}
 	}
 
-	// Validate client ID matches
-	if user.ClientID != claims.ClientID {
+	// Validate the user may use the client the token was issued to
+	if !s.userBelongsToClient(ctx, user, claims.ClientID) {
 		log.Printf("Client ID mismatch in token claims")
-		return &authv1.ValidateTokenResponse{
+		return nil, nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "Invalid token claims",
-		}, nil
+		}
 	}
 
-	// Validate refresh token exists in database (for additional security)
-	session, err := s.repo.GetSessionByUserAndClient(ctx, user.UserID, user.ClientID)
-	if err != nil || session.RefreshToken != claims.RefreshToken {
+	// Validate the session the token was issued for still exists (for additional security)
+	session, err := s.sessionForClaims(ctx, claims)
+	if err != nil || session.UserID != user.UserID || session.ClientID != claims.ClientID {
 		log.Printf("Refresh token validation failed")
-		return &authv1.ValidateTokenResponse{
+		return nil, nil, &authv1.ValidateTokenResponse{
 			Valid:   false,
 			Message: "Invalid session",
-		}, nil
+		}
 	}
 
-	userProfile := &authv1.UserProfile{
-		UserId:    user.UserID,
-		Username:  user.UserName,
-		Email:     user.Email,
-		ClientId:  user.ClientID,
-		CreatedAt: timestamppb.New(user.CreatedAt),
+	// A user removed from an organization must not keep acting inside it
+	if claims.OrganizationID != "" {
+		organizationID, err := s.userOrganizationID(ctx, user.UserID, claims.ClientID)
+		if err != nil || organizationID != claims.OrganizationID {
+			log.Printf("Organization membership no longer matches token claims")
+			return nil, nil, &authv1.ValidateTokenResponse{
+				Valid:   false,
+				Message: "Invalid token claims",
+			}
+		}
 	}
 
-	return &authv1.ValidateTokenResponse{
-		Valid:     true,
-		Message:   "Token is valid",
-		UserId:    user.UserID,
-		ExpiresAt: timestamppb.New(claims.ExpiresAt.Time),
-		User:      userProfile,
-	}, nil
+	return user, session, &authv1.ValidateTokenResponse{
+		Valid:          true,
+		Message:        "Token is valid",
+		UserId:         user.UserID,
+		ExpiresAt:      timestamppb.New(claims.ExpiresAt.Time),
+		User:           userProfile(user),
+		PrincipalType:  utils.PrincipalTypeUser,
+		ClientId:       claims.ClientID,
+		Scope:          claims.Scope,
+		Audience:       claims.Audience,
+		Actor:          actorSubject(claims),
+		OrganizationId: claims.OrganizationID,
+	}
+}
+
+// sessionForClaims loads the session a user access token was issued for.
+// Tokens carry only the session ID, so the refresh token never reaches
+// downstream services that verify them.
+func (s *AuthServiceServerImpl) sessionForClaims(ctx context.Context, claims *utils.Claims) (*models.Session, error) {
+	if claims.SessionID != "" {
+		return s.repo.GetSessionByID(ctx, claims.SessionID)
+	}
+	return nil, errors.New("token is not bound to a session")
 }
 
 func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.RefreshTokenRequest) (*authv1.RefreshTokenResponse, error) {
@@ -311,10 +599,20 @@ func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
+	// Only the client the token was issued to may redeem it; public clients
+	// have no secret to prove that with
+	if _, oauthErr := s.authenticateClient(ctx, req.ClientId, req.ClientSecret); oauthErr != nil {
+		return &authv1.RefreshTokenResponse{
+			Success: false,
+			Message: oauthErr.Description,
+		}, nil
+	}
+
 	// Get session by refresh token
 	session, err := s.repo.GetSessionByRefreshToken(ctx, req.RefreshToken)
 	if err != nil {
 		log.Printf("Error getting session by refresh token: %v", err)
+		s.detectRefreshTokenReuse(ctx, req.RefreshToken)
 		return &authv1.RefreshTokenResponse{
 			Success: false,
 			Message: "Invalid refresh token",
@@ -331,14 +629,38 @@ func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
-	// Check if user belongs to the client
-	if user.ClientID != req.ClientId {
+	// Check the session was issued to the client
+	if session.ClientID != req.ClientId {
 		return &authv1.RefreshTokenResponse{
 			Success: false,
 			Message: "Invalid client ID",
 		}, nil
 	}
 
+	if err := userStatusError(user); err != nil {
+		return &authv1.RefreshTokenResponse{
+			Success: false,
+			Message: signInErrorMessage(err),
+		}, nil
+	}
+
+	// The refreshed access token may be narrower than the login's grant, never wider
+	scope, audience, err := s.resolveRefreshGrant(ctx, session, req.Scope, req.Audience)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			return &authv1.RefreshTokenResponse{
+				Success: false,
+				Message: oauthErr.Description,
+			}, nil
+		}
+		log.Printf("Error resolving refresh grant: %v", err)
+		return &authv1.RefreshTokenResponse{
+			Success: false,
+			Message: "Internal server error",
+		}, nil
+	}
+
 	// Generate new tokens
 	newRefreshToken, err := utils.GenerateRefreshToken()
 	if err != nil {
@@ -349,8 +671,17 @@ func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
-	// Generate JWT token with new refresh token in payload
-	accessToken, expiresAt, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, newRefreshToken)
+	// Role and organization claims are reloaded so changes reach tokens on refresh
+	grant, err := s.userTokenGrant(ctx, user, session.ClientID, scope, audience)
+	if err != nil {
+		log.Printf("Error loading token claims: %v", err)
+		return &authv1.RefreshTokenResponse{
+			Success: false,
+			Message: "Internal server error",
+		}, nil
+	}
+
+	accessToken, expiresAt, err := utils.GenerateScopedJWTToken(user.UserID, user.UserName, session.ClientID, session.SessionID, grant)
 	if err != nil {
 		log.Printf("Error generating JWT token: %v", err)
 		return &authv1.RefreshTokenResponse{
@@ -359,10 +690,22 @@ func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.Re
 		}, nil
 	}
 
-	// Update session with new refresh token
-	session.RefreshToken = newRefreshToken
-	session.ExpiresAt = time.Now().Add(7 * 24 * time.Hour) // 7 days
-	if err := s.repo.CreateOrUpdateSession(ctx, session); err != nil {
+	// Rotate the refresh token within its family, remembering the old one for reuse detection
+	if session.FamilyID == "" {
+		session.FamilyID = utils.GenerateUUID() // sessions created before token families existed
+	}
+	now := time.Now()
+	session.LastUsedAt = &now
+	session.IPAddress = clientIP(ctx)
+	if err := s.repo.RotateRefreshToken(ctx, session, newRefreshToken, now.Add(7*24*time.Hour)); err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotCurrent) {
+			// A concurrent request already rotated this token, so this presentation is a replay
+			s.detectRefreshTokenReuse(ctx, req.RefreshToken)
+			return &authv1.RefreshTokenResponse{
+				Success: false,
+				Message: "Invalid refresh token",
+			}, nil
+		}
 		log.Printf("Error updating session: %v", err)
 		return &authv1.RefreshTokenResponse{
 			Success: false,
@@ -377,6 +720,8 @@ func (s *AuthServiceServerImpl) RefreshToken(ctx context.Context, req *authv1.Re
 		AccessToken:  accessToken,
 		RefreshToken: newRefreshToken,
 		ExpiresAt:    timestamppb.New(expiresAt),
+		Scope:        scope,
+		Audience:     audience,
 	}, nil
 }
 
@@ -416,22 +761,99 @@ func (s *AuthServiceServerImpl) RegisterClient(ctx context.Context, req *authv1.
 		}, nil
 	}
 
+	// Public clients cannot keep a secret, so none is issued and they cannot
+	// act on their own or on users' behalf
+	if req.Public && req.AllowTokenExchange {
+		return &authv1.RegisterClientResponse{
+			Success: false,
+			Message: "public clients cannot use token exchange",
+		}, nil
+	}
+
 	// Generate client ID and secret
 	clientID := utils.GenerateUUID()
-	clientSecret, err := utils.GenerateClientSecret()
-	if err != nil {
-		log.Printf("Error generating client secret: %v", err)
+	var clientSecret, clientSecretHash string
+	if !req.Public {
+		var err error
+		clientSecret, err = utils.GenerateClientSecret()
+		if err != nil {
+			log.Printf("Error generating client secret: %v", err)
+			return &authv1.RegisterClientResponse{
+				Success: false,
+				Message: "Internal server error",
+			}, nil
+		}
+		clientSecretHash = utils.HashToken(clientSecret)
+	}
+
+	if req.MaxSessionsPerUser < 0 {
 		return &authv1.RegisterClientResponse{
 			Success: false,
-			Message: "Internal server error",
+			Message: "max_sessions_per_user cannot be negative",
 		}, nil
 	}
 
-	// Create client
+	for _, scope := range req.AllowedScopes {
+		if !isValidScopeToken(scope) {
+			return &authv1.RegisterClientResponse{
+				Success: false,
+				Message: fmt.Sprintf("invalid scope: %q", scope),
+			}, nil
+		}
+	}
+
+	for _, audience := range req.AllowedAudiences {
+		if strings.TrimSpace(audience) == "" || strings.ContainsAny(audience, " \n") {
+			return &authv1.RegisterClientResponse{
+				Success: false,
+				Message: fmt.Sprintf("invalid audience: %q", audience),
+			}, nil
+		}
+	}
+
+	if err := validateRedirectURIs(req.RedirectUris); err != nil {
+		return &authv1.RegisterClientResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	// Joining a pool grants access to its users, so only an admin may do it.
+	// The caller is rejected the way AdminAuthInterceptor rejects them.
+	if req.IdentityPoolId != "" {
+		if err := authorizeAdmin(ctx); err != nil {
+			return nil, err
+		}
+		if len(req.IdentityPoolId) > 36 || strings.ContainsAny(req.IdentityPoolId, " \t\r\n") {
+			return &authv1.RegisterClientResponse{
+				Success: false,
+				Message: "identity_pool_id must be at most 36 characters without whitespace",
+			}, nil
+		}
+	}
+
+	if err := validateCustomAttributesSchema(req.CustomAttributesSchema); err != nil {
+		return &authv1.RegisterClientResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	// Create client; only the secret's hash is stored, so this response is the only copy
 	client := &models.Client{
-		ClientID:     clientID,
-		ClientName:   req.ClientName,
-		ClientSecret: clientSecret,
+		ClientID:                 clientID,
+		ClientName:               req.ClientName,
+		ClientSecretHash:         clientSecretHash,
+		Public:                   req.Public,
+		MaxSessionsPerUser:       int(req.MaxSessionsPerUser),
+		RedirectURIs:             strings.Join(req.RedirectUris, "\n"),
+		AllowedScopes:            strings.Join(req.AllowedScopes, " "),
+		AllowTokenExchange:       req.AllowTokenExchange,
+		AllowedAudiences:         strings.Join(req.AllowedAudiences, "\n"),
+		IdentityPoolID:           req.IdentityPoolId,
+		RequireEmailVerification: req.RequireEmailVerification,
+		CustomAttributesSchema:   req.CustomAttributesSchema,
+		EmbedCustomAttributes:    req.EmbedCustomAttributes,
 	}
 
 	if err := s.repo.CreateClient(ctx, client); err != nil {
@@ -462,22 +884,11 @@ func (s *AuthServiceServerImpl) ChangeUserPassword(ctx context.Context, req *aut
 	}
 
 	// Validate access token
-	claims, err := utils.ValidateJWTToken(req.AccessToken)
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
 	if err != nil {
-		log.Printf("Error validating JWT token: %v", err)
 		return &authv1.ChangeUserPasswordResponse{
 			Success: false,
-			Message: "Invalid access token",
-		}, nil
-	}
-
-	// Get user
-	user, err := s.repo.GetUserByID(ctx, claims.UserID)
-	if err != nil {
-		log.Printf("Error getting user by ID: %v", err)
-		return &authv1.ChangeUserPasswordResponse{
-			Success: false,
-			Message: "User not found",
+			Message: err.Error(),
 		}, nil
 	}
 
@@ -561,7 +972,115 @@ func (s *AuthServiceServerImpl) ChangeClientSecret(ctx context.Context, req *aut
 	}, nil
 }
 
+func (s *AuthServiceServerImpl) UpdateClientRedirectURIs(ctx context.Context, req *authv1.UpdateClientRedirectURIsRequest) (*authv1.UpdateClientRedirectURIsResponse, error) {
+	log.Printf("UpdateClientRedirectURIs request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" {
+		return &authv1.UpdateClientRedirectURIsResponse{Success: false, Message: "client_id and client_secret are required"}, nil
+	}
+
+	if _, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret); err != nil {
+		return &authv1.UpdateClientRedirectURIsResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	if err := validateRedirectURIs(req.RedirectUris); err != nil {
+		return &authv1.UpdateClientRedirectURIsResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := s.repo.UpdateClientRedirectURIs(ctx, req.ClientId, req.RedirectUris); err != nil {
+		log.Printf("Error updating client redirect URIs: %v", err)
+		return &authv1.UpdateClientRedirectURIsResponse{Success: false, Message: "Failed to update redirect URIs"}, nil
+	}
+
+	return &authv1.UpdateClientRedirectURIsResponse{
+		Success: true,
+		Message: "Redirect URIs updated successfully",
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) GetJWKS(ctx context.Context, in *emptypb.Empty) (*authv1.GetJWKSResponse, error) {
+	jwks, err := utils.PublicJWKS()
+	if err != nil {
+		log.Printf("Error building JWKS: %v", err)
+		return nil, status.Error(codes.Internal, "failed to load signing keys")
+	}
+
+	keys := make([]*authv1.JsonWebKey, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys = append(keys, &authv1.JsonWebKey{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return &authv1.GetJWKSResponse{Keys: keys}, nil
+}
+
 // Helper functions
+
+// detectRefreshTokenReuse revokes the whole token family when a superseded
+// refresh token is presented again (OAuth 2.0 Security BCP, section 4.14).
+// Either the legitimate client or an attacker holds a stale copy, and we
+// cannot tell which, so every token in the family is invalidated.
+func (s *AuthServiceServerImpl) detectRefreshTokenReuse(ctx context.Context, refreshToken string) {
+	superseded, err := s.repo.GetSupersededRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return
+	}
+
+	log.Printf("Refresh token reuse detected for user %s, revoking token family %s", superseded.UserID, superseded.FamilyID)
+	if err := s.repo.RevokeTokenFamily(ctx, superseded.FamilyID); err != nil {
+		log.Printf("Error revoking token family: %v", err)
+	}
+
+	s.recordSecurityEvent(ctx, models.SecurityEventRefreshTokenReuse, superseded.UserID, superseded.ClientID,
+		fmt.Sprintf("superseded refresh token replayed; token family %s revoked", superseded.FamilyID))
+}
+
+func userProfile(user *models.User) *authv1.UserProfile {
+	return &authv1.UserProfile{
+		UserId:           user.UserID,
+		Username:         user.UserName,
+		Email:            user.Email,
+		ClientId:         user.ClientID,
+		CreatedAt:        timestamppb.New(user.CreatedAt),
+		EmailVerified:    user.EmailVerified,
+		DisplayName:      user.DisplayName,
+		Locale:           user.Locale,
+		AvatarUrl:        user.AvatarURL,
+		CustomAttributes: user.CustomAttributes,
+	}
+}
+
+// maxSessionsPerUser returns the client's concurrent session cap, falling back
+// to DEFAULT_MAX_SESSIONS_PER_USER; 0 means unlimited
+func maxSessionsPerUser(client *models.Client) int {
+	if client.MaxSessionsPerUser > 0 {
+		return client.MaxSessionsPerUser
+	}
+	return envInt("DEFAULT_MAX_SESSIONS_PER_USER", 0)
+}
+
+func (s *AuthServiceServerImpl) recordSecurityEvent(ctx context.Context, eventType, userID, clientID, details string) {
+	event := &models.SecurityEvent{
+		EventID:   utils.GenerateUUID(),
+		EventType: eventType,
+		UserID:    userID,
+		ClientID:  clientID,
+		Details:   details,
+	}
+	if err := s.repo.CreateSecurityEvent(ctx, event); err != nil {
+		log.Printf("Error recording security event %s: %v", eventType, err)
+	}
+}
+
 func (s *AuthServiceServerImpl) validateUserRegistration(req *authv1.RegisterUserRequest) error {
 	if req.Username == "" {
 		return fmt.Errorf("username is required")
@@ -575,12 +1094,8 @@ func (s *AuthServiceServerImpl) validateUserRegistration(req *authv1.RegisterUse
 		return fmt.Errorf("invalid email format")
 	}
 
-	if req.Password == "" {
-		return fmt.Errorf("password is required")
-	}
-
-	if len(req.Password) < 8 {
-		return fmt.Errorf("password must be at least 8 characters long")
+	if err := validatePassword(req.Password); err != nil {
+		return err
 	}
 
 	if req.ClientId == "" {
@@ -590,6 +1105,18 @@ func (s *AuthServiceServerImpl) validateUserRegistration(req *authv1.RegisterUse
 	return nil
 }
 
+func validatePassword(password string) error {
+	if password == "" {
+		return fmt.Errorf("password is required")
+	}
+
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters long")
+	}
+
+	return nil
+}
+
 func (s *AuthServiceServerImpl) isValidEmail(email string) bool {
 	email = strings.TrimSpace(email)
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
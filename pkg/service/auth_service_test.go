@@ -2,7 +2,13 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +19,8 @@ import (
 	"authservice/pkg/utils"
 
 	sqlite "github.com/glebarez/sqlite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"gorm.io/gorm"
 )
@@ -33,9 +41,9 @@ func seedClient(t *testing.T, db *gorm.DB, clientID string) {
 	t.Helper()
 	repo := repository.NewAuthRepository(db)
 	if err := repo.CreateClient(context.Background(), &models.Client{
-		ClientID:     clientID,
-		ClientName:   "test-client",
-		ClientSecret: "secret",
+		ClientID:         clientID,
+		ClientName:       "test-client",
+		ClientSecretHash: utils.HashToken("secret"),
 	}); err != nil {
 		t.Fatalf("failed to seed client: %v", err)
 	}
@@ -61,18 +69,21 @@ func seedUser(t *testing.T, db *gorm.DB, userID, clientID, email, username, rawP
 	return user
 }
 
-func seedSession(t *testing.T, db *gorm.DB, userID, clientID, refreshToken string, expiresAt time.Time) {
+// seedSession creates a session and returns its ID, which access tokens for it carry
+func seedSession(t *testing.T, db *gorm.DB, userID, clientID, refreshToken string, expiresAt time.Time) string {
 	t.Helper()
 	repo := repository.NewAuthRepository(db)
-	if err := repo.CreateOrUpdateSession(context.Background(), &models.Session{
-		UserID:       userID,
-		ClientID:     clientID,
-		RefreshToken: refreshToken,
-		UserAgent:    "test-agent",
-		ExpiresAt:    expiresAt,
-	}); err != nil {
+	session := &models.Session{
+		UserID:           userID,
+		ClientID:         clientID,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		UserAgent:        "test-agent",
+		ExpiresAt:        expiresAt,
+	}
+	if err := repo.CreateOrUpdateSession(context.Background(), session); err != nil {
 		t.Fatalf("failed to seed session: %v", err)
 	}
+	return session.SessionID
 }
 
 func withJWTSecret(t *testing.T) func() {
@@ -158,6 +169,16 @@ func TestLoginUser_Success(t *testing.T) {
 		t.Fatalf("expected successful login with tokens, got success=%v msg=%s", resp.Success, resp.Message)
 	}
 
+	// The access token is bound to the session by ID and never carries the refresh token
+	claims, err := utils.ValidateJWTToken(resp.AccessToken)
+	if err != nil || claims.SessionID != resp.SessionId {
+		t.Fatalf("expected the access token to name its session, got err=%v claims=%+v", err, claims)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(resp.AccessToken, ".")[1])
+	if strings.Contains(string(payload), resp.RefreshToken) || strings.Contains(string(payload), "refresh_token") {
+		t.Fatalf("expected the access token not to carry the refresh token, got %s", payload)
+	}
+
 	// ensure session persisted
 	repo := repository.NewAuthRepository(db)
 	if _, err := repo.GetSessionByUserAndClient(context.Background(), "user-1", "client-1"); err != nil {
@@ -165,6 +186,22 @@ func TestLoginUser_Success(t *testing.T) {
 	}
 }
 
+func TestGetUserByEmail_MatchesEmail(t *testing.T) {
+	db := newTestDB(t)
+	repo := repository.NewAuthRepository(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	seedUser(t, db, "user-2", "client-1", "bob@example.com", "bob", "password123")
+
+	user, err := repo.GetUserByEmail(context.Background(), "client-1", "bob@example.com")
+	if err != nil || user.UserID != "user-2" {
+		t.Fatalf("expected the user with the email, got %+v (%v)", user, err)
+	}
+	if _, err := repo.GetUserByEmail(context.Background(), "client-1", "carol@example.com"); err == nil {
+		t.Fatalf("expected an unknown email not to match")
+	}
+}
+
 func TestValidateToken_Success(t *testing.T) {
 	cleanup := withJWTSecret(t)
 	defer cleanup()
@@ -174,10 +211,9 @@ func TestValidateToken_Success(t *testing.T) {
 	seedClient(t, db, "client-1")
 	user := seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
 
-	refresh := "refresh-abc"
-	seedSession(t, db, user.UserID, user.ClientID, refresh, time.Now().Add(24*time.Hour))
+	sessionID := seedSession(t, db, user.UserID, user.ClientID, "refresh-abc", time.Now().Add(24*time.Hour))
 
-	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, refresh)
+	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, sessionID)
 	if err != nil {
 		t.Fatalf("failed to generate jwt: %v", err)
 	}
@@ -203,9 +239,15 @@ func TestRefreshToken_Success(t *testing.T) {
 	oldRefresh := "refresh-old"
 	seedSession(t, db, user.UserID, user.ClientID, oldRefresh, time.Now().Add(24*time.Hour))
 
+	// A confidential client must prove its secret to redeem the token
+	if resp, _ := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{RefreshToken: oldRefresh, ClientId: user.ClientID}); resp.Success {
+		t.Fatalf("expected refresh without the client secret to fail")
+	}
+
 	resp, err := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
 		RefreshToken: oldRefresh,
 		ClientId:     user.ClientID,
+		ClientSecret: "secret",
 	})
 	if err != nil {
 		t.Fatalf("RefreshToken returned error: %v", err)
@@ -249,10 +291,9 @@ func TestGetUserProfile_Success(t *testing.T) {
 	user := seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
 
 	// Seed a matching session to satisfy ValidateToken's session check
-	refresh := "any-refresh"
-	seedSession(t, db, user.UserID, user.ClientID, refresh, time.Now().Add(24*time.Hour))
+	sessionID := seedSession(t, db, user.UserID, user.ClientID, "any-refresh", time.Now().Add(24*time.Hour))
 
-	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, refresh)
+	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, sessionID)
 	if err != nil {
 		t.Fatalf("failed to generate jwt: %v", err)
 	}
@@ -276,9 +317,9 @@ func TestChangePassword_Success(t *testing.T) {
 	user := seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "old-password")
 
 	// seed a session that should be invalidated
-	seedSession(t, db, user.UserID, user.ClientID, "refresh-to-be-removed", time.Now().Add(24*time.Hour))
+	sessionID := seedSession(t, db, user.UserID, user.ClientID, "refresh-to-be-removed", time.Now().Add(24*time.Hour))
 
-	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, "refresh-to-be-removed")
+	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, sessionID)
 	if err != nil {
 		t.Fatalf("failed to generate jwt: %v", err)
 	}
@@ -301,3 +342,375 @@ func TestChangePassword_Success(t *testing.T) {
 		t.Fatalf("expected user sessions to be deleted")
 	}
 }
+
+func TestChangePassword_DisabledUserRejected(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	user := seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "old-password")
+	sessionID := seedSession(t, db, user.UserID, user.ClientID, "refresh-1", time.Now().Add(24*time.Hour))
+	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, sessionID)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	if err := db.Model(&models.User{}).Where("user_id = ?", user.UserID).Update("status", models.UserStatusDisabled).Error; err != nil {
+		t.Fatalf("failed to disable user: %v", err)
+	}
+
+	resp, err := svc.ChangeUserPassword(context.Background(), &authv1.ChangeUserPasswordRequest{
+		AccessToken:     token,
+		CurrentPassword: "old-password",
+		NewPassword:     "new-password-123",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword returned error: %v", err)
+	}
+	if resp.Success {
+		t.Fatalf("expected a disabled user's token to be refused")
+	}
+}
+
+func withRSASigningKey(t *testing.T) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal RSA key: %v", err)
+	}
+	t.Setenv("JWT_SIGNING_ALG", utils.AlgorithmRS256)
+	t.Setenv("JWT_PRIVATE_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	t.Setenv("JWT_KEY_ID", "rsa-test-key")
+}
+
+func TestGetJWKS_RS256(t *testing.T) {
+	withRSASigningKey(t)
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	user := seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	sessionID := seedSession(t, db, user.UserID, user.ClientID, "refresh-rsa", time.Now().Add(24*time.Hour))
+
+	token, _, err := utils.GenerateJWTToken(user.UserID, user.UserName, user.ClientID, sessionID)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	validateResp, err := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: token})
+	if err != nil {
+		t.Fatalf("ValidateToken returned error: %v", err)
+	}
+	if !validateResp.Valid {
+		t.Fatalf("expected RS256 token to validate, got msg=%s", validateResp.Message)
+	}
+
+	jwksResp, err := svc.GetJWKS(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetJWKS returned error: %v", err)
+	}
+	if len(jwksResp.Keys) != 1 || jwksResp.Keys[0].Kid != "rsa-test-key" || jwksResp.Keys[0].Kty != "RSA" {
+		t.Fatalf("expected a single RSA key in JWKS, got %v", jwksResp.Keys)
+	}
+}
+
+func TestGetJWKS_HS256NotPublished(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	svc := NewAuthServiceServer(newTestDB(t))
+	resp, err := svc.GetJWKS(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetJWKS returned error: %v", err)
+	}
+	if len(resp.Keys) != 0 {
+		t.Fatalf("expected shared secrets to never be published, got %d keys", len(resp.Keys))
+	}
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	login, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: "client-1",
+	})
+	if err != nil || !login.Success {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, login)
+	}
+
+	rotated, err := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
+		RefreshToken: login.RefreshToken,
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+	})
+	if err != nil || !rotated.Success {
+		t.Fatalf("expected first refresh to succeed, got err=%v resp=%v", err, rotated)
+	}
+
+	// Replaying the superseded token must fail and revoke the whole family
+	replay, err := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
+		RefreshToken: login.RefreshToken,
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("RefreshToken returned error: %v", err)
+	}
+	if replay.Success {
+		t.Fatalf("expected replay of superseded refresh token to fail")
+	}
+
+	afterReplay, err := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
+		RefreshToken: rotated.RefreshToken,
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("RefreshToken returned error: %v", err)
+	}
+	if afterReplay.Success {
+		t.Fatalf("expected the current refresh token of a revoked family to be rejected")
+	}
+
+	repo := repository.NewAuthRepository(db)
+	events, err := repo.ListSecurityEventsByUser(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("failed to list security events: %v", err)
+	}
+	if len(events) != 1 || events[0].EventType != models.SecurityEventRefreshTokenReuse {
+		t.Fatalf("expected one refresh token reuse event, got %v", events)
+	}
+}
+
+func TestCredentialsStoredHashed(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("TOKEN_HASH_PEPPER", "")
+	if err := utils.CheckTokenHashPepper(); err == nil {
+		t.Fatalf("expected startup to require TOKEN_HASH_PEPPER")
+	}
+	t.Setenv("TOKEN_HASH_PEPPER", "test-pepper")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	repo := repository.NewAuthRepository(db)
+
+	registered, err := svc.RegisterClient(context.Background(), &authv1.RegisterClientRequest{ClientName: "app"})
+	if err != nil || !registered.Success {
+		t.Fatalf("expected client registration to succeed, got err=%v resp=%v", err, registered)
+	}
+	client, err := repo.GetClientByID(context.Background(), registered.ClientId)
+	if err != nil {
+		t.Fatalf("failed to load client: %v", err)
+	}
+	if client.ClientSecretHash == registered.ClientSecret {
+		t.Fatalf("expected client secret to be stored hashed")
+	}
+
+	rotated, err := svc.ChangeClientSecret(context.Background(), &authv1.ChangeClientSecretRequest{
+		ClientId:      registered.ClientId,
+		CurrentSecret: registered.ClientSecret,
+	})
+	if err != nil || !rotated.Success {
+		t.Fatalf("expected secret change with the raw secret to succeed, got err=%v resp=%v", err, rotated)
+	}
+	if _, err := repo.ValidateClient(context.Background(), registered.ClientId, registered.ClientSecret); err == nil {
+		t.Fatalf("expected old client secret to be rejected")
+	}
+
+	seedUser(t, db, "user-1", registered.ClientId, "alice@example.com", "alice", "password123")
+	login, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: registered.ClientId,
+	})
+	if err != nil || !login.Success {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, login)
+	}
+
+	var count int64
+	db.Model(&models.Session{}).Where("refresh_token = ?", login.RefreshToken).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected refresh token to be stored hashed")
+	}
+	if _, err := repo.GetSessionByRefreshToken(context.Background(), login.RefreshToken); err != nil {
+		t.Fatalf("expected session lookup by raw refresh token to succeed: %v", err)
+	}
+}
+
+func TestRegisterClient_Public(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	repo := repository.NewAuthRepository(db)
+
+	if resp, _ := svc.RegisterClient(context.Background(), &authv1.RegisterClientRequest{ClientName: "spa", Public: true, AllowTokenExchange: true}); resp.Success {
+		t.Fatalf("expected a public client to be refused token exchange")
+	}
+	registered, err := svc.RegisterClient(context.Background(), &authv1.RegisterClientRequest{ClientName: "spa", Public: true})
+	if err != nil || !registered.Success || registered.ClientSecret != "" {
+		t.Fatalf("expected a public client to be registered without a secret, got err=%v resp=%v", err, registered)
+	}
+	client, err := repo.GetClientByID(context.Background(), registered.ClientId)
+	if err != nil || !client.Public {
+		t.Fatalf("expected the client to be stored as public, got err=%v client=%v", err, client)
+	}
+	if _, err := repo.ValidateClient(context.Background(), registered.ClientId, ""); err == nil {
+		t.Fatalf("expected a public client never to authenticate with a secret")
+	}
+	if resp, _ := svc.ChangeClientSecret(context.Background(), &authv1.ChangeClientSecretRequest{ClientId: registered.ClientId}); resp.Success {
+		t.Fatalf("expected a public client to be refused a secret")
+	}
+}
+
+func TestGetToken_ConcurrentSessions(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	login := func(device string) *authv1.GetTokenResponse {
+		resp, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+			Email:       "alice@example.com",
+			Password:    "password123",
+			ClientId:    "client-1",
+			UserAgent:   "unit-test",
+			DeviceLabel: device,
+		})
+		if err != nil || !resp.Success {
+			t.Fatalf("expected login on %s to succeed, got err=%v resp=%v", device, err, resp)
+		}
+		return resp
+	}
+
+	laptop := login("laptop")
+	phone := login("phone")
+	if laptop.SessionId == phone.SessionId {
+		t.Fatalf("expected each login to create its own session")
+	}
+
+	// Logging in on the phone must not log the laptop out
+	for _, token := range []string{laptop.AccessToken, phone.AccessToken} {
+		resp, err := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: token})
+		if err != nil || !resp.Valid {
+			t.Fatalf("expected both sessions to stay valid, got err=%v resp=%v", err, resp)
+		}
+	}
+}
+
+func TestGetToken_SessionCapEvictsOldest(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	if err := db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("max_sessions_per_user", 2).Error; err != nil {
+		t.Fatalf("failed to set session cap: %v", err)
+	}
+
+	var logins []*authv1.GetTokenResponse
+	for i := 0; i < 3; i++ {
+		resp, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+			Email:    "alice@example.com",
+			Password: "password123",
+			ClientId: "client-1",
+		})
+		if err != nil || !resp.Success {
+			t.Fatalf("expected login to succeed, got err=%v resp=%v", err, resp)
+		}
+		logins = append(logins, resp)
+		time.Sleep(10 * time.Millisecond) // keep created_at ordering deterministic
+	}
+
+	var count int64
+	db.Model(&models.Session{}).Where("user_id = ?", "user-1").Count(&count)
+	if count != 2 {
+		t.Fatalf("expected session cap to keep 2 sessions, got %d", count)
+	}
+
+	oldest, err := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: logins[0].AccessToken})
+	if err != nil {
+		t.Fatalf("ValidateToken returned error: %v", err)
+	}
+	if oldest.Valid {
+		t.Fatalf("expected the oldest session to be evicted")
+	}
+}
+
+func TestRegisterUser_EmailUniquePerIdentityPool(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+
+	register := func(clientID string) *authv1.RegisterUserResponse {
+		resp, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{
+			Username: "alice", Email: "alice@example.com", Password: "password123", ClientId: clientID,
+		})
+		return resp
+	}
+	if resp := register("client-1"); !resp.Success {
+		t.Fatalf("expected registration to succeed, got %v", resp)
+	}
+	if resp := register("client-1"); resp.Success {
+		t.Fatalf("expected a duplicate email within a client to be rejected")
+	}
+	if resp := register("client-2"); !resp.Success {
+		t.Fatalf("expected the same email to register with another client, got %v", resp)
+	}
+}
+
+func TestSharedIdentityPool(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	if _, err := svc.RegisterClient(context.Background(), &authv1.RegisterClientRequest{ClientName: "mobile", IdentityPoolId: "client-1"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected joining an identity pool to require the admin key, got %v", err)
+	}
+	joined, _ := svc.RegisterClient(adminContext(t), &authv1.RegisterClientRequest{ClientName: "mobile", IdentityPoolId: "client-1"})
+	if !joined.Success {
+		t.Fatalf("expected an admin to register a pooled client, got %v", joined)
+	}
+	seedClient(t, db, "client-3")
+
+	// Pool members share accounts; tokens name the client the user signed in to
+	login := loginAs(t, svc, "alice@example.com", "password123", joined.ClientId, "phone")
+	validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: login.AccessToken})
+	if !validate.Valid || validate.UserId != "user-1" || validate.ClientId != joined.ClientId {
+		t.Fatalf("expected a token for the pooled client, got %v", validate)
+	}
+	if dup, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{
+		Username: "alice2", Email: "alice@example.com", Password: "password123", ClientId: joined.ClientId,
+	}); dup.Success {
+		t.Fatalf("expected the email to be taken across the pool")
+	}
+	if outside, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email: "alice@example.com", Password: "password123", ClientId: "client-3",
+	}); outside.Success {
+		t.Fatalf("expected clients outside the pool not to see the user")
+	}
+}
@@ -44,15 +44,60 @@ func (c *CleanupService) Start() {
 	}()
 }
 
+// cleanupStep is one task of a cleanup run. Steps are independent, so one
+// failing does not hold up the others.
+type cleanupStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func (c *CleanupService) cleanupSteps() []cleanupStep {
+	return []cleanupStep{
+		{"expired sessions", c.repo.DeleteExpiredSessions},
+		{"superseded refresh tokens", c.repo.DeleteExpiredSupersededRefreshTokens},
+		{"authorization codes", c.repo.DeleteExpiredAuthorizationCodes},
+		{"device authorizations", c.repo.DeleteExpiredDeviceAuthorizations},
+		{"invitations", c.repo.DeleteExpiredInvitations},
+		{"user tokens", c.repo.DeleteExpiredUserTokens},
+		{"sign-in throttles", func(ctx context.Context) error {
+			return c.repo.DeleteStaleSignInThrottles(ctx, time.Now().Add(-signInFailureMemory))
+		}},
+		{"rate limit buckets", func(ctx context.Context) error {
+			return c.repo.DeleteIdleRateLimitBuckets(ctx, time.Now().Add(-rateLimitBucketIdle))
+		}},
+		{"locked users", func(ctx context.Context) error {
+			unlocked, err := c.repo.UnlockExpiredUsers(ctx)
+			if unlocked > 0 {
+				log.Printf("Unlocked %d users whose lock ended", unlocked)
+			}
+			return err
+		}},
+		// Deleted accounts are erased once they can no longer be restored
+		{"deleted users", func(ctx context.Context) error {
+			purged, err := c.repo.PurgeDeletedUsers(ctx, time.Now().Add(-accountDeletionGracePeriod()))
+			if purged > 0 {
+				log.Printf("Purged %d deleted users", purged)
+			}
+			return err
+		}},
+	}
+}
+
 func (c *CleanupService) cleanupExpiredSessions(ctx context.Context) {
 	log.Println("Starting cleanup of expired sessions...")
 
-	err := c.repo.DeleteExpiredSessions(ctx)
-	if err != nil {
-		log.Printf("Error cleaning up expired sessions: %v", err)
-		return
+	failed := 0
+	for _, step := range c.cleanupSteps() {
+		if err := step.run(ctx); err != nil {
+			log.Printf("Error cleaning up %s: %v", step.name, err)
+			failed++
+		}
 	}
 
+	if failed > 0 {
+		log.Printf("Expired sessions cleanup completed with %d failed steps", failed)
+		return
+	}
 	log.Println("Expired sessions cleanup completed")
 }
 
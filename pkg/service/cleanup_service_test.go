@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+)
+
+func TestCleanup_FailingStepDoesNotStopTheRest(t *testing.T) {
+	db := newTestDB(t)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	if err := db.Create(&models.UserToken{
+		TokenHash: "expired-token",
+		UserID:    "user-1",
+		Purpose:   "verify_email",
+		Email:     "alice@example.com",
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}).Error; err != nil {
+		t.Fatalf("failed to seed user token: %v", err)
+	}
+
+	// The invitations step runs before the user tokens step and now fails
+	if err := db.Migrator().DropTable(&models.Invitation{}); err != nil {
+		t.Fatalf("failed to drop invitations: %v", err)
+	}
+	NewCleanupService(db).cleanupExpiredSessions(context.Background())
+
+	var count int64
+	db.Model(&models.UserToken{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected the expired user token to be removed despite the failed step, found %d", count)
+	}
+}
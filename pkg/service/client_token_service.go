@@ -0,0 +1,116 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthServiceServerImpl) GetClientToken(ctx context.Context, req *authv1.GetClientTokenRequest) (*authv1.GetClientTokenResponse, error) {
+	log.Printf("GetClientToken request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" {
+		return &authv1.GetClientTokenResponse{Success: false, Message: "client_id and client_secret are required"}, nil
+	}
+
+	client, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return &authv1.GetClientTokenResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	resp, err := s.issueClientToken(client, req.Scope, req.Audience)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			return &authv1.GetClientTokenResponse{Success: false, Message: oauthErr.Description}, nil
+		}
+		log.Printf("Error issuing client token: %v", err)
+		return &authv1.GetClientTokenResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Client token issued for client: %s", client.ClientID)
+	return resp, nil
+}
+
+// issueClientToken signs a token for an already authenticated client. Client
+// tokens are stateless: they cannot be refreshed and expire after
+// CLIENT_TOKEN_TTL_MINUTES (default 60).
+func (s *AuthServiceServerImpl) issueClientToken(client *models.Client, requestedScope string, requestedAudience []string) (*authv1.GetClientTokenResponse, error) {
+	scope, err := resolveClientScope(client, requestedScope)
+	if err != nil {
+		return nil, err
+	}
+	audience, err := resolveAudience(client.AllowedAudienceList(), requestedAudience)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(envInt("CLIENT_TOKEN_TTL_MINUTES", 60)) * time.Minute
+	accessToken, expiresAt, err := utils.GenerateClientToken(client.ClientID, scope, audience, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authv1.GetClientTokenResponse{
+		Success:     true,
+		Message:     "Client token issued",
+		AccessToken: accessToken,
+		ExpiresAt:   timestamppb.New(expiresAt),
+		Scope:       scope,
+		Audience:    audience,
+	}, nil
+}
+
+// resolveClientScope narrows the requested scopes to the client's allowed
+// scopes like resolveUserScope, except that the OIDC scopes are not on offer
+// since there is no user behind the token
+func resolveClientScope(client *models.Client, requestedScope string) (string, error) {
+	if len(strings.Fields(requestedScope)) == 0 {
+		return strings.Join(client.AllowedScopeList(), " "), nil
+	}
+	return intersectScope(client.AllowedScopeList(), requestedScope)
+}
+
+// validateClientToken completes ValidateToken for client principals; the
+// token stays valid only while the client is still registered
+func (s *AuthServiceServerImpl) validateClientToken(ctx context.Context, claims *utils.Claims) *authv1.ValidateTokenResponse {
+	if claims.Subject != claims.ClientID {
+		log.Printf("Subject mismatch in client token claims")
+		return &authv1.ValidateTokenResponse{Valid: false, Message: "Invalid token claims"}
+	}
+
+	if _, err := s.repo.GetClientByID(ctx, claims.ClientID); err != nil {
+		log.Printf("Error getting client by ID: %v", err)
+		return &authv1.ValidateTokenResponse{Valid: false, Message: "Client not found"}
+	}
+
+	return &authv1.ValidateTokenResponse{
+		Valid:         true,
+		Message:       "Token is valid",
+		ExpiresAt:     timestamppb.New(claims.ExpiresAt.Time),
+		PrincipalType: utils.PrincipalTypeClient,
+		ClientId:      claims.ClientID,
+		Scope:         claims.Scope,
+		Audience:      claims.Audience,
+	}
+}
+
+// isValidScopeToken checks the scope-token syntax of RFC 6749 section 3.3
+func isValidScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestGetClientToken_ValidatesAsClientPrincipal(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("allowed_scopes", "reports:read reports:write")
+
+	resp, err := svc.GetClientToken(context.Background(), &authv1.GetClientTokenRequest{
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+		Scope:        "reports:read",
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected client token, got err=%v resp=%v", err, resp)
+	}
+	if resp.Scope != "reports:read" {
+		t.Fatalf("expected granted scope reports:read, got %q", resp.Scope)
+	}
+
+	validate, err := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: resp.AccessToken})
+	if err != nil || !validate.Valid {
+		t.Fatalf("expected client token to validate, got err=%v resp=%v", err, validate)
+	}
+	if validate.PrincipalType != utils.PrincipalTypeClient || validate.ClientId != "client-1" || validate.Scope != "reports:read" {
+		t.Fatalf("unexpected client principal: %v", validate)
+	}
+	if validate.UserId != "" || validate.User != nil {
+		t.Fatalf("client principal must not carry a user")
+	}
+
+	// Client tokens cannot act for a user
+	list, _ := svc.ListSessions(context.Background(), &authv1.ListSessionsRequest{AccessToken: resp.AccessToken})
+	if list.Success {
+		t.Fatalf("expected ListSessions to reject a client token")
+	}
+}
+
+func TestGetClientToken_Rejections(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("allowed_scopes", "reports:read")
+
+	resp, _ := svc.GetClientToken(context.Background(), &authv1.GetClientTokenRequest{ClientId: "client-1", ClientSecret: "wrong"})
+	if resp.Success {
+		t.Fatalf("expected wrong secret to be rejected")
+	}
+
+	resp, _ = svc.GetClientToken(context.Background(), &authv1.GetClientTokenRequest{ClientId: "client-1", ClientSecret: "secret", Scope: "admin"})
+	if resp.Success {
+		t.Fatalf("expected scope outside the allowed set to be rejected")
+	}
+
+	// Like user logins, a partly allowed request is narrowed rather than rejected
+	resp, _ = svc.GetClientToken(context.Background(), &authv1.GetClientTokenRequest{ClientId: "client-1", ClientSecret: "secret", Scope: "admin reports:read openid"})
+	if !resp.Success || resp.Scope != "reports:read" {
+		t.Fatalf("expected the scope to be narrowed to reports:read, got %q (msg=%s)", resp.Scope, resp.Message)
+	}
+}
+
+func TestTokenEndpoint_ClientCredentials(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("allowed_scopes", "reports:read")
+	handler := NewHTTPHandler(svc)
+
+	rec := postToken(handler, url.Values{"grant_type": {"client_credentials"}, "client_id": {"client-1"}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected client_credentials without a secret to be rejected, got %d", rec.Code)
+	}
+
+	rec = postToken(handler, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"client-1"},
+		"client_secret": {"secret"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected token response, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("invalid token response: %v", err)
+	}
+	if tokens["scope"] != "reports:read" || tokens["refresh_token"] != nil {
+		t.Fatalf("unexpected token response: %v", tokens)
+	}
+}
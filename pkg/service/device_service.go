@@ -0,0 +1,378 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// deviceCodeGrantType is the grant_type of device token polls (RFC 8628 section 3.4)
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet avoids vowels and look-alike characters, so user codes
+// are easy to type and never spell words (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const userCodeLength = 8
+
+// slowDownIncrement is added to the poll interval each time a device polls
+// too fast (RFC 8628 section 3.5)
+const slowDownIncrement = 5
+
+func (s *AuthServiceServerImpl) StartDeviceAuthorization(ctx context.Context, req *authv1.StartDeviceAuthorizationRequest) (*authv1.StartDeviceAuthorizationResponse, error) {
+	log.Printf("StartDeviceAuthorization request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.StartDeviceAuthorizationResponse{Success: false, Message: "client_id is required"}, nil
+	}
+
+	caller, oauthErr := s.authenticateClient(ctx, req.ClientId, req.ClientSecret)
+	if oauthErr != nil {
+		return &authv1.StartDeviceAuthorizationResponse{Success: false, Message: oauthErr.Description}, nil
+	}
+
+	resp, err := s.startDeviceAuthorization(ctx, caller.Client, req.Scope)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			return &authv1.StartDeviceAuthorizationResponse{Success: false, Message: oauthErr.Description}, nil
+		}
+		log.Printf("Error starting device authorization: %v", err)
+		return &authv1.StartDeviceAuthorizationResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	return resp, nil
+}
+
+func (s *AuthServiceServerImpl) PollDeviceToken(ctx context.Context, req *authv1.PollDeviceTokenRequest) (*authv1.GetTokenResponse, error) {
+	log.Printf("PollDeviceToken request received for client: %s", req.ClientId)
+
+	if _, oauthErr := s.authenticateClient(ctx, req.ClientId, req.ClientSecret); oauthErr != nil {
+		return &authv1.GetTokenResponse{Success: false, Message: oauthErr.Code}, nil
+	}
+	resp, err := s.pollDeviceToken(ctx, req)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			// The RFC 8628 code tells the device whether to keep polling
+			return &authv1.GetTokenResponse{Success: false, Message: oauthErr.Code}, nil
+		}
+		log.Printf("Error polling device token: %v", err)
+		return &authv1.GetTokenResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	return resp, nil
+}
+
+// startDeviceAuthorization issues a device code for the device to poll with
+// and a short user code for the user to enter on the verification page. The
+// page's URL is built from PUBLIC_BASE_URL, which must be set.
+func (s *AuthServiceServerImpl) startDeviceAuthorization(ctx context.Context, client *models.Client, requestedScope string) (*authv1.StartDeviceAuthorizationResponse, error) {
+	baseURL, err := requirePublicBaseURL()
+	if err != nil {
+		log.Printf("Device authorization requested but %v", err)
+		return nil, newOAuthError("server_error", "Device authorization is not configured")
+	}
+	scope, err := resolveUserScope(client, requestedScope)
+	if err != nil {
+		return nil, err
+	}
+	deviceCode, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(envInt("DEVICE_CODE_TTL_MINUTES", 10)) * time.Minute
+	interval := envInt("DEVICE_POLL_INTERVAL_SECONDS", 5)
+	authorization := &models.DeviceAuthorization{
+		DeviceCodeHash: utils.HashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       client.ClientID,
+		Scope:          scope,
+		Status:         models.DeviceAuthorizationPending,
+		IntervalSecs:   interval,
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+	if err := s.repo.CreateDeviceAuthorization(ctx, authorization); err != nil {
+		return nil, err
+	}
+
+	verificationURI := baseURL + "/device"
+	return &authv1.StartDeviceAuthorizationResponse{
+		Success:                 true,
+		Message:                 "Device authorization started",
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationUri:         verificationURI,
+		VerificationUriComplete: verificationURI + "?user_code=" + url.QueryEscape(formatUserCode(userCode)),
+		ExpiresIn:               int32(ttl.Seconds()),
+		Interval:                int32(interval),
+	}, nil
+}
+
+// pollDeviceToken answers a device's poll: authorization_pending until the
+// user decides, slow_down when polled faster than the interval, and the
+// token pair exactly once after approval
+func (s *AuthServiceServerImpl) pollDeviceToken(ctx context.Context, req *authv1.PollDeviceTokenRequest) (*authv1.GetTokenResponse, error) {
+	if req.ClientId == "" || req.DeviceCode == "" {
+		return nil, newOAuthError("invalid_request", "client_id and device_code are required")
+	}
+
+	authorization, err := s.repo.GetDeviceAuthorizationByDeviceCode(ctx, req.DeviceCode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newOAuthError("invalid_grant", "Invalid device code")
+		}
+		return nil, err
+	}
+	if authorization.ClientID != req.ClientId {
+		return nil, newOAuthError("invalid_grant", "Device code was not issued to this client")
+	}
+
+	now := time.Now()
+	if now.After(authorization.ExpiresAt) {
+		return nil, newOAuthError("expired_token", "Device code has expired")
+	}
+
+	interval := authorization.IntervalSecs
+	tooFast := authorization.LastPolledAt != nil && now.Sub(*authorization.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooFast {
+		interval += slowDownIncrement
+	}
+	if err := s.repo.RecordDevicePoll(ctx, authorization.DeviceCodeHash, now, interval); err != nil {
+		return nil, err
+	}
+	if tooFast {
+		return nil, newOAuthError("slow_down", fmt.Sprintf("Polling too fast; wait %d seconds between requests", interval))
+	}
+
+	switch authorization.Status {
+	case models.DeviceAuthorizationPending:
+		return nil, newOAuthError("authorization_pending", "The user has not yet approved the request")
+	case models.DeviceAuthorizationDenied:
+		return nil, newOAuthError("access_denied", "The user denied the request")
+	case models.DeviceAuthorizationConsumed:
+		return nil, newOAuthError("invalid_grant", "Device code has already been used")
+	}
+
+	consumed, err := s.repo.ConsumeDeviceAuthorization(ctx, authorization.DeviceCodeHash)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, newOAuthError("invalid_grant", "Device code has already been used")
+	}
+
+	client, err := s.repo.GetClientByID(ctx, authorization.ClientID)
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "Invalid client ID")
+	}
+	user, err := s.repo.GetUserByID(ctx, authorization.UserID)
+	if err != nil {
+		return nil, newOAuthError("invalid_grant", "User not found")
+	}
+
+	// The scope was resolved against the client when the grant started
+	resp, err := s.issueSessionTokens(ctx, user, client, authorization.Scope, client.AllowedAudienceList(), req.UserAgent, req.DeviceLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Device authorization completed for user: %s", user.UserID)
+	return resp, nil
+}
+
+type devicePage struct {
+	UserCode  string
+	Email     string
+	Error     string
+	Message   string
+	CSRFToken string
+}
+
+// handleDevice serves the verification page where the user enters the code
+// shown on their device
+func (s *AuthServiceServerImpl) handleDevice(w http.ResponseWriter, r *http.Request) {
+	setAuthorizePageHeaders(w)
+
+	csrfToken, err := setCSRFCookie(w, r, "/device")
+	if err != nil {
+		log.Printf("Error generating CSRF token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	renderDevicePage(w, http.StatusOK, &devicePage{
+		UserCode:  r.URL.Query().Get("user_code"),
+		CSRFToken: csrfToken,
+	})
+}
+
+// handleDeviceSubmit signs the user in and records their decision on the
+// device authorization identified by the user code
+func (s *AuthServiceServerImpl) handleDeviceSubmit(w http.ResponseWriter, r *http.Request) {
+	setAuthorizePageHeaders(w)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		renderAuthorizeError(w, "malformed request")
+		return
+	}
+	csrfToken, ok := verifyCSRF(r)
+	if !ok {
+		renderAuthorizeError(w, "your sign-in form expired, please start again")
+		return
+	}
+
+	ctx := withHTTPClientIP(r.Context(), r)
+	page := &devicePage{
+		UserCode:  r.PostForm.Get("user_code"),
+		Email:     r.PostForm.Get("email"),
+		CSRFToken: csrfToken,
+	}
+
+	authorization, err := s.repo.GetPendingDeviceAuthorization(ctx, normalizeUserCode(page.UserCode))
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error getting device authorization: %v", err)
+		}
+		page.Error = "That code is invalid or has expired"
+		renderDevicePage(w, http.StatusBadRequest, page)
+		return
+	}
+
+	client, err := s.repo.GetClientByID(ctx, authorization.ClientID)
+	if err != nil {
+		page.Error = "That code is invalid or has expired"
+		renderDevicePage(w, http.StatusBadRequest, page)
+		return
+	}
+
+	user, err := s.authenticateUser(ctx, client, page.Email, r.PostForm.Get("password"))
+	if err != nil {
+		page.Error = loginErrorText(err)
+		renderDevicePage(w, http.StatusUnauthorized, page)
+		return
+	}
+
+	status := models.DeviceAuthorizationDenied
+	page.Message = "Request denied"
+	if r.PostForm.Get("decision") == "allow" {
+		status = models.DeviceAuthorizationApproved
+		page.Message = "Device connected to " + client.ClientName
+	}
+
+	resolved, err := s.repo.ResolveDeviceAuthorization(ctx, authorization.DeviceCodeHash, user.UserID, status)
+	if err != nil {
+		log.Printf("Error resolving device authorization: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !resolved {
+		page.Message = ""
+		page.Error = "That code is invalid or has expired"
+		renderDevicePage(w, http.StatusBadRequest, page)
+		return
+	}
+
+	log.Printf("Device authorization %s by user: %s", status, user.UserID)
+	renderDevicePage(w, http.StatusOK, page)
+}
+
+// handleDeviceAuthorization is the RFC 8628 device authorization endpoint
+func (s *AuthServiceServerImpl) handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError("invalid_request", "malformed request body"))
+		return
+	}
+
+	ctx := withHTTPClientIP(r.Context(), r)
+	caller, oauthErr := s.authenticateTokenClient(ctx, r)
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr)
+		return
+	}
+	resp, err := s.startDeviceAuthorization(ctx, caller.Client, r.PostForm.Get("scope"))
+	if err != nil {
+		var deviceErr *oauthError
+		if !errors.As(err, &deviceErr) {
+			log.Printf("Error starting device authorization: %v", err)
+			deviceErr = newOAuthError("server_error", "Internal server error")
+		}
+		writeOAuthError(w, deviceErr)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               resp.DeviceCode,
+		"user_code":                 resp.UserCode,
+		"verification_uri":          resp.VerificationUri,
+		"verification_uri_complete": resp.VerificationUriComplete,
+		"expires_in":                resp.ExpiresIn,
+		"interval":                  resp.Interval,
+	})
+}
+
+func (s *AuthServiceServerImpl) tokenFromDeviceCode(ctx context.Context, r *http.Request, client *tokenClient) (*tokenEndpointResponse, error) {
+	resp, err := s.pollDeviceToken(ctx, &authv1.PollDeviceTokenRequest{
+		ClientId:   client.ClientID,
+		DeviceCode: r.PostForm.Get("device_code"),
+		UserAgent:  r.UserAgent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newTokenEndpointResponse(resp.AccessToken, resp.ExpiresAt, resp.RefreshToken, resp.Scope), nil
+}
+
+func renderDevicePage(w http.ResponseWriter, statusCode int, page *devicePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := templates.ExecuteTemplate(w, "device.html", page); err != nil {
+		log.Printf("Error rendering device page: %v", err)
+	}
+}
+
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits a user code in two halves for display, e.g. WDJB-MJHT
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode accepts user input in any case and with separators
+func normalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(input) {
+		if c >= 'A' && c <= 'Z' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
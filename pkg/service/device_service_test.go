@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+)
+
+// approveDevice drives the verification page for the given user code
+func approveDevice(t *testing.T, handler http.Handler, userCode, email, password, decision string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device?user_code="+url.QueryEscape(userCode), nil))
+	match := csrfFieldPattern.FindStringSubmatch(rec.Body.String())
+	if rec.Code != http.StatusOK || match == nil {
+		t.Fatalf("expected verification page with CSRF token, got %d", rec.Code)
+	}
+
+	form := url.Values{
+		"csrf_token": {match[1]},
+		"user_code":  {userCode},
+		"email":      {email},
+		"password":   {password},
+		"decision":   {decision},
+	}
+	post := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range rec.Result().Cookies() {
+		post.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, post)
+	return rec
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("DEVICE_POLL_INTERVAL_SECONDS", "0")
+	t.Setenv("PUBLIC_BASE_URL", "https://auth.example.com/")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	handler := NewHTTPHandler(svc)
+
+	if start, _ := svc.StartDeviceAuthorization(context.Background(), &authv1.StartDeviceAuthorizationRequest{ClientId: "client-1"}); start.Success {
+		t.Fatalf("expected a client holding a secret to be required to present it")
+	}
+	start, err := svc.StartDeviceAuthorization(context.Background(), &authv1.StartDeviceAuthorizationRequest{ClientId: "client-1", ClientSecret: "secret"})
+	if err != nil || !start.Success {
+		t.Fatalf("expected device authorization to start, got err=%v resp=%v", err, start)
+	}
+	if len(start.UserCode) != 9 || start.VerificationUri != "https://auth.example.com/device" ||
+		start.VerificationUriComplete != start.VerificationUri+"?user_code="+start.UserCode {
+		t.Fatalf("unexpected device authorization response: %v", start)
+	}
+
+	poll := &authv1.PollDeviceTokenRequest{ClientId: "client-1", DeviceCode: start.DeviceCode}
+	resp, _ := svc.PollDeviceToken(context.Background(), poll)
+	if resp.Success || resp.Message != "invalid_client" {
+		t.Fatalf("expected polling without the client secret to fail, got %v", resp)
+	}
+	poll.ClientSecret = "secret"
+	resp, _ = svc.PollDeviceToken(context.Background(), poll)
+	if resp.Success || resp.Message != "authorization_pending" {
+		t.Fatalf("expected authorization_pending, got %v", resp)
+	}
+
+	rec := approveDevice(t, handler, strings.ToLower(start.UserCode), "alice@example.com", "wrong-password", "allow")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected bad credentials to be rejected, got %d", rec.Code)
+	}
+	rec = approveDevice(t, handler, strings.ToLower(start.UserCode), "alice@example.com", "password123", "allow")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Device connected") {
+		t.Fatalf("expected approval to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	resp, _ = svc.PollDeviceToken(context.Background(), poll)
+	if !resp.Success || resp.AccessToken == "" || resp.User.UserId != "user-1" {
+		t.Fatalf("expected tokens after approval, got %v", resp)
+	}
+
+	resp, _ = svc.PollDeviceToken(context.Background(), poll)
+	if resp.Success {
+		t.Fatalf("expected device code to be single-use")
+	}
+}
+
+func TestDeviceAuthorization_SlowDownAndDeny(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("PUBLIC_BASE_URL", "https://auth.example.com")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	// Devices that cannot keep a secret register as public clients
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Updates(map[string]interface{}{"public": true, "client_secret": ""})
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	start, _ := svc.StartDeviceAuthorization(context.Background(), &authv1.StartDeviceAuthorizationRequest{ClientId: "client-1"})
+	poll := &authv1.PollDeviceTokenRequest{ClientId: "client-1", DeviceCode: start.DeviceCode}
+
+	svc.PollDeviceToken(context.Background(), poll)
+	resp, _ := svc.PollDeviceToken(context.Background(), poll)
+	if resp.Message != "slow_down" {
+		t.Fatalf("expected slow_down for an immediate second poll, got %v", resp)
+	}
+	var authorization models.DeviceAuthorization
+	db.First(&authorization)
+	if authorization.IntervalSecs != int(start.Interval)+slowDownIncrement {
+		t.Fatalf("expected interval to grow to %d, got %d", start.Interval+slowDownIncrement, authorization.IntervalSecs)
+	}
+
+	rec := approveDevice(t, NewHTTPHandler(svc), start.UserCode, "alice@example.com", "password123", "deny")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected denial to be recorded, got %d", rec.Code)
+	}
+
+	db.Model(&models.DeviceAuthorization{}).Where("1 = 1").Update("last_polled_at", time.Now().Add(-time.Minute))
+	resp, _ = svc.PollDeviceToken(context.Background(), poll)
+	if resp.Message != "access_denied" {
+		t.Fatalf("expected access_denied, got %v", resp)
+	}
+}
+
+func TestDeviceAuthorization_RequiresPublicBaseURL(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("PUBLIC_BASE_URL", "")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+
+	// The verification URI must never come from the Host header
+	start, _ := svc.StartDeviceAuthorization(context.Background(), &authv1.StartDeviceAuthorizationRequest{ClientId: "client-1", ClientSecret: "secret"})
+	if start.Success || start.VerificationUri != "" {
+		t.Fatalf("expected device authorization to require PUBLIC_BASE_URL, got %v", start)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/device_authorization", strings.NewReader(url.Values{"client_id": {"client-1"}, "client_secret": {"secret"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "evil.example.com"
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(svc).ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "evil.example.com") {
+		t.Fatalf("expected the HTTP endpoint to refuse too, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var count int64
+	db.Model(&models.DeviceAuthorization{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no device authorization to be stored, got %d", count)
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthServiceServerImpl) ChangeEmail(ctx context.Context, req *authv1.ChangeEmailRequest) (*authv1.ChangeEmailResponse, error) {
+	log.Printf("ChangeEmail request received for new email: %s", req.NewEmail)
+
+	if req.CurrentPassword == "" || req.NewEmail == "" {
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Current password and new email are required"}, nil
+	}
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.ChangeEmailResponse{Success: false, Message: err.Error()}, nil
+	}
+	if !utils.CheckPasswordHash(req.CurrentPassword, user.Password) {
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Current password is incorrect"}, nil
+	}
+	if !s.isValidEmail(req.NewEmail) {
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Invalid email format"}, nil
+	}
+	if strings.EqualFold(req.NewEmail, user.Email) {
+		return &authv1.ChangeEmailResponse{Success: false, Message: "New email must differ from the current one"}, nil
+	}
+
+	emailExists, err := s.repo.IsEmailExists(ctx, user.IdentityPoolID, req.NewEmail)
+	if err != nil {
+		log.Printf("Error checking email existence: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if emailExists {
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Email already registered"}, nil
+	}
+
+	confirmation, confirmationToken, err := newEmailChangeToken(user, req.NewEmail, models.UserTokenEmailChange,
+		time.Duration(envInt("EMAIL_CHANGE_TTL_HOURS", 24))*time.Hour)
+	if err != nil {
+		log.Printf("Error generating email change token: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	undo, undoToken, err := newEmailChangeToken(user, req.NewEmail, models.UserTokenEmailChangeUndo,
+		time.Duration(envInt("EMAIL_CHANGE_UNDO_TTL_HOURS", 168))*time.Hour)
+	if err != nil {
+		log.Printf("Error generating email change undo token: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if err := s.repo.CreateEmailChangeTokens(ctx, confirmation, undo); err != nil {
+		log.Printf("Error storing email change tokens: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	// The owner of the current address hears about the change before it can happen
+	if err := s.mailer.Send(ctx, emailChangeNoticeMessage(user, req.NewEmail, undoToken, undo.ExpiresAt)); err != nil {
+		log.Printf("Error sending email change notice: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Failed to notify the current address"}, nil
+	}
+	if err := s.mailer.Send(ctx, emailChangeConfirmationMessage(user, req.NewEmail, confirmationToken, confirmation.ExpiresAt)); err != nil {
+		log.Printf("Error sending email change confirmation: %v", err)
+		return &authv1.ChangeEmailResponse{Success: false, Message: "Failed to send confirmation email"}, nil
+	}
+
+	log.Printf("Email change requested for user: %s", user.UserID)
+	return &authv1.ChangeEmailResponse{
+		Success:   true,
+		Message:   "Confirmation email sent to the new address",
+		ExpiresAt: timestamppb.New(confirmation.ExpiresAt),
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) ConfirmEmailChange(ctx context.Context, req *authv1.ConfirmEmailChangeRequest) (*authv1.ConfirmEmailChangeResponse, error) {
+	log.Printf("ConfirmEmailChange request received")
+
+	if req.Token == "" {
+		return &authv1.ConfirmEmailChangeResponse{Success: false, Message: "Token is required"}, nil
+	}
+
+	userToken, err := s.repo.ConfirmEmailChange(ctx, req.Token)
+	if err != nil {
+		if msg := emailChangeErrorMessage(err); msg != "" {
+			return &authv1.ConfirmEmailChangeResponse{Success: false, Message: msg}, nil
+		}
+		log.Printf("Error confirming email change: %v", err)
+		return &authv1.ConfirmEmailChangeResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	s.recordSecurityEvent(ctx, models.SecurityEventEmailChanged, userToken.UserID, "",
+		fmt.Sprintf("email changed from %s to %s", userToken.Email, userToken.NewEmail))
+	log.Printf("Email changed for user: %s", userToken.UserID)
+	return &authv1.ConfirmEmailChangeResponse{
+		Success: true,
+		Message: "Email changed successfully",
+		UserId:  userToken.UserID,
+		Email:   userToken.NewEmail,
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) UndoEmailChange(ctx context.Context, req *authv1.UndoEmailChangeRequest) (*authv1.UndoEmailChangeResponse, error) {
+	log.Printf("UndoEmailChange request received")
+
+	if req.Token == "" {
+		return &authv1.UndoEmailChangeResponse{Success: false, Message: "Token is required"}, nil
+	}
+
+	userToken, err := s.repo.UndoEmailChange(ctx, req.Token)
+	if err != nil {
+		if msg := emailChangeErrorMessage(err); msg != "" {
+			return &authv1.UndoEmailChangeResponse{Success: false, Message: msg}, nil
+		}
+		log.Printf("Error undoing email change: %v", err)
+		return &authv1.UndoEmailChangeResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	s.recordSecurityEvent(ctx, models.SecurityEventEmailChangeUndone, userToken.UserID, "",
+		fmt.Sprintf("email restored to %s; all sessions revoked", userToken.Email))
+	log.Printf("Email change undone for user: %s", userToken.UserID)
+	return &authv1.UndoEmailChangeResponse{
+		Success: true,
+		Message: "Email address restored. Please log in again and consider resetting your password.",
+		UserId:  userToken.UserID,
+		Email:   userToken.Email,
+	}, nil
+}
+
+// newEmailChangeToken creates a token of the purpose for moving the user
+// from their current address to newEmail; it returns the record and the raw token
+func newEmailChangeToken(user *models.User, newEmail, purpose string, ttl time.Duration) (*models.UserToken, string, error) {
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	return &models.UserToken{
+		TokenHash: utils.HashToken(token),
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(ttl),
+	}, token, nil
+}
+
+// emailChangeErrorMessage maps the expected email change failures to
+// response messages; it returns "" for internal errors
+func emailChangeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, repository.ErrUserTokenInvalid):
+		return "Invalid or expired token"
+	case errors.Is(err, repository.ErrEmailTaken):
+		return "Email already registered"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	authv1 "authservice/proto/auth/v1"
+)
+
+var (
+	emailChangeTokenPattern = regexp.MustCompile(`email change token: ([0-9a-f]+)`)
+	undoTokenPattern        = regexp.MustCompile(`undo token: ([0-9a-f]+)`)
+)
+
+func TestChangeEmail_ConfirmsNewAddress(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	seedUser(t, db, "user-2", "client-1", "bob@example.com", "bob", "password123")
+	alice := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	change := func(password, email string) *authv1.ChangeEmailResponse {
+		resp, _ := svc.ChangeEmail(context.Background(), &authv1.ChangeEmailRequest{AccessToken: alice.AccessToken, CurrentPassword: password, NewEmail: email})
+		return resp
+	}
+	if resp := change("wrong-password", "alice@new.example.com"); resp.Success {
+		t.Fatalf("expected the current password to be required")
+	}
+	if resp := change("password123", "bob@example.com"); resp.Success {
+		t.Fatalf("expected a taken address to be rejected")
+	}
+	if resp := change("password123", "alice@new.example.com"); !resp.Success {
+		t.Fatalf("expected the change to be requested, got %v", resp)
+	}
+	mailedToken(t, mail, "alice@example.com", undoTokenPattern)
+	token := mailedToken(t, mail, "alice@new.example.com", emailChangeTokenPattern)
+
+	confirmed, _ := svc.ConfirmEmailChange(context.Background(), &authv1.ConfirmEmailChangeRequest{Token: token})
+	if !confirmed.Success || confirmed.Email != "alice@new.example.com" {
+		t.Fatalf("expected the change to be confirmed, got %v", confirmed)
+	}
+	if again, _ := svc.ConfirmEmailChange(context.Background(), &authv1.ConfirmEmailChangeRequest{Token: token}); again.Success {
+		t.Fatalf("expected the confirmation token to be single-use")
+	}
+
+	if old, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"}); old.Success {
+		t.Fatalf("expected the old address to stop working")
+	}
+	login := loginAs(t, svc, "alice@new.example.com", "password123", "client-1", "laptop")
+	info, _ := svc.GetUserInfo(context.Background(), &authv1.GetUserInfoRequest{AccessToken: login.AccessToken})
+	if info.Email != "alice@new.example.com" || !info.EmailVerified {
+		t.Fatalf("expected the confirmed address to be verified, got %v", info)
+	}
+}
+
+func TestUndoEmailChange_RestoresOriginalAddress(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	// An attacker holding the password moves the account twice, collecting the
+	// undo token for the intermediate address
+	moveTo := func(from, to string) {
+		session := loginAs(t, svc, from, "password123", "client-1", "attacker")
+		if resp, _ := svc.ChangeEmail(context.Background(), &authv1.ChangeEmailRequest{AccessToken: session.AccessToken, CurrentPassword: "password123", NewEmail: to}); !resp.Success {
+			t.Fatalf("expected the change to %s to be requested, got %v", to, resp)
+		}
+		token := mailedToken(t, mail, to, emailChangeTokenPattern)
+		if resp, _ := svc.ConfirmEmailChange(context.Background(), &authv1.ConfirmEmailChangeRequest{Token: token}); !resp.Success {
+			t.Fatalf("expected the change to %s to be confirmed, got %v", to, resp)
+		}
+	}
+	moveTo("alice@example.com", "mallory@example.com")
+	ownerUndo := mailedToken(t, mail, "alice@example.com", undoTokenPattern)
+	moveTo("mallory@example.com", "mallory2@example.com")
+	attackerUndo := mailedToken(t, mail, "mallory@example.com", undoTokenPattern)
+	attacker := loginAs(t, svc, "mallory2@example.com", "password123", "client-1", "attacker")
+
+	undone, _ := svc.UndoEmailChange(context.Background(), &authv1.UndoEmailChangeRequest{Token: ownerUndo})
+	if !undone.Success || undone.Email != "alice@example.com" {
+		t.Fatalf("expected the original address to be restored, got %v", undone)
+	}
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: attacker.AccessToken}); validate.Valid {
+		t.Fatalf("expected undoing the change to revoke every session")
+	}
+	if resp, _ := svc.UndoEmailChange(context.Background(), &authv1.UndoEmailChangeRequest{Token: attackerUndo}); resp.Success {
+		t.Fatalf("expected newer undo tokens to be revoked by the owner's undo")
+	}
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"authservice/pkg/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// NewHTTPHandler exposes the HTTP endpoints that standard OAuth/JOSE tooling
+// expects alongside the gRPC API
+func NewHTTPHandler(authServer *AuthServiceServerImpl) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/jwks.json", authServer.handleJWKS)
+	mux.HandleFunc("GET /.well-known/openid-configuration", authServer.handleOpenIDConfiguration)
+	mux.HandleFunc("GET /oauth/authorize", authServer.handleAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", authServer.handleAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", authServer.handleToken)
+	mux.HandleFunc("POST /oauth/device_authorization", authServer.handleDeviceAuthorization)
+	mux.HandleFunc("GET /device", authServer.handleDevice)
+	mux.HandleFunc("POST /device", authServer.handleDeviceSubmit)
+	mux.HandleFunc("POST /oauth/introspect", authServer.handleIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", authServer.handleRevoke)
+	mux.HandleFunc("GET /oauth/userinfo", authServer.handleUserInfo)
+	mux.HandleFunc("POST /oauth/userinfo", authServer.handleUserInfo)
+	return mux
+}
+
+func (s *AuthServiceServerImpl) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, err := utils.PublicJWKS()
+	if err != nil {
+		log.Printf("Error building JWKS: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	// Let verifiers cache the key set, but pick up rotations reasonably quickly
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, jwks)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
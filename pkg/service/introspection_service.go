@@ -0,0 +1,275 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Token type hints (RFC 7009 section 2.1, RFC 7662 section 2.1)
+const (
+	tokenTypeAccessToken  = "access_token"
+	tokenTypeRefreshToken = "refresh_token"
+)
+
+func (s *AuthServiceServerImpl) IntrospectToken(ctx context.Context, req *authv1.IntrospectTokenRequest) (*authv1.IntrospectTokenResponse, error) {
+	log.Printf("IntrospectToken request received from client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" || req.Token == "" {
+		return &authv1.IntrospectTokenResponse{Success: false, Message: "client_id, client_secret and token are required"}, nil
+	}
+
+	client, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return &authv1.IntrospectTokenResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	return s.introspectToken(ctx, client, req.Token, req.TokenTypeHint), nil
+}
+
+func (s *AuthServiceServerImpl) RevokeOAuthToken(ctx context.Context, req *authv1.RevokeOAuthTokenRequest) (*authv1.RevokeTokenResponse, error) {
+	log.Printf("RevokeOAuthToken request received from client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" || req.Token == "" {
+		return &authv1.RevokeTokenResponse{Success: false, Message: "client_id, client_secret and token are required"}, nil
+	}
+
+	client, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return &authv1.RevokeTokenResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	if err := s.revokeToken(ctx, client, req.Token, req.TokenTypeHint); err != nil {
+		log.Printf("Error revoking token: %v", err)
+		return &authv1.RevokeTokenResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	return &authv1.RevokeTokenResponse{Success: true, Message: "Token revoked successfully"}, nil
+}
+
+// introspectToken describes a token using the same checks as ValidateToken.
+// Any authenticated client may introspect access tokens, as resource servers
+// and gateways must; refresh tokens are only described to the client they
+// were issued to. Everything else is reported as inactive.
+func (s *AuthServiceServerImpl) introspectToken(ctx context.Context, client *models.Client, token, tokenTypeHint string) *authv1.IntrospectTokenResponse {
+	// The hint only decides which lookup runs first (RFC 7662 section 2.1)
+	if tokenTypeHint == tokenTypeRefreshToken {
+		if resp := s.introspectRefreshToken(ctx, client, token); resp != nil {
+			return resp
+		}
+		if resp := s.introspectAccessToken(ctx, token); resp != nil {
+			return resp
+		}
+	} else {
+		if resp := s.introspectAccessToken(ctx, token); resp != nil {
+			return resp
+		}
+		if resp := s.introspectRefreshToken(ctx, client, token); resp != nil {
+			return resp
+		}
+	}
+	return &authv1.IntrospectTokenResponse{Success: true, Message: "Token is not active", Active: false}
+}
+
+func (s *AuthServiceServerImpl) introspectAccessToken(ctx context.Context, token string) *authv1.IntrospectTokenResponse {
+	if !looksLikeJWT(token) {
+		return nil
+	}
+	claims, validation := s.validateAccessToken(ctx, token)
+	if claims == nil {
+		return nil
+	}
+
+	resp := &authv1.IntrospectTokenResponse{
+		Success:        true,
+		Message:        "Token is active",
+		Active:         true,
+		Scope:          claims.Scope,
+		ClientId:       claims.ClientID,
+		Username:       claims.Username,
+		TokenType:      tokenTypeAccessToken,
+		Sub:            claims.Subject,
+		Aud:            claims.Audience,
+		Iss:            claims.Issuer,
+		PrincipalType:  validation.PrincipalType,
+		Actor:          validation.Actor,
+		OrganizationId: validation.OrganizationId,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		resp.Nbf = claims.NotBefore.Unix()
+	}
+	return resp
+}
+
+func (s *AuthServiceServerImpl) introspectRefreshToken(ctx context.Context, client *models.Client, token string) *authv1.IntrospectTokenResponse {
+	session, err := s.repo.GetSessionByRefreshToken(ctx, token)
+	if err != nil || session.ClientID != client.ClientID || time.Now().After(session.ExpiresAt) {
+		return nil
+	}
+	user, err := s.repo.GetUserByID(ctx, session.UserID)
+	if err != nil || userStatusError(user) != nil {
+		return nil
+	}
+
+	return &authv1.IntrospectTokenResponse{
+		Success:       true,
+		Message:       "Token is active",
+		Active:        true,
+		ClientId:      session.ClientID,
+		Username:      user.UserName,
+		TokenType:     tokenTypeRefreshToken,
+		Exp:           session.ExpiresAt.Unix(),
+		Sub:           session.UserID,
+		Iss:           utils.Issuer(),
+		PrincipalType: utils.PrincipalTypeUser,
+	}
+}
+
+// revokeToken ends the session behind a refresh or user access token issued
+// to the client (RFC 7009 section 2.1). Tokens that are invalid, unknown or
+// belong to another client are ignored, so callers learn nothing about them.
+// Client credential tokens are stateless and simply expire.
+func (s *AuthServiceServerImpl) revokeToken(ctx context.Context, client *models.Client, token, tokenTypeHint string) error {
+	var session *models.Session
+	if looksLikeJWT(token) && tokenTypeHint != tokenTypeRefreshToken {
+		claims, err := utils.ValidateJWTToken(token)
+		if err != nil || claims.IsClientPrincipal() || claims.ClientID != client.ClientID {
+			return nil
+		}
+		if session, err = s.sessionForClaims(ctx, claims); err != nil {
+			return nil
+		}
+	} else {
+		var err error
+		if session, err = s.repo.GetSessionByRefreshToken(ctx, token); err != nil {
+			return nil
+		}
+	}
+
+	if session.ClientID != client.ClientID {
+		return nil
+	}
+	if _, err := s.repo.DeleteUserSession(ctx, session.UserID, session.SessionID); err != nil {
+		return err
+	}
+	log.Printf("Session %s revoked by client %s", session.SessionID, client.ClientID)
+	return nil
+}
+
+// looksLikeJWT distinguishes our JWT access tokens from opaque refresh tokens
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// introspectionResponse is the RFC 7662 section 2.2 JSON response
+type introspectionResponse struct {
+	Active        bool     `json:"active"`
+	Scope         string   `json:"scope,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
+	Username      string   `json:"username,omitempty"`
+	TokenType     string   `json:"token_type,omitempty"`
+	Exp           int64    `json:"exp,omitempty"`
+	Iat           int64    `json:"iat,omitempty"`
+	Nbf           int64    `json:"nbf,omitempty"`
+	Sub           string   `json:"sub,omitempty"`
+	Aud           []string `json:"aud,omitempty"`
+	Iss           string   `json:"iss,omitempty"`
+	PrincipalType string   `json:"principal_type,omitempty"`
+	Act           *actor   `json:"act,omitempty"`
+	OrgID         string   `json:"org_id,omitempty"`
+}
+
+// actor is the act member of an introspection response (RFC 8693 section 4.1)
+type actor struct {
+	Sub string `json:"sub"`
+}
+
+// handleIntrospect is the RFC 7662 introspection endpoint
+func (s *AuthServiceServerImpl) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.parseClientAuthenticatedForm(w, r)
+	if !ok {
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, newOAuthError("invalid_request", "token is required"))
+		return
+	}
+
+	resp := s.introspectToken(withHTTPClientIP(r.Context(), r), client, token, r.PostForm.Get("token_type_hint"))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, &introspectionResponse{
+		Active:        resp.Active,
+		Scope:         resp.Scope,
+		ClientID:      resp.ClientId,
+		Username:      resp.Username,
+		TokenType:     resp.TokenType,
+		Exp:           resp.Exp,
+		Iat:           resp.Iat,
+		Nbf:           resp.Nbf,
+		Sub:           resp.Sub,
+		Aud:           resp.Aud,
+		Iss:           resp.Iss,
+		PrincipalType: resp.PrincipalType,
+		Act:           introspectionActor(resp.Actor),
+		OrgID:         resp.OrganizationId,
+	})
+}
+
+func introspectionActor(subject string) *actor {
+	if subject == "" {
+		return nil
+	}
+	return &actor{Sub: subject}
+}
+
+// handleRevoke is the RFC 7009 revocation endpoint
+func (s *AuthServiceServerImpl) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.parseClientAuthenticatedForm(w, r)
+	if !ok {
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, newOAuthError("invalid_request", "token is required"))
+		return
+	}
+
+	if err := s.revokeToken(withHTTPClientIP(r.Context(), r), client, token, r.PostForm.Get("token_type_hint")); err != nil {
+		log.Printf("Error revoking token: %v", err)
+		writeOAuthError(w, newOAuthError("server_error", "Internal server error"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseClientAuthenticatedForm parses a form post from a confidential client,
+// writing the error response itself when that fails
+func (s *AuthServiceServerImpl) parseClientAuthenticatedForm(w http.ResponseWriter, r *http.Request) (*models.Client, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError("invalid_request", "malformed request body"))
+		return nil, false
+	}
+
+	caller, oauthErr := s.authenticateTokenClient(withHTTPClientIP(r.Context(), r), r)
+	if oauthErr == nil && !caller.Authenticated {
+		oauthErr = newOAuthError("invalid_client", "client authentication is required")
+	}
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr)
+		return nil, false
+	}
+	return caller.Client, true
+}
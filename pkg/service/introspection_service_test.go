@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestIntrospectToken_AccessAndRefreshTokens(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "gateway")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	// Any authenticated client may introspect access tokens
+	resp, err := svc.IntrospectToken(context.Background(), &authv1.IntrospectTokenRequest{
+		ClientId:     "gateway",
+		ClientSecret: "secret",
+		Token:        login.AccessToken,
+	})
+	if err != nil || !resp.Success || !resp.Active {
+		t.Fatalf("expected active access token, got err=%v resp=%v", err, resp)
+	}
+	if resp.Sub != "user-1" || resp.ClientId != "client-1" || resp.TokenType != tokenTypeAccessToken || resp.Exp == 0 {
+		t.Fatalf("unexpected introspection response: %v", resp)
+	}
+
+	// Refresh tokens are only described to the client they were issued to
+	resp, _ = svc.IntrospectToken(context.Background(), &authv1.IntrospectTokenRequest{
+		ClientId:      "gateway",
+		ClientSecret:  "secret",
+		Token:         login.RefreshToken,
+		TokenTypeHint: tokenTypeRefreshToken,
+	})
+	if resp.Active {
+		t.Fatalf("expected another client's refresh token to be inactive")
+	}
+	resp, _ = svc.IntrospectToken(context.Background(), &authv1.IntrospectTokenRequest{
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+		Token:        login.RefreshToken,
+	})
+	if !resp.Active || resp.TokenType != tokenTypeRefreshToken {
+		t.Fatalf("expected active refresh token, got %v", resp)
+	}
+
+	resp, _ = svc.IntrospectToken(context.Background(), &authv1.IntrospectTokenRequest{
+		ClientId:     "gateway",
+		ClientSecret: "wrong",
+		Token:        login.AccessToken,
+	})
+	if resp.Success || resp.Active {
+		t.Fatalf("expected unauthenticated introspection to be rejected")
+	}
+}
+
+func TestRevokeOAuthToken(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	// Another client cannot revoke the token, but is not told so
+	resp, err := svc.RevokeOAuthToken(context.Background(), &authv1.RevokeOAuthTokenRequest{
+		ClientId:     "client-2",
+		ClientSecret: "secret",
+		Token:        login.RefreshToken,
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected revocation request to succeed, got err=%v resp=%v", err, resp)
+	}
+	validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: login.AccessToken})
+	if !validate.Valid {
+		t.Fatalf("expected token to survive revocation by another client")
+	}
+
+	// Revoking the access token ends its session, refresh token included
+	resp, _ = svc.RevokeOAuthToken(context.Background(), &authv1.RevokeOAuthTokenRequest{
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+		Token:        login.AccessToken,
+	})
+	if !resp.Success {
+		t.Fatalf("expected revocation to succeed, got %v", resp)
+	}
+	validate, _ = svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: login.AccessToken})
+	if validate.Valid {
+		t.Fatalf("expected access token to be invalid after revocation")
+	}
+	refresh, _ := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{RefreshToken: login.RefreshToken, ClientId: "client-1", ClientSecret: "secret"})
+	if refresh.Success {
+		t.Fatalf("expected refresh token to be revoked with its session")
+	}
+}
+
+func TestIntrospectAndRevokeEndpoints(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	handler := NewHTTPHandler(svc)
+
+	post := func(path string, form url.Values, authenticate bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authenticate {
+			req.SetBasicAuth("client-1", "secret")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/oauth/introspect", url.Values{"token": {login.AccessToken}}, false)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected introspection without client credentials to be rejected, got %d", rec.Code)
+	}
+
+	rec = post("/oauth/introspect", url.Values{"token": {login.AccessToken}}, true)
+	var body map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || body["active"] != true || body["sub"] != "user-1" {
+		t.Fatalf("expected active token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = post("/oauth/revoke", url.Values{"token": {login.RefreshToken}, "token_type_hint": {"refresh_token"}}, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected revocation to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = post("/oauth/introspect", url.Values{"token": {login.AccessToken}}, true)
+	body = map[string]interface{}{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["active"] != false || len(body) != 1 {
+		t.Fatalf("expected only active=false for a revoked token, got %s", rec.Body.String())
+	}
+}
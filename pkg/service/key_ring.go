@@ -0,0 +1,364 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LegacyKeyID is assigned to a JWT_SECRET/JWT_PRIVATE_KEY imported without a
+// kid, so tokens issued before the key ring existed keep verifying
+const LegacyKeyID = "legacy"
+
+// KeyRing is a database backed utils.KeyProvider. It signs with the single
+// active key and verifies with the active key plus any verify-only keys still
+// inside their grace period. Other replicas pick up rotations on refresh.
+type KeyRing struct {
+	repo *repository.AuthRepository
+
+	algorithm        string
+	gracePeriod      time.Duration
+	rotationInterval time.Duration
+	refreshInterval  time.Duration
+
+	mu       sync.RWMutex
+	active   *utils.SigningKey
+	keys     map[string]*utils.SigningKey
+	loadedAt time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewKeyRing creates a key ring configured from the environment:
+//
+//	JWT_SIGNING_ALG               algorithm for newly generated keys (default HS256)
+//	KEY_ROTATION_INTERVAL_HOURS   scheduled rotation interval, 0 disables (default 0)
+//	KEY_GRACE_PERIOD_HOURS        how long a rotated-out key keeps verifying (default 48)
+func NewKeyRing(db *gorm.DB) *KeyRing {
+	algorithm := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALG"))
+	if algorithm == "" {
+		algorithm = utils.AlgorithmHS256
+	}
+
+	return &KeyRing{
+		repo:             repository.NewAuthRepository(db),
+		algorithm:        algorithm,
+		gracePeriod:      time.Duration(envInt("KEY_GRACE_PERIOD_HOURS", 48)) * time.Hour,
+		rotationInterval: time.Duration(envInt("KEY_ROTATION_INTERVAL_HOURS", 0)) * time.Hour,
+		refreshInterval:  time.Minute,
+		keys:             map[string]*utils.SigningKey{},
+		stop:             make(chan struct{}),
+	}
+}
+
+// Load reads the usable keys from the database. On first start it imports the
+// key configured in the environment, or generates one if none is configured.
+// With SIGNING_KEY_ENCRYPTION_KEY set, keys stored in plaintext are encrypted.
+func (k *KeyRing) Load(ctx context.Context) error {
+	sealing, err := utils.SigningKeyEncryptionEnabled()
+	if err != nil {
+		return err
+	}
+	records, err := k.repo.ListUsableSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !hasActiveKey(records) {
+		if err := k.bootstrap(ctx); err != nil {
+			return err
+		}
+		if records, err = k.repo.ListUsableSigningKeys(ctx); err != nil {
+			return err
+		}
+	}
+
+	keys := make(map[string]*utils.SigningKey, len(records))
+	var active *utils.SigningKey
+	for i := range records {
+		key, err := signingKeyFromModel(&records[i])
+		if err != nil {
+			log.Printf("Skipping unusable signing key %s: %v", records[i].KeyID, err)
+			continue
+		}
+		keys[key.KeyID] = key
+		if records[i].Status == models.SigningKeyStatusActive {
+			active = key
+		}
+		if sealing && records[i].PrivateKey != "" && !utils.IsSealedPrivateKey(records[i].PrivateKey) {
+			k.sealPrivateKey(ctx, &records[i])
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("key ring has no usable active key")
+	}
+
+	k.mu.Lock()
+	k.active = active
+	k.keys = keys
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeyRing) bootstrap(ctx context.Context) error {
+	key, err := (&utils.EnvKeyProvider{}).SigningKey()
+	if err != nil {
+		log.Printf("No signing key configured in environment (%v); generating a new %s key", err, k.algorithm)
+		if key, err = utils.GenerateSigningKey(k.algorithm); err != nil {
+			return err
+		}
+	} else if key.KeyID == "" {
+		key.KeyID = LegacyKeyID
+	}
+
+	record, err := signingKeyToModel(key)
+	if err != nil {
+		return err
+	}
+	// Activate unconditionally; if another replica raced us the newest key wins
+	if _, err := k.repo.RotateSigningKey(ctx, record, time.Now().Add(k.gracePeriod), time.Now()); err != nil {
+		return err
+	}
+	log.Printf("Initialized signing key ring with key %s (%s)", record.KeyID, record.Algorithm)
+	return nil
+}
+
+// sealPrivateKey encrypts a key stored before encryption was configured
+func (k *KeyRing) sealPrivateKey(ctx context.Context, record *models.SigningKey) {
+	sealed, err := utils.SealPrivateKey(record.KeyID, record.PrivateKey)
+	if err == nil {
+		err = k.repo.UpdateSigningKeyPrivateKey(ctx, record.KeyID, record.PrivateKey, sealed)
+	}
+	if err != nil {
+		log.Printf("Error encrypting signing key %s: %v", record.KeyID, err)
+		return
+	}
+	log.Printf("Encrypted signing key %s at rest", record.KeyID)
+}
+
+// Rotate generates a new active key; the previous active key stays valid for
+// verification until the grace period ends. An empty algorithm keeps the
+// configured one. Nil is returned when another rotation replaced the active
+// key between reading it and committing this one.
+func (k *KeyRing) Rotate(ctx context.Context, algorithm string) (*models.SigningKey, error) {
+	if algorithm == "" {
+		algorithm = k.algorithm
+	}
+	activatedAt, err := k.activeKeyActivation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Only a key activated after the observed one blocks the rotation
+	return k.rotate(ctx, algorithm, activatedAt.Add(time.Nanosecond))
+}
+
+// activeKeyActivation returns when the currently stored active key was
+// activated, or the current time when there is none
+func (k *KeyRing) activeKeyActivation(ctx context.Context) (time.Time, error) {
+	records, err := k.repo.ListUsableSigningKeys(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, record := range records {
+		if record.Status == models.SigningKeyStatusActive && record.ActivatedAt != nil {
+			return *record.ActivatedAt, nil
+		}
+	}
+	return time.Now(), nil
+}
+
+func (k *KeyRing) rotate(ctx context.Context, algorithm string, activatedBefore time.Time) (*models.SigningKey, error) {
+	key, err := utils.GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	record, err := signingKeyToModel(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := k.repo.RotateSigningKey(ctx, record, time.Now().Add(k.gracePeriod), activatedBefore)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, nil
+	}
+
+	log.Printf("Rotated signing key; new active key %s (%s)", record.KeyID, record.Algorithm)
+	return record, k.Load(ctx)
+}
+
+// ListKeys returns every key in the ring, including retired ones
+func (k *KeyRing) ListKeys(ctx context.Context) ([]models.SigningKey, error) {
+	return k.repo.ListSigningKeys(ctx)
+}
+
+// Start runs the scheduled rotation, retirement and refresh loop
+func (k *KeyRing) Start() {
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		ticker := time.NewTicker(k.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				k.maintain(ctx)
+				cancel()
+			case <-k.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (k *KeyRing) maintain(ctx context.Context) {
+	if retired, err := k.repo.RetireExpiredSigningKeys(ctx); err != nil {
+		log.Printf("Error retiring expired signing keys: %v", err)
+	} else if retired > 0 {
+		log.Printf("Retired %d signing keys", retired)
+	}
+
+	if k.rotationInterval > 0 {
+		if _, err := k.rotate(ctx, k.algorithm, time.Now().Add(-k.rotationInterval)); err != nil {
+			log.Printf("Error running scheduled key rotation: %v", err)
+		}
+	}
+
+	if err := k.Load(ctx); err != nil {
+		log.Printf("Error refreshing signing keys: %v", err)
+	}
+}
+
+func (k *KeyRing) Stop() {
+	close(k.stop)
+	k.wg.Wait()
+}
+
+// SigningKey implements utils.KeyProvider
+func (k *KeyRing) SigningKey() (*utils.SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == nil {
+		return nil, fmt.Errorf("key ring is not loaded")
+	}
+	return k.active, nil
+}
+
+// VerificationKey implements utils.KeyProvider
+func (k *KeyRing) VerificationKey(kid string) (*utils.SigningKey, error) {
+	if kid == "" {
+		kid = LegacyKeyID
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.loadedAt) > k.refreshInterval
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	// The key may have been rotated in by another replica since our last load
+	if stale {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := k.Load(ctx); err != nil {
+			return nil, err
+		}
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// PublicKeys implements utils.KeyProvider
+func (k *KeyRing) PublicKeys() ([]*utils.SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*utils.SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.Algorithm != utils.AlgorithmHS256 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func hasActiveKey(records []models.SigningKey) bool {
+	for _, record := range records {
+		if record.Status == models.SigningKeyStatusActive {
+			return true
+		}
+	}
+	return false
+}
+
+func signingKeyToModel(key *utils.SigningKey) (*models.SigningKey, error) {
+	privateKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	if privateKey, err = utils.SealPrivateKey(key.KeyID, privateKey); err != nil {
+		return nil, err
+	}
+	record := &models.SigningKey{
+		KeyID:      key.KeyID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: privateKey,
+	}
+	if key.Algorithm != utils.AlgorithmHS256 {
+		if record.PublicKey, err = key.MarshalPublicKeyPEM(); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func signingKeyFromModel(record *models.SigningKey) (*utils.SigningKey, error) {
+	privateKey, err := utils.OpenPrivateKey(record.KeyID, record.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if record.Algorithm == utils.AlgorithmHS256 {
+		secret, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return &utils.SigningKey{KeyID: record.KeyID, Algorithm: record.Algorithm, Secret: secret}, nil
+	}
+	if privateKey != "" {
+		return utils.NewAsymmetricSigningKey(record.Algorithm, record.KeyID, privateKey)
+	}
+	return utils.NewVerificationKey(record.Algorithm, record.KeyID, record.PublicKey)
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+		log.Printf("Invalid int for %s=%q, using default %d", key, v, fallback)
+	}
+	return fallback
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (a *AdminServiceServerImpl) RotateSigningKey(ctx context.Context, req *authv1.RotateSigningKeyRequest) (*authv1.RotateSigningKeyResponse, error) {
+	log.Printf("RotateSigningKey request received")
+
+	switch req.Algorithm {
+	case "", utils.AlgorithmHS256, utils.AlgorithmRS256, utils.AlgorithmES256, utils.AlgorithmEdDSA:
+	default:
+		return &authv1.RotateSigningKeyResponse{Success: false, Message: "Unsupported algorithm"}, nil
+	}
+
+	key, err := a.auth.keyRing.Rotate(ctx, req.Algorithm)
+	if err != nil {
+		log.Printf("Error rotating signing key: %v", err)
+		return &authv1.RotateSigningKeyResponse{Success: false, Message: "Failed to rotate signing key"}, nil
+	}
+	if key == nil {
+		return &authv1.RotateSigningKeyResponse{Success: false, Message: "Signing key was rotated concurrently, try again"}, nil
+	}
+
+	log.Printf("Signing key rotated: %s", key.KeyID)
+	return &authv1.RotateSigningKeyResponse{
+		Success:   true,
+		Message:   "Signing key rotated successfully",
+		KeyId:     key.KeyID,
+		Algorithm: key.Algorithm,
+	}, nil
+}
+
+func (a *AdminServiceServerImpl) ListSigningKeys(ctx context.Context, in *emptypb.Empty) (*authv1.ListSigningKeysResponse, error) {
+	log.Printf("ListSigningKeys request received")
+
+	keys, err := a.auth.keyRing.ListKeys(ctx)
+	if err != nil {
+		log.Printf("Error listing signing keys: %v", err)
+		return &authv1.ListSigningKeysResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	infos := make([]*authv1.SigningKeyInfo, 0, len(keys))
+	for i := range keys {
+		infos = append(infos, signingKeyInfo(&keys[i]))
+	}
+
+	return &authv1.ListSigningKeysResponse{
+		Success: true,
+		Message: "Signing keys retrieved successfully",
+		Keys:    infos,
+	}, nil
+}
+
+func signingKeyInfo(key *models.SigningKey) *authv1.SigningKeyInfo {
+	return &authv1.SigningKeyInfo{
+		KeyId:         key.KeyID,
+		Algorithm:     key.Algorithm,
+		Status:        key.Status,
+		ActivatedAt:   optionalTimestamp(key.ActivatedAt),
+		DeactivatedAt: optionalTimestamp(key.DeactivatedAt),
+		VerifyUntil:   optionalTimestamp(key.VerifyUntil),
+		CreatedAt:     timestamppb.New(key.CreatedAt),
+	}
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
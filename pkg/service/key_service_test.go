@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+)
+
+func newKeyRingServer(t *testing.T, db *gorm.DB) (*AuthServiceServerImpl, *KeyRing) {
+	t.Helper()
+	t.Setenv("JWT_SIGNING_ALG", utils.AlgorithmES256)
+	t.Setenv("JWT_PRIVATE_KEY", "")
+	t.Setenv("JWT_PRIVATE_KEY_FILE", "")
+
+	svc := NewAuthServiceServer(db)
+	keyRing := svc.KeyRing()
+	if err := keyRing.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load key ring: %v", err)
+	}
+	utils.SetKeyProvider(keyRing)
+	t.Cleanup(func() { utils.SetKeyProvider(nil) })
+	return svc, keyRing
+}
+
+func adminContext(t *testing.T) context.Context {
+	t.Helper()
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(adminKeyMetadata, "test-admin-key"))
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &utils.Claims{})
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestRotateSigningKey_OverlappingValidity(t *testing.T) {
+	db := newTestDB(t)
+	svc, keyRing := newKeyRingServer(t, db)
+	admin := NewAdminServiceServer(svc)
+	ctx := context.Background()
+
+	oldToken, _, err := utils.GenerateJWTToken("user-1", "alice", "client-1", "refresh")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	resp, err := admin.RotateSigningKey(ctx, &authv1.RotateSigningKeyRequest{})
+	if err != nil {
+		t.Fatalf("RotateSigningKey returned error: %v", err)
+	}
+	if !resp.Success || resp.KeyId == "" {
+		t.Fatalf("expected rotation to succeed, got msg=%s", resp.Message)
+	}
+
+	newToken, _, err := utils.GenerateJWTToken("user-1", "alice", "client-1", "refresh")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	if tokenKeyID(t, newToken) != resp.KeyId || tokenKeyID(t, oldToken) == resp.KeyId {
+		t.Fatalf("expected new tokens to be signed with the rotated key")
+	}
+
+	// Both keys verify during the grace period and both are published
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := utils.ValidateJWTToken(token); err != nil {
+			t.Fatalf("expected token to verify during grace period: %v", err)
+		}
+	}
+	jwks, err := svc.GetJWKS(context.Background(), &emptypb.Empty{})
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Fatalf("expected two published keys, got %v (err=%v)", jwks.GetKeys(), err)
+	}
+
+	// End the grace period and let the ring retire the old key
+	if err := db.Model(&models.SigningKey{}).
+		Where("status = ?", models.SigningKeyStatusVerifyOnly).
+		Update("verify_until", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("failed to expire grace period: %v", err)
+	}
+	keyRing.maintain(context.Background())
+
+	if _, err := utils.ValidateJWTToken(oldToken); err == nil {
+		t.Fatalf("expected token signed with retired key to be rejected")
+	}
+	if _, err := utils.ValidateJWTToken(newToken); err != nil {
+		t.Fatalf("expected token signed with active key to verify: %v", err)
+	}
+
+	listResp, err := admin.ListSigningKeys(ctx, &emptypb.Empty{})
+	if err != nil || !listResp.Success || len(listResp.Keys) != 2 {
+		t.Fatalf("expected both keys to be listed, got %v (err=%v)", listResp.GetKeys(), err)
+	}
+}
+
+func TestKeyRing_ConcurrentRotationBacksOff(t *testing.T) {
+	db := newTestDB(t)
+	_, keyRing := newKeyRingServer(t, db)
+	ctx := context.Background()
+
+	// Two admins read the same active key; the first rotation wins
+	observed, err := keyRing.activeKeyActivation(ctx)
+	if err != nil {
+		t.Fatalf("failed to read active key: %v", err)
+	}
+	first, err := keyRing.Rotate(ctx, "")
+	if err != nil || first == nil {
+		t.Fatalf("expected the first rotation to succeed, got %v (err=%v)", first, err)
+	}
+	second, err := keyRing.rotate(ctx, keyRing.algorithm, observed.Add(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("rotate returned error: %v", err)
+	}
+	if second != nil {
+		t.Fatalf("expected the rotation based on the replaced key to back off, got %s", second.KeyID)
+	}
+
+	var active []models.SigningKey
+	db.Where("status = ?", models.SigningKeyStatusActive).Find(&active)
+	if len(active) != 1 || active[0].KeyID != first.KeyID {
+		t.Fatalf("expected only the first rotation's key to be active, got %v", active)
+	}
+
+	// A fresh rotation observes the new key and goes through
+	if third, err := keyRing.Rotate(ctx, ""); err != nil || third == nil {
+		t.Fatalf("expected a later rotation to succeed, got %v (err=%v)", third, err)
+	}
+}
+
+func TestRotateSigningKey_RequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	admin := NewAdminServiceServer(NewAuthServiceServer(newTestDB(t)))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(adminKeyMetadata, "wrong"))
+	_, err := callAdmin(ctx, "RotateSigningKey", func(ctx context.Context) (*authv1.RotateSigningKeyResponse, error) {
+		return admin.RotateSigningKey(ctx, &authv1.RotateSigningKeyRequest{})
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected rotation without admin credentials to be rejected, got %v", err)
+	}
+}
+
+func TestKeyRing_EncryptsPrivateKeysAtRest(t *testing.T) {
+	db := newTestDB(t)
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", "")
+	_, keyRing := newKeyRingServer(t, db)
+	privateKeys := func() []string {
+		var keys []string
+		db.Model(&models.SigningKey{}).Order("created_at").Pluck("private_key", &keys)
+		return keys
+	}
+	if stored := privateKeys(); len(stored) != 1 || !strings.Contains(stored[0], "PRIVATE KEY") {
+		t.Fatalf("expected the key to be stored in plaintext without an encryption key, got %v", stored)
+	}
+	token, _, err := utils.GenerateJWTToken("user-1", "alice", "client-1", "refresh")
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+
+	// Configuring the encryption key encrypts existing keys on the next load
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err := keyRing.Load(context.Background()); err != nil {
+		t.Fatalf("failed to reload key ring: %v", err)
+	}
+	if _, err := keyRing.Rotate(context.Background(), ""); err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	stored := privateKeys()
+	for _, privateKey := range stored {
+		if !utils.IsSealedPrivateKey(privateKey) || strings.Contains(privateKey, "PRIVATE KEY") {
+			t.Fatalf("expected every private key to be encrypted, got %q", privateKey)
+		}
+	}
+
+	reloaded := NewKeyRing(db)
+	if err := reloaded.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load encrypted keys: %v", err)
+	}
+	utils.SetKeyProvider(reloaded)
+	if _, err := utils.ValidateJWTToken(token); err != nil {
+		t.Fatalf("expected the re-encrypted key to keep verifying: %v", err)
+	}
+
+	// The ciphertext is bound to its key ID
+	if _, err := signingKeyFromModel(&models.SigningKey{KeyID: "other", Algorithm: utils.AlgorithmES256, PrivateKey: stored[1]}); err == nil {
+		t.Fatalf("expected encrypted material to be rejected under another key ID")
+	}
+
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err := NewKeyRing(db).Load(context.Background()); err == nil {
+		t.Fatalf("expected loading with the wrong encryption key to fail")
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"authservice/pkg/mailer"
+	"authservice/pkg/models"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// invitationMessage builds the invitation email. With INVITATION_URL set it
+// links there with the token as the invitation_token query parameter.
+func invitationMessage(org *models.Organization, inviter *models.User, invitation *models.Invitation, token string) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s invited you to join %s as %s.\n\n", inviter.UserName, org.Name, invitation.Role)
+	if link := mailLink("INVITATION_URL", "invitation_token", token); link != "" {
+		fmt.Fprintf(&body, "Accept the invitation: %s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Register with this invitation token: %s\n\n", token)
+	}
+	fmt.Fprintf(&body, "The invitation expires on %s.\n", invitation.ExpiresAt.UTC().Format(time.RFC1123))
+
+	return mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You're invited to join %s", org.Name),
+		Body:    body.String(),
+	}
+}
+
+// verificationMessage builds the email verification email. With
+// EMAIL_VERIFICATION_URL set it links there with the token as the token
+// query parameter.
+func verificationMessage(user *models.User, token string, expiresAt time.Time) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nPlease confirm that this is your email address.\n\n", user.UserName)
+	if link := mailLink("EMAIL_VERIFICATION_URL", "token", token); link != "" {
+		fmt.Fprintf(&body, "Verify your address: %s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Enter this verification token: %s\n\n", token)
+	}
+	fmt.Fprintf(&body, "The link expires on %s. If you did not sign up, ignore this email.\n", expiresAt.UTC().Format(time.RFC1123))
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body.String(),
+	}
+}
+
+// passwordResetMessage builds the password reset email. With
+// PASSWORD_RESET_URL set it links there with the token as the token query
+// parameter.
+func passwordResetMessage(user *models.User, token string, expiresAt time.Time) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nSomeone asked to reset the password of your account.\n\n", user.UserName)
+	if link := mailLink("PASSWORD_RESET_URL", "token", token); link != "" {
+		fmt.Fprintf(&body, "Choose a new password: %s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Enter this password reset token: %s\n\n", token)
+	}
+	fmt.Fprintf(&body, "The link expires on %s and signs you out everywhere once used. If you did not ask for it, ignore this email; your password stays the same.\n",
+		expiresAt.UTC().Format(time.RFC1123))
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    body.String(),
+	}
+}
+
+// emailChangeConfirmationMessage builds the email sent to the new address of
+// an email change. With EMAIL_CHANGE_URL set it links there with the token as
+// the token query parameter.
+func emailChangeConfirmationMessage(user *models.User, newEmail, token string, expiresAt time.Time) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nPlease confirm that you want to use this address for your account instead of %s.\n\n", user.UserName, user.Email)
+	if link := mailLink("EMAIL_CHANGE_URL", "token", token); link != "" {
+		fmt.Fprintf(&body, "Confirm the change: %s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Enter this email change token: %s\n\n", token)
+	}
+	fmt.Fprintf(&body, "The link expires on %s. If you did not ask for it, ignore this email.\n", expiresAt.UTC().Format(time.RFC1123))
+
+	return mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body:    body.String(),
+	}
+}
+
+// emailChangeNoticeMessage warns the old address of an email change. With
+// EMAIL_CHANGE_UNDO_URL set it links there with the undo token as the token
+// query parameter.
+func emailChangeNoticeMessage(user *models.User, newEmail, undoToken string, expiresAt time.Time) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nSomeone asked to change the email address of your account to %s.\n\n", user.UserName, newEmail)
+	fmt.Fprintf(&body, "If this was not you, undo the change; it restores this address and signs out every device.\n")
+	if link := mailLink("EMAIL_CHANGE_UNDO_URL", "token", undoToken); link != "" {
+		fmt.Fprintf(&body, "Undo the change: %s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Enter this undo token: %s\n\n", undoToken)
+	}
+	fmt.Fprintf(&body, "You can undo the change until %s. Consider resetting your password as well.\n", expiresAt.UTC().Format(time.RFC1123))
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body:    body.String(),
+	}
+}
+
+// mailLink appends token as the param query parameter to the URL configured
+// in envVar; it returns "" when the variable is unset
+func mailLink(envVar, param, token string) string {
+	base := os.Getenv(envVar)
+	if base == "" {
+		return ""
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + param + "=" + url.QueryEscape(token)
+}
@@ -0,0 +1,475 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// csrfCookieName holds the double-submit CSRF token of the login form
+const csrfCookieName = "oauth_csrf"
+
+// maxFormBytes bounds the size of form posts to the OAuth endpoints
+const maxFormBytes = 64 << 10
+
+// authorizeRequest is the validated query of an authorization request
+// (RFC 6749 section 4.1.1 with the PKCE parameters of RFC 7636)
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	State               string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func newAuthorizeRequest(values url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		State:               values.Get("state"),
+		Scope:               values.Get("scope"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// params returns the request parameters carried through the login form
+func (a *authorizeRequest) params() map[string]string {
+	params := map[string]string{
+		"response_type":         a.ResponseType,
+		"client_id":             a.ClientID,
+		"redirect_uri":          a.RedirectURI,
+		"state":                 a.State,
+		"scope":                 a.Scope,
+		"nonce":                 a.Nonce,
+		"code_challenge":        a.CodeChallenge,
+		"code_challenge_method": a.CodeChallengeMethod,
+	}
+	for name, value := range params {
+		if value == "" {
+			delete(params, name)
+		}
+	}
+	return params
+}
+
+// validate checks the parameters whose errors are reported back to the
+// client through its redirect URI, and narrows the scope to what the client
+// may be granted
+func (a *authorizeRequest) validate(client *models.Client) *oauthError {
+	if a.ResponseType != "code" {
+		return newOAuthError("unsupported_response_type", "response_type must be code")
+	}
+	if a.CodeChallenge == "" {
+		return newOAuthError("invalid_request", "code_challenge is required")
+	}
+	if a.CodeChallengeMethod != codeChallengeMethodS256 {
+		return newOAuthError("invalid_request", "code_challenge_method must be S256")
+	}
+	// An S256 challenge is an unpadded base64url SHA-256 digest
+	if len(a.CodeChallenge) != 43 {
+		return newOAuthError("invalid_request", "invalid code_challenge")
+	}
+	scope, err := resolveUserScope(client, a.Scope)
+	var scopeErr *oauthError
+	if errors.As(err, &scopeErr) {
+		return scopeErr
+	}
+	a.Scope = scope
+	return nil
+}
+
+type authorizePage struct {
+	ClientName string
+	Scope      string
+	Email      string
+	Error      string
+	CSRFToken  string
+	Params     map[string]string
+}
+
+// loadAuthorizeClient resolves the client and checks the redirect URI. These
+// errors must not be redirected, since the redirect URI itself is untrusted.
+func (s *AuthServiceServerImpl) loadAuthorizeClient(ctx context.Context, req *authorizeRequest) (*models.Client, error) {
+	if req.ClientID == "" {
+		return nil, errors.New("missing client_id")
+	}
+	client, err := s.repo.GetClientByID(ctx, req.ClientID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error getting client by ID: %v", err)
+		}
+		return nil, errors.New("unknown client")
+	}
+	if req.RedirectURI == "" || !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, errors.New("redirect_uri is not registered for this client")
+	}
+	return client, nil
+}
+
+func (s *AuthServiceServerImpl) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Authorize request received for client: %s", r.URL.Query().Get("client_id"))
+	setAuthorizePageHeaders(w)
+
+	req := newAuthorizeRequest(r.URL.Query())
+	client, err := s.loadAuthorizeClient(r.Context(), req)
+	if err != nil {
+		renderAuthorizeError(w, err.Error())
+		return
+	}
+	if oauthErr := req.validate(client); oauthErr != nil {
+		redirectWithError(w, r, req, oauthErr)
+		return
+	}
+
+	csrfToken, err := setCSRFCookie(w, r, "/oauth/authorize")
+	if err != nil {
+		log.Printf("Error generating CSRF token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	renderAuthorizePage(w, http.StatusOK, &authorizePage{
+		ClientName: client.ClientName,
+		Scope:      req.Scope,
+		CSRFToken:  csrfToken,
+		Params:     req.params(),
+	})
+}
+
+func (s *AuthServiceServerImpl) handleAuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	setAuthorizePageHeaders(w)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		renderAuthorizeError(w, "malformed request")
+		return
+	}
+
+	csrfToken, ok := verifyCSRF(r)
+	if !ok {
+		renderAuthorizeError(w, "your sign-in form expired, please start again")
+		return
+	}
+
+	req := newAuthorizeRequest(r.PostForm)
+	client, err := s.loadAuthorizeClient(r.Context(), req)
+	if err != nil {
+		renderAuthorizeError(w, err.Error())
+		return
+	}
+	if oauthErr := req.validate(client); oauthErr != nil {
+		redirectWithError(w, r, req, oauthErr)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		redirectWithError(w, r, req, newOAuthError("access_denied", "The user denied the request"))
+		return
+	}
+
+	ctx := withHTTPClientIP(r.Context(), r)
+	email := r.PostForm.Get("email")
+	user, err := s.authenticateUser(ctx, client, email, r.PostForm.Get("password"))
+	if err != nil {
+		renderAuthorizePage(w, http.StatusUnauthorized, &authorizePage{
+			ClientName: client.ClientName,
+			Scope:      req.Scope,
+			Email:      email,
+			Error:      loginErrorText(err),
+			CSRFToken:  csrfToken,
+			Params:     req.params(),
+		})
+		return
+	}
+
+	code, err := s.issueAuthorizationCode(ctx, user, req)
+	if err != nil {
+		log.Printf("Error issuing authorization code: %v", err)
+		redirectWithError(w, r, req, newOAuthError("server_error", "Internal server error"))
+		return
+	}
+
+	log.Printf("Authorization code issued for user: %s", user.UserID)
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// tokenEndpointResponse is a successful token response (RFC 6749 section 5.1)
+type tokenEndpointResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	// IssuedTokenType is set for token exchange (RFC 8693 section 2.2.1)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+func newTokenEndpointResponse(accessToken string, expiresAt *timestamppb.Timestamp, refreshToken, scope string) *tokenEndpointResponse {
+	return &tokenEndpointResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expiresAt.AsTime()).Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+}
+
+// handleToken is the OAuth 2.0 token endpoint (RFC 6749 section 3.2)
+func (s *AuthServiceServerImpl) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError("invalid_request", "malformed request body"))
+		return
+	}
+
+	ctx := withHTTPClientIP(r.Context(), r)
+	client, oauthErr := s.authenticateTokenClient(ctx, r)
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr)
+		return
+	}
+
+	var resp *tokenEndpointResponse
+	var err error
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "authorization_code":
+		resp, err = s.tokenFromAuthorizationCode(ctx, r, client)
+	case "client_credentials":
+		resp, err = s.tokenFromClientCredentials(r, client)
+	case deviceCodeGrantType:
+		resp, err = s.tokenFromDeviceCode(ctx, r, client)
+	case tokenExchangeGrantType:
+		resp, err = s.tokenFromTokenExchange(ctx, r, client)
+	case "":
+		err = newOAuthError("invalid_request", "grant_type is required")
+	default:
+		err = newOAuthError("unsupported_grant_type", "unsupported grant_type: "+grantType)
+	}
+	if err != nil {
+		var tokenErr *oauthError
+		if !errors.As(err, &tokenErr) {
+			log.Printf("Error handling token request: %v", err)
+			tokenErr = newOAuthError("server_error", "Internal server error")
+		}
+		writeOAuthError(w, tokenErr)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *AuthServiceServerImpl) tokenFromAuthorizationCode(ctx context.Context, r *http.Request, client *tokenClient) (*tokenEndpointResponse, error) {
+	resp, err := s.exchangeAuthorizationCode(ctx, &authv1.ExchangeAuthorizationCodeRequest{
+		ClientId:     client.ClientID,
+		Code:         r.PostForm.Get("code"),
+		RedirectUri:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		UserAgent:    r.UserAgent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokenResp := newTokenEndpointResponse(resp.AccessToken, resp.ExpiresAt, resp.RefreshToken, resp.Scope)
+	tokenResp.IDToken = resp.IdToken
+	return tokenResp, nil
+}
+
+func (s *AuthServiceServerImpl) tokenFromClientCredentials(r *http.Request, client *tokenClient) (*tokenEndpointResponse, error) {
+	// Only confidential clients can act on their own behalf
+	if !client.Authenticated {
+		return nil, newOAuthError("invalid_client", "client_credentials requires client authentication")
+	}
+	resp, err := s.issueClientToken(client.Client, r.PostForm.Get("scope"), r.PostForm["audience"])
+	if err != nil {
+		return nil, err
+	}
+	return newTokenEndpointResponse(resp.AccessToken, resp.ExpiresAt, "", resp.Scope), nil
+}
+
+// tokenClient is the caller of the token endpoint. Authenticated is set only
+// when the client proved its secret; public clients are identified by
+// ClientID alone.
+type tokenClient struct {
+	ClientID      string
+	Client        *models.Client
+	Authenticated bool
+}
+
+// authenticateTokenClient identifies the client of a token request, which
+// authenticates with HTTP Basic or client_secret in the form
+func (s *AuthServiceServerImpl) authenticateTokenClient(ctx context.Context, r *http.Request) (*tokenClient, *oauthError) {
+	clientID, clientSecret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		// Basic credentials are form-urlencoded (RFC 6749 section 2.3.1)
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, newOAuthError("invalid_client", "malformed client credentials")
+		}
+		if clientSecret, err = url.QueryUnescape(clientSecret); err != nil {
+			return nil, newOAuthError("invalid_client", "malformed client credentials")
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	return s.authenticateClient(ctx, clientID, clientSecret)
+}
+
+// authenticateClient checks the credentials of a client redeeming a grant.
+// Every client must present its secret except one registered as public, which
+// has none: it sends only its client_id and relies on PKCE.
+func (s *AuthServiceServerImpl) authenticateClient(ctx context.Context, clientID, clientSecret string) (*tokenClient, *oauthError) {
+	if clientID == "" {
+		return nil, newOAuthError("invalid_client", "client authentication is required")
+	}
+	if clientSecret == "" {
+		client, err := s.repo.GetClientByID(ctx, clientID)
+		if err != nil {
+			return nil, newOAuthError("invalid_client", "Invalid client credentials")
+		}
+		if !client.Public {
+			return nil, newOAuthError("invalid_client", "client authentication is required")
+		}
+		return &tokenClient{ClientID: clientID, Client: client}, nil
+	}
+	client, err := s.repo.ValidateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "Invalid client credentials")
+	}
+	return &tokenClient{ClientID: clientID, Client: client, Authenticated: true}, nil
+}
+
+func writeOAuthError(w http.ResponseWriter, err *oauthError) {
+	statusCode := http.StatusBadRequest
+	switch err.Code {
+	case "invalid_client":
+		statusCode = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+	case "server_error":
+		statusCode = http.StatusInternalServerError
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, statusCode, map[string]string{
+		"error":             err.Code,
+		"error_description": err.Description,
+	})
+}
+
+// redirectToClient sends the user agent back to the validated redirect URI,
+// keeping any query the client registered and echoing state
+func redirectToClient(w http.ResponseWriter, r *http.Request, req *authorizeRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderAuthorizeError(w, "invalid redirect_uri")
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req *authorizeRequest, err *oauthError) {
+	redirectToClient(w, r, req, url.Values{
+		"error":             {err.Code},
+		"error_description": {err.Description},
+	})
+}
+
+// setAuthorizePageHeaders keeps the login page out of frames and caches
+func setAuthorizePageHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+func renderAuthorizePage(w http.ResponseWriter, statusCode int, page *authorizePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := templates.ExecuteTemplate(w, "authorize.html", page); err != nil {
+		log.Printf("Error rendering authorize page: %v", err)
+	}
+}
+
+func renderAuthorizeError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := templates.ExecuteTemplate(w, "error.html", message); err != nil {
+		log.Printf("Error rendering error page: %v", err)
+	}
+}
+
+// setCSRFCookie issues the double-submit CSRF token for a form served under path
+func setCSRFCookie(w http.ResponseWriter, r *http.Request, path string) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	csrfToken := hex.EncodeToString(bytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken,
+		Path:     path,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+// verifyCSRF checks that the posted form token matches the CSRF cookie and
+// returns it for re-rendering the form
+func verifyCSRF(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(csrfCookieName)
+	csrfToken := r.PostForm.Get("csrf_token")
+	if err != nil || csrfToken == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(csrfToken)) != 1 {
+		return "", false
+	}
+	return csrfToken, true
+}
+
+// isHTTPS reports whether the browser reached us over TLS, directly or via a
+// trusted proxy, so cookies can be marked Secure
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return os.Getenv("TRUST_PROXY_HEADERS") == "true" && r.Header.Get("X-Forwarded-Proto") == "https"
+}
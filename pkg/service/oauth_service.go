@@ -0,0 +1,208 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// authorizationCodeTTL bounds how long an issued code can be exchanged
+// (RFC 6749 section 4.1.2 recommends at most 10 minutes)
+const authorizationCodeTTL = 60 * time.Second
+
+// codeChallengeMethodS256 is the only PKCE method we accept; "plain" offers
+// no protection against an intercepted authorization request
+const codeChallengeMethodS256 = "S256"
+
+// pkceVerifierPattern is the code_verifier syntax from RFC 7636 section 4.1
+var pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// oauthError is an OAuth 2.0 error response (RFC 6749 section 5.2). The HTTP
+// endpoints return Code on the wire; gRPC callers get Description as Message.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Description
+}
+
+func newOAuthError(code, description string) *oauthError {
+	return &oauthError{Code: code, Description: description}
+}
+
+func (s *AuthServiceServerImpl) ExchangeAuthorizationCode(ctx context.Context, req *authv1.ExchangeAuthorizationCodeRequest) (*authv1.GetTokenResponse, error) {
+	log.Printf("ExchangeAuthorizationCode request received for client: %s", req.ClientId)
+
+	if _, oauthErr := s.authenticateClient(ctx, req.ClientId, req.ClientSecret); oauthErr != nil {
+		return &authv1.GetTokenResponse{Success: false, Message: oauthErr.Description}, nil
+	}
+	resp, err := s.exchangeAuthorizationCode(ctx, req)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			return &authv1.GetTokenResponse{
+				Success: false,
+				Message: oauthErr.Description,
+			}, nil
+		}
+		log.Printf("Error exchanging authorization code: %v", err)
+		return &authv1.GetTokenResponse{
+			Success: false,
+			Message: "Internal server error",
+		}, nil
+	}
+
+	return resp, nil
+}
+
+// exchangeAuthorizationCode redeems a code for the same token pair GetToken
+// issues. Codes are single-use: presenting one again revokes the session it
+// was exchanged for (RFC 6749 section 4.1.2).
+func (s *AuthServiceServerImpl) exchangeAuthorizationCode(ctx context.Context, req *authv1.ExchangeAuthorizationCodeRequest) (*authv1.GetTokenResponse, error) {
+	if req.ClientId == "" || req.Code == "" || req.RedirectUri == "" || req.CodeVerifier == "" {
+		return nil, newOAuthError("invalid_request", "client_id, code, redirect_uri and code_verifier are required")
+	}
+
+	authCode, err := s.repo.ConsumeAuthorizationCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, repository.ErrAuthorizationCodeUsed) {
+			s.revokeReplayedAuthorizationCode(ctx, authCode)
+			return nil, newOAuthError("invalid_grant", "Authorization code has already been used")
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newOAuthError("invalid_grant", "Invalid or expired authorization code")
+		}
+		return nil, err
+	}
+
+	// The code is bound to the client, redirect URI and PKCE challenge of the
+	// authorization request that produced it
+	if authCode.ClientID != req.ClientId || authCode.RedirectURI != req.RedirectUri {
+		return nil, newOAuthError("invalid_grant", "Authorization code was not issued to this client or redirect URI")
+	}
+	if !verifyCodeChallenge(req.CodeVerifier, authCode.CodeChallenge) {
+		return nil, newOAuthError("invalid_grant", "Invalid code verifier")
+	}
+
+	client, err := s.repo.GetClientByID(ctx, authCode.ClientID)
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "Invalid client ID")
+	}
+	user, err := s.repo.GetUserByID(ctx, authCode.UserID)
+	if err != nil {
+		return nil, newOAuthError("invalid_grant", "User not found")
+	}
+
+	// The scope was resolved against the client when the code was issued
+	resp, err := s.issueSessionTokens(ctx, user, client, authCode.Scope, client.AllowedAudienceList(), req.UserAgent, req.DeviceLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	// An OpenID Connect request also gets an ID token for the original sign-in
+	if hasScope(authCode.Scope, scopeOpenID) {
+		if err := s.attachIDToken(resp, user, client.ClientID, authCode.Nonce, authCode.AuthTime); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.SetAuthorizationCodeSession(ctx, authCode.CodeHash, resp.SessionId); err != nil {
+		log.Printf("Error linking authorization code to session: %v", err)
+	}
+
+	log.Printf("Authorization code exchanged for user: %s", user.UserID)
+	return resp, nil
+}
+
+func (s *AuthServiceServerImpl) revokeReplayedAuthorizationCode(ctx context.Context, authCode *models.AuthorizationCode) {
+	log.Printf("Authorization code replay detected for user %s", authCode.UserID)
+	if authCode.SessionID != "" {
+		if _, err := s.repo.DeleteUserSession(ctx, authCode.UserID, authCode.SessionID); err != nil {
+			log.Printf("Error revoking session issued from replayed code: %v", err)
+		}
+	}
+	s.recordSecurityEvent(ctx, models.SecurityEventAuthorizationCodeReuse, authCode.UserID, authCode.ClientID,
+		fmt.Sprintf("authorization code replayed; session %s revoked", authCode.SessionID))
+}
+
+// issueAuthorizationCode stores a new code for the authenticated user and
+// returns the raw value; only its hash is persisted
+func (s *AuthServiceServerImpl) issueAuthorizationCode(ctx context.Context, user *models.User, req *authorizeRequest) (string, error) {
+	code, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	authCode := &models.AuthorizationCode{
+		CodeHash:            utils.HashToken(code),
+		ClientID:            req.ClientID,
+		UserID:              user.UserID,
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authorizationCodeTTL),
+	}
+	if err := s.repo.CreateAuthorizationCode(ctx, authCode); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// verifyCodeChallenge checks BASE64URL(SHA256(verifier)) == challenge
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// validateRedirectURI enforces the registration rules of RFC 6749 section
+// 3.1.2: an absolute URI without a fragment. Plain http is only allowed for
+// loopback redirects used by native apps (RFC 8252 section 7.3).
+func validateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() {
+		return fmt.Errorf("redirect URI must be an absolute URI: %s", uri)
+	}
+	if parsed.Fragment != "" || parsed.RawFragment != "" {
+		return fmt.Errorf("redirect URI must not contain a fragment: %s", uri)
+	}
+	if parsed.Scheme == "http" {
+		switch parsed.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return fmt.Errorf("redirect URI must use https: %s", uri)
+		}
+	}
+	return nil
+}
+
+func validateRedirectURIs(uris []string) error {
+	for _, uri := range uris {
+		if err := validateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
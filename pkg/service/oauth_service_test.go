@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	authv1 "authservice/proto/auth/v1"
+
+	"gorm.io/gorm"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mJ92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+func testCodeChallenge() string {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func seedRedirectURI(t *testing.T, db *gorm.DB, clientID string) {
+	t.Helper()
+	repo := repository.NewAuthRepository(db)
+	if err := repo.UpdateClientRedirectURIs(context.Background(), clientID, []string{testRedirectURI}); err != nil {
+		t.Fatalf("failed to seed redirect URI: %v", err)
+	}
+}
+
+func authorizeQuery() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client-1"},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {testCodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// authorizeAs drives the login form and returns the redirect it produces
+func authorizeAs(t *testing.T, handler http.Handler, email, password string) *url.URL {
+	t.Helper()
+	query := authorizeQuery()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected login page, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatalf("expected login page to forbid framing")
+	}
+	match := csrfFieldPattern.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("expected CSRF token in login page")
+	}
+
+	form := authorizeQuery()
+	form.Set("csrf_token", match[1])
+	form.Set("email", email)
+	form.Set("password", password)
+	form.Set("decision", "allow")
+	post := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range rec.Result().Cookies() {
+		post.AddCookie(cookie)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, post)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect location: %v", err)
+	}
+	return location
+}
+
+func postToken(handler http.Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthorizationCodeFlow_Success(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedRedirectURI(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	handler := NewHTTPHandler(svc)
+
+	location := authorizeAs(t, handler, "alice@example.com", "password123")
+	if location.Query().Get("state") != "xyz" {
+		t.Fatalf("expected state to be echoed, got %q", location.Query().Get("state"))
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("expected authorization code in redirect, got %s", location)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client-1"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}
+	// A client holding a secret cannot pass as public by leaving it out
+	rec := postToken(handler, form)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "invalid_client") {
+		t.Fatalf("expected invalid_client without the client secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+	form.Set("client_secret", "secret")
+	rec = postToken(handler, form)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected token response, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("invalid token response: %v", err)
+	}
+	if tokens["token_type"] != "Bearer" || tokens["refresh_token"] == "" {
+		t.Fatalf("unexpected token response: %v", tokens)
+	}
+
+	validate, err := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: tokens["access_token"].(string)})
+	if err != nil || !validate.Valid || validate.UserId != "user-1" {
+		t.Fatalf("expected issued access token to validate, got err=%v resp=%v", err, validate)
+	}
+
+	// Replaying the code fails and revokes the session it produced
+	rec = postToken(handler, form)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_grant") {
+		t.Fatalf("expected invalid_grant on replay, got %d: %s", rec.Code, rec.Body.String())
+	}
+	validate, _ = svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: tokens["access_token"].(string)})
+	if validate.Valid {
+		t.Fatalf("expected session to be revoked after code replay")
+	}
+
+	var events []models.SecurityEvent
+	db.Where("event_type = ?", models.SecurityEventAuthorizationCodeReuse).Find(&events)
+	if len(events) != 1 {
+		t.Fatalf("expected one code reuse event, got %d", len(events))
+	}
+}
+
+func TestAuthorizationCodeFlow_WrongVerifier(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedRedirectURI(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	location := authorizeAs(t, NewHTTPHandler(svc), "alice@example.com", "password123")
+
+	resp, err := svc.ExchangeAuthorizationCode(context.Background(), &authv1.ExchangeAuthorizationCodeRequest{
+		ClientId:     "client-1",
+		Code:         location.Query().Get("code"),
+		RedirectUri:  testRedirectURI,
+		CodeVerifier: strings.Repeat("a", 43),
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode returned error: %v", err)
+	}
+	if resp.Success {
+		t.Fatalf("expected exchange with wrong verifier to fail")
+	}
+}
+
+func TestAuthorizationCodeFlow_PublicClient(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Updates(map[string]interface{}{"public": true, "client_secret": ""})
+	seedRedirectURI(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	handler := NewHTTPHandler(svc)
+
+	exchange := func(code, secret string) (*authv1.GetTokenResponse, error) {
+		return svc.ExchangeAuthorizationCode(context.Background(), &authv1.ExchangeAuthorizationCodeRequest{
+			ClientId:     "client-1",
+			ClientSecret: secret,
+			Code:         code,
+			RedirectUri:  testRedirectURI,
+			CodeVerifier: testCodeVerifier,
+		})
+	}
+
+	// Public clients have no secret, so presenting one fails
+	code := authorizeAs(t, handler, "alice@example.com", "password123").Query().Get("code")
+	if resp, err := exchange(code, "secret"); err != nil || resp.Success {
+		t.Fatalf("expected a public client presenting a secret to be rejected, got err=%v resp=%v", err, resp)
+	}
+	if resp, err := exchange(code, ""); err != nil || !resp.Success {
+		t.Fatalf("expected a public client to exchange its code with PKCE alone, got err=%v resp=%v", err, resp)
+	}
+
+	// Nor can they use grants that need client authentication
+	rec := postToken(handler, url.Values{"grant_type": {"client_credentials"}, "client_id": {"client-1"}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected client_credentials to require a confidential client, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAuthorize_RejectsUnregisteredRedirectURI(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedRedirectURI(t, db, "client-1")
+
+	query := authorizeQuery()
+	query.Set("redirect_uri", "https://evil.example.com/callback")
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected error page, got %d", rec.Code)
+	}
+	if rec.Header().Get("Location") != "" {
+		t.Fatalf("must not redirect to an unregistered URI")
+	}
+}
+
+func TestAuthorize_RequiresCSRFToken(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedRedirectURI(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	form := authorizeQuery()
+	form.Set("email", "alice@example.com")
+	form.Set("password", "password123")
+	form.Set("decision", "allow")
+	post := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(svc).ServeHTTP(rec, post)
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Location") != "" {
+		t.Fatalf("expected form post without CSRF token to be rejected, got %d", rec.Code)
+	}
+}
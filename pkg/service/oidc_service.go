@@ -0,0 +1,198 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// idTokenTTL is the lifetime of ID tokens; relying parties only use them at
+// sign-in, so they are much shorter lived than access tokens
+const idTokenTTL = time.Hour
+
+// scopeOpenID marks an authorization request as an OpenID Connect request
+const scopeOpenID = "openid"
+
+func (s *AuthServiceServerImpl) GetUserInfo(ctx context.Context, req *authv1.GetUserInfoRequest) (*authv1.GetUserInfoResponse, error) {
+	log.Printf("GetUserInfo request received")
+
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.GetUserInfoResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	return &authv1.GetUserInfoResponse{
+		Success:           true,
+		Message:           "User info retrieved successfully",
+		Sub:               user.UserID,
+		PreferredUsername: user.UserName,
+		Name:              displayName(user),
+		Email:             user.Email,
+		EmailVerified:     user.EmailVerified,
+		ClientId:          user.ClientID,
+		CreatedAt:         timestamppb.New(user.CreatedAt),
+		Locale:            user.Locale,
+		Picture:           user.AvatarURL,
+	}, nil
+}
+
+// attachIDToken adds an ID token for the user to a token response. Under an
+// HS256 key no ID token is issued, since relying parties could not verify it.
+func (s *AuthServiceServerImpl) attachIDToken(resp *authv1.GetTokenResponse, user *models.User, clientID, nonce string, authTime time.Time) error {
+	idToken, err := utils.GenerateIDToken(utils.IDTokenParams{
+		UserID:        user.UserID,
+		Username:      user.UserName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		ClientID:      clientID,
+		Nonce:         nonce,
+		AuthTime:      authTime,
+		AccessToken:   resp.AccessToken,
+		TTL:           idTokenTTL,
+	})
+	if errors.Is(err, utils.ErrSymmetricSigningKey) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.IdToken = idToken
+	return nil
+}
+
+// hasScope reports whether a space separated scope string contains scope
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// userInfoClaims maps a user profile to OIDC standard claims (OIDC Core
+// section 5.1); client_id and created_at are kept as private claims. Unset
+// optional claims are omitted, as the spec requires.
+func userInfoClaims(user *models.User) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":                user.UserID,
+		"preferred_username": user.UserName,
+		"name":               displayName(user),
+		"email":              user.Email,
+		"email_verified":     user.EmailVerified,
+		"client_id":          user.ClientID,
+		"created_at":         user.CreatedAt.Unix(),
+	}
+	if user.Locale != "" {
+		claims["locale"] = user.Locale
+	}
+	if user.AvatarURL != "" {
+		claims["picture"] = user.AvatarURL
+	}
+	return claims
+}
+
+// displayName is the user's display name, falling back to the username
+func displayName(user *models.User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.UserName
+}
+
+// handleUserInfo is the OIDC UserInfo endpoint; the access token is sent as a
+// Bearer token (RFC 6750 section 2.1)
+func (s *AuthServiceServerImpl) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || accessToken == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="auth-service"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	user, _, err := s.authenticateAccessToken(withHTTPClientIP(r.Context(), r), accessToken)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="auth-service", error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_token",
+			"error_description": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, userInfoClaims(user))
+}
+
+// handleOpenIDConfiguration serves the OIDC discovery document (OpenID
+// Connect Discovery 1.0 section 3). It is only served once PUBLIC_BASE_URL is
+// set: a document built from the Host header could be poisoned in shared
+// caches, and its issuer would not match the iss claim of our tokens. Nor is
+// it served under an HS256 key, with which no ID tokens are issued.
+func (s *AuthServiceServerImpl) handleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	baseURL := configuredBaseURL()
+	if baseURL == "" {
+		log.Printf("OpenID configuration requested but PUBLIC_BASE_URL is not set")
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found"})
+		return
+	}
+	algorithm, err := utils.SigningAlgorithm()
+	if err != nil {
+		log.Printf("Error loading signing key: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	if algorithm == utils.AlgorithmHS256 {
+		log.Printf("OpenID configuration requested but tokens are signed with HS256")
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                utils.Issuer(),
+		"authorization_endpoint":                baseURL + "/oauth/authorize",
+		"token_endpoint":                        baseURL + "/oauth/token",
+		"userinfo_endpoint":                     baseURL + "/oauth/userinfo",
+		"introspection_endpoint":                baseURL + "/oauth/introspect",
+		"revocation_endpoint":                   baseURL + "/oauth/revoke",
+		"device_authorization_endpoint":         baseURL + "/oauth/device_authorization",
+		"jwks_uri":                              baseURL + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials", deviceCodeGrantType, tokenExchangeGrantType},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{algorithm},
+		"scopes_supported":                      []string{scopeOpenID, "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{codeChallengeMethodS256},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "preferred_username", "name", "email", "email_verified", "locale", "picture"},
+	})
+}
+
+// errPublicBaseURLUnset is returned where a URL handed to users must be built
+// from PUBLIC_BASE_URL; the request's Host header is attacker controlled
+var errPublicBaseURLUnset = errors.New("PUBLIC_BASE_URL is not set")
+
+// requirePublicBaseURL is the externally visible URL of the HTTP endpoints,
+// or errPublicBaseURLUnset when PUBLIC_BASE_URL is not configured
+func requirePublicBaseURL() (string, error) {
+	baseURL := configuredBaseURL()
+	if baseURL == "" {
+		return "", errPublicBaseURLUnset
+	}
+	return baseURL, nil
+}
+
+// configuredBaseURL is PUBLIC_BASE_URL without a trailing slash
+func configuredBaseURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("PUBLIC_BASE_URL")), "/")
+}
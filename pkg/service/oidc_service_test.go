@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestGetToken_IssuesIDToken(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	withRSASigningKey(t)
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	resp, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: "client-1",
+		Nonce:    "n-0S6_WzA2Mj",
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, resp)
+	}
+
+	claims := &utils.IDTokenClaims{}
+	if err := utils.ParseSignedClaims(resp.IdToken, claims); err != nil {
+		t.Fatalf("expected a valid ID token: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Nonce != "n-0S6_WzA2Mj" || claims.Email != "alice@example.com" {
+		t.Fatalf("unexpected ID token claims: %+v", claims)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "client-1" {
+		t.Fatalf("expected audience client-1, got %v", claims.Audience)
+	}
+	if claims.AuthTime == nil {
+		t.Fatalf("expected auth_time in ID token")
+	}
+	if claims.AtHash != utils.AccessTokenHash(resp.AccessToken, utils.AlgorithmRS256) {
+		t.Fatalf("at_hash does not match the access token")
+	}
+}
+
+func TestGetToken_NoIDTokenUnderHS256(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	// Relying parties could only verify an HMAC-signed ID token with our secret
+	resp, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"})
+	if err != nil || !resp.Success || resp.AccessToken == "" {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, resp)
+	}
+	if resp.IdToken != "" {
+		t.Fatalf("expected no ID token under HS256")
+	}
+}
+
+func TestAuthorizationCodeFlow_OpenIDScopeReturnsIDToken(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	withRSASigningKey(t)
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedRedirectURI(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	handler := NewHTTPHandler(svc)
+
+	user, _ := svc.repo.GetUserByID(context.Background(), "user-1")
+	req := newAuthorizeRequest(authorizeQuery())
+	req.Scope = "openid email"
+	req.Nonce = "abc123"
+	code, err := svc.issueAuthorizationCode(context.Background(), user, req)
+	if err != nil {
+		t.Fatalf("failed to issue code: %v", err)
+	}
+
+	rec := postToken(handler, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client-1"},
+		"client_secret": {"secret"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected token response, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens map[string]string
+	_ = json.Unmarshal(rec.Body.Bytes(), &tokens)
+
+	claims := &utils.IDTokenClaims{}
+	if err := utils.ParseSignedClaims(tokens["id_token"], claims); err != nil {
+		t.Fatalf("expected a valid ID token: %v", err)
+	}
+	if claims.Nonce != "abc123" {
+		t.Fatalf("expected nonce from the authorization request, got %q", claims.Nonce)
+	}
+
+	// UserInfo returns the standard claims for the access token
+	userInfoReq := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+	userInfoReq.Header.Set("Authorization", "Bearer "+tokens["access_token"])
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, userInfoReq)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected userinfo, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var info map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &info)
+	if info["sub"] != "user-1" || info["preferred_username"] != "alice" || info["email"] != "alice@example.com" {
+		t.Fatalf("unexpected userinfo claims: %v", info)
+	}
+}
+
+func TestUserInfo_RequiresBearerToken(t *testing.T) {
+	db := newTestDB(t)
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(NewAuthServiceServer(db)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with a Bearer challenge, got %d", rec.Code)
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	handler := NewHTTPHandler(NewAuthServiceServer(newTestDB(t)))
+
+	// Without PUBLIC_BASE_URL the document would be built from the Host header
+	t.Setenv("PUBLIC_BASE_URL", "")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected discovery to require PUBLIC_BASE_URL, got %d", rec.Code)
+	}
+
+	// Nor under HS256, whose ID token signatures nobody else could verify
+	t.Setenv("PUBLIC_BASE_URL", "https://auth.example.com/")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected discovery to require an asymmetric key, got %d", rec.Code)
+	}
+
+	withRSASigningKey(t)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected discovery document, got %d", rec.Code)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid discovery document: %v", err)
+	}
+	if doc["issuer"] != "https://auth.example.com" {
+		t.Fatalf("expected issuer to default to PUBLIC_BASE_URL, got %v", doc["issuer"])
+	}
+	if doc["token_endpoint"] != "https://auth.example.com/oauth/token" || doc["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Fatalf("unexpected endpoints: %v", doc)
+	}
+	if algs, _ := doc["id_token_signing_alg_values_supported"].([]interface{}); len(algs) != 1 || algs[0] != utils.AlgorithmRS256 {
+		t.Fatalf("expected RS256 to be advertised, got %v", doc["id_token_signing_alg_values_supported"])
+	}
+}
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const maxOrganizationNameLength = 100
+
+func (a *AdminServiceServerImpl) CreateOrganization(ctx context.Context, req *authv1.CreateOrganizationRequest) (*authv1.OrganizationResponse, error) {
+	log.Printf("CreateOrganization request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.OrganizationResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxOrganizationNameLength {
+		return &authv1.OrganizationResponse{Success: false, Message: fmt.Sprintf("name is required and must be at most %d characters", maxOrganizationNameLength)}, nil
+	}
+	if _, err := a.auth.repo.GetClientByID(ctx, req.ClientId); err != nil {
+		return &authv1.OrganizationResponse{Success: false, Message: "Invalid client ID"}, nil
+	}
+
+	org := &models.Organization{
+		OrganizationID: utils.GenerateUUID(),
+		ClientID:       req.ClientId,
+		Name:           name,
+	}
+	var owner *models.OrganizationMember
+	if req.OwnerUserId != "" {
+		if err := a.auth.checkJoinable(ctx, req.OwnerUserId, org.ClientID); err != nil {
+			return &authv1.OrganizationResponse{Success: false, Message: err.Error()}, nil
+		}
+		owner = &models.OrganizationMember{UserID: req.OwnerUserId, ClientID: org.ClientID, OrganizationID: org.OrganizationID, Role: models.OrganizationRoleOwner}
+	}
+
+	if err := a.auth.repo.CreateOrganization(ctx, org, owner); err != nil {
+		log.Printf("Error creating organization: %v", err)
+		return &authv1.OrganizationResponse{Success: false, Message: "Failed to create organization"}, nil
+	}
+
+	log.Printf("Organization %s created for client %s", org.OrganizationID, org.ClientID)
+	return &authv1.OrganizationResponse{Success: true, Message: "Organization created successfully", Organization: organizationInfo(org)}, nil
+}
+
+func (a *AdminServiceServerImpl) ListOrganizations(ctx context.Context, req *authv1.ListOrganizationsRequest) (*authv1.ListOrganizationsResponse, error) {
+	log.Printf("ListOrganizations request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.ListOrganizationsResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+
+	orgs, err := a.auth.repo.ListOrganizationsByClient(ctx, req.ClientId)
+	if err != nil {
+		log.Printf("Error listing organizations: %v", err)
+		return &authv1.ListOrganizationsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	infos := make([]*authv1.Organization, 0, len(orgs))
+	for i := range orgs {
+		infos = append(infos, organizationInfo(&orgs[i]))
+	}
+	return &authv1.ListOrganizationsResponse{Success: true, Message: "Organizations retrieved successfully", Organizations: infos}, nil
+}
+
+func (a *AdminServiceServerImpl) AddOrganizationMember(ctx context.Context, req *authv1.AddOrganizationMemberRequest) (*authv1.OrganizationMemberResponse, error) {
+	log.Printf("AddOrganizationMember request received for organization: %s", req.OrganizationId)
+
+	if req.OrganizationId == "" || req.UserId == "" {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "Organization ID and user ID are required"}, nil
+	}
+	role, err := organizationRole(req.Role)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	org, err := a.auth.repo.GetOrganizationByID(ctx, req.OrganizationId)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "Organization not found"}, nil
+	}
+	if err := a.auth.checkJoinable(ctx, req.UserId, org.ClientID); err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	member := &models.OrganizationMember{UserID: req.UserId, ClientID: org.ClientID, OrganizationID: org.OrganizationID, Role: role}
+	if err := a.auth.repo.AddOrganizationMember(ctx, member); err != nil {
+		log.Printf("Error adding organization member: %v", err)
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "Failed to add member"}, nil
+	}
+
+	log.Printf("User %s added to organization %s as %s", member.UserID, org.OrganizationID, role)
+	return &authv1.OrganizationMemberResponse{Success: true, Message: "Member added successfully"}, nil
+}
+
+func (s *AuthServiceServerImpl) InviteOrganizationMember(ctx context.Context, req *authv1.InviteOrganizationMemberRequest) (*authv1.InviteOrganizationMemberResponse, error) {
+	log.Printf("InviteOrganizationMember request received for email: %s", req.Email)
+
+	user, caller, err := s.authenticateOrganizationMember(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	role, err := organizationRole(req.Role)
+	if err != nil {
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	if !canManageMember(caller.Role, role) {
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "You are not allowed to invite members with this role"}, nil
+	}
+	if !s.isValidEmail(req.Email) {
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Invalid email format"}, nil
+	}
+
+	org, err := s.repo.GetOrganizationByID(ctx, caller.OrganizationID)
+	if err != nil {
+		log.Printf("Error getting organization: %v", err)
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	client, err := s.repo.GetClientByID(ctx, org.ClientID)
+	if err != nil {
+		log.Printf("Error getting organization client: %v", err)
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	// Invitations are redeemed at registration, so existing accounts cannot accept one
+	emailExists, err := s.repo.IsEmailExists(ctx, client.IdentityPool(), req.Email)
+	if err != nil {
+		log.Printf("Error checking email existence: %v", err)
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if emailExists {
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Email already registered"}, nil
+	}
+
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	invitation := &models.Invitation{
+		InvitationID:   utils.GenerateUUID(),
+		OrganizationID: org.OrganizationID,
+		Email:          req.Email,
+		Role:           role,
+		TokenHash:      utils.HashToken(token),
+		InvitedBy:      user.UserID,
+		ExpiresAt:      time.Now().Add(time.Duration(envInt("INVITATION_TTL_HOURS", 72)) * time.Hour),
+	}
+	if err := s.repo.CreateInvitation(ctx, invitation); err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	if err := s.mailer.Send(ctx, invitationMessage(org, user, invitation, token)); err != nil {
+		log.Printf("Error sending invitation: %v", err)
+		// An invitation nobody received must not stay redeemable
+		if err := s.repo.DeleteInvitation(ctx, invitation.InvitationID); err != nil {
+			log.Printf("Error deleting unsent invitation: %v", err)
+		}
+		return &authv1.InviteOrganizationMemberResponse{Success: false, Message: "Failed to send invitation"}, nil
+	}
+
+	log.Printf("Invitation %s to organization %s sent by user %s", invitation.InvitationID, org.OrganizationID, user.UserID)
+	return &authv1.InviteOrganizationMemberResponse{
+		Success:      true,
+		Message:      "Invitation sent successfully",
+		InvitationId: invitation.InvitationID,
+		ExpiresAt:    timestamppb.New(invitation.ExpiresAt),
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) ListOrganizationMembers(ctx context.Context, req *authv1.ListOrganizationMembersRequest) (*authv1.ListOrganizationMembersResponse, error) {
+	log.Printf("ListOrganizationMembers request received")
+
+	_, caller, err := s.authenticateOrganizationMember(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.ListOrganizationMembersResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	org, err := s.repo.GetOrganizationByID(ctx, caller.OrganizationID)
+	if err != nil {
+		log.Printf("Error getting organization: %v", err)
+		return &authv1.ListOrganizationMembersResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	members, err := s.repo.ListOrganizationMembers(ctx, org.OrganizationID)
+	if err != nil {
+		log.Printf("Error listing organization members: %v", err)
+		return &authv1.ListOrganizationMembersResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	users, err := s.repo.ListUsersByIDs(ctx, userIDs)
+	if err != nil {
+		log.Printf("Error listing organization users: %v", err)
+		return &authv1.ListOrganizationMembersResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	usersByID := make(map[string]*models.User, len(users))
+	for i := range users {
+		usersByID[users[i].UserID] = &users[i]
+	}
+
+	infos := make([]*authv1.OrganizationMember, 0, len(members))
+	for _, member := range members {
+		user := usersByID[member.UserID]
+		if user == nil {
+			continue // deleted user
+		}
+		infos = append(infos, &authv1.OrganizationMember{
+			UserId:   user.UserID,
+			Username: user.UserName,
+			Email:    user.Email,
+			Role:     member.Role,
+			JoinedAt: timestamppb.New(member.CreatedAt),
+		})
+	}
+	return &authv1.ListOrganizationMembersResponse{
+		Success:      true,
+		Message:      "Members retrieved successfully",
+		Organization: organizationInfo(org),
+		Members:      infos,
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) UpdateOrganizationMemberRole(ctx context.Context, req *authv1.UpdateOrganizationMemberRoleRequest) (*authv1.OrganizationMemberResponse, error) {
+	log.Printf("UpdateOrganizationMemberRole request received for user: %s", req.UserId)
+
+	if req.UserId == "" || req.Role == "" {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "User ID and role are required"}, nil
+	}
+	role, err := organizationRole(req.Role)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	_, caller, err := s.authenticateOrganizationMember(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	target, err := s.organizationMember(ctx, caller, req.UserId)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	if !canManageMember(caller.Role, target.Role) || !canManageMember(caller.Role, role) {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "You are not allowed to change this member's role"}, nil
+	}
+
+	if _, err := s.repo.UpdateOrganizationMemberRole(ctx, caller.OrganizationID, target.UserID, role); err != nil {
+		if errors.Is(err, repository.ErrLastOwner) {
+			return &authv1.OrganizationMemberResponse{Success: false, Message: "The organization must keep at least one owner"}, nil
+		}
+		log.Printf("Error updating organization member role: %v", err)
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("User %s is now %s of organization %s", target.UserID, role, caller.OrganizationID)
+	return &authv1.OrganizationMemberResponse{Success: true, Message: "Member role updated successfully"}, nil
+}
+
+func (s *AuthServiceServerImpl) RemoveOrganizationMember(ctx context.Context, req *authv1.RemoveOrganizationMemberRequest) (*authv1.OrganizationMemberResponse, error) {
+	log.Printf("RemoveOrganizationMember request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "User ID is required"}, nil
+	}
+	user, caller, err := s.authenticateOrganizationMember(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	target, err := s.organizationMember(ctx, caller, req.UserId)
+	if err != nil {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: err.Error()}, nil
+	}
+	// Anyone may leave; removing others takes management rights over them
+	if target.UserID != user.UserID && !canManageMember(caller.Role, target.Role) {
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "You are not allowed to remove this member"}, nil
+	}
+
+	if _, err := s.repo.RemoveOrganizationMember(ctx, caller.OrganizationID, target.UserID); err != nil {
+		if errors.Is(err, repository.ErrLastOwner) {
+			return &authv1.OrganizationMemberResponse{Success: false, Message: "The organization must keep at least one owner"}, nil
+		}
+		log.Printf("Error removing organization member: %v", err)
+		return &authv1.OrganizationMemberResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("User %s removed from organization %s by user %s", target.UserID, caller.OrganizationID, user.UserID)
+	return &authv1.OrganizationMemberResponse{Success: true, Message: "Member removed successfully"}, nil
+}
+
+// authenticateOrganizationMember authenticates the access token and loads
+// the caller's membership among the organizations of the token's client
+func (s *AuthServiceServerImpl) authenticateOrganizationMember(ctx context.Context, accessToken string) (*models.User, *models.OrganizationMember, error) {
+	user, session, err := s.authenticateAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	member, err := s.repo.GetOrganizationMember(ctx, user.UserID, session.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("you do not belong to an organization")
+		}
+		log.Printf("Error getting organization membership: %v", err)
+		return nil, nil, errors.New("internal server error")
+	}
+	return user, member, nil
+}
+
+// organizationMember loads a member of the caller's organization; members of
+// other organizations are reported as not found
+func (s *AuthServiceServerImpl) organizationMember(ctx context.Context, caller *models.OrganizationMember, userID string) (*models.OrganizationMember, error) {
+	member, err := s.repo.GetOrganizationMember(ctx, userID, caller.ClientID)
+	if err != nil || member.OrganizationID != caller.OrganizationID {
+		return nil, errors.New("Member not found")
+	}
+	return member, nil
+}
+
+// checkJoinable checks the user can sign in to the client and belongs to no
+// organization of the client yet
+func (s *AuthServiceServerImpl) checkJoinable(ctx context.Context, userID, clientID string) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil || !s.userBelongsToClient(ctx, user, clientID) {
+		return errors.New("User not found")
+	}
+	_, err = s.repo.GetOrganizationMember(ctx, userID, clientID)
+	if err == nil {
+		return errors.New("User already belongs to an organization of this client")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error getting organization membership: %v", err)
+		return errors.New("Internal server error")
+	}
+	return nil
+}
+
+// userOrganizationID returns the ID of the user's organization among the
+// client's organizations, or "" when the user has none there
+func (s *AuthServiceServerImpl) userOrganizationID(ctx context.Context, userID, clientID string) (string, error) {
+	member, err := s.repo.GetOrganizationMember(ctx, userID, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return member.OrganizationID, nil
+}
+
+// pendingInvitation returns the invitation behind token if it may be
+// accepted by email when registering with clientID
+func (s *AuthServiceServerImpl) pendingInvitation(ctx context.Context, token, clientID, email string) (*models.Invitation, error) {
+	invitation, err := s.repo.GetPendingInvitation(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	org, err := s.repo.GetOrganizationByID(ctx, invitation.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	if org.ClientID != clientID || !strings.EqualFold(invitation.Email, email) {
+		return nil, errors.New("invitation does not match the registration")
+	}
+	return invitation, nil
+}
+
+// organizationRole validates a membership role; empty means member
+func organizationRole(role string) (string, error) {
+	switch role {
+	case "":
+		return models.OrganizationRoleMember, nil
+	case models.OrganizationRoleOwner, models.OrganizationRoleAdmin, models.OrganizationRoleMember:
+		return role, nil
+	default:
+		return "", errors.New("role must be owner, admin or member")
+	}
+}
+
+// canManageMember reports whether a member with role actor may invite,
+// change or remove members with role target. Owners manage everyone, admins
+// manage admins and members, members manage nobody.
+func canManageMember(actor, target string) bool {
+	switch actor {
+	case models.OrganizationRoleOwner:
+		return true
+	case models.OrganizationRoleAdmin:
+		return target != models.OrganizationRoleOwner
+	default:
+		return false
+	}
+}
+
+func organizationInfo(org *models.Organization) *authv1.Organization {
+	return &authv1.Organization{
+		OrganizationId: org.OrganizationID,
+		ClientId:       org.ClientID,
+		Name:           org.Name,
+		CreatedAt:      timestamppb.New(org.CreatedAt),
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"authservice/pkg/mailer"
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// captureMailer records sent messages, or fails every send when err is set
+type captureMailer struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var invitationTokenPattern = regexp.MustCompile(`invitation token: ([0-9a-f]+)`)
+
+func TestOrganizations_InvitationJoinsOrganization(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	created, _ := NewAdminServiceServer(svc).CreateOrganization(context.Background(), &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme", OwnerUserId: "user-1"})
+	if !created.Success {
+		t.Fatalf("expected organization to be created, got %v", created)
+	}
+	orgID := created.Organization.OrganizationId
+
+	owner := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	claims, _ := utils.ValidateJWTToken(owner.AccessToken)
+	if claims.OrganizationID != orgID {
+		t.Fatalf("expected org_id %s in the owner's token, got %q", orgID, claims.OrganizationID)
+	}
+
+	invite, _ := svc.InviteOrganizationMember(context.Background(), &authv1.InviteOrganizationMemberRequest{
+		AccessToken: owner.AccessToken, Email: "bob@example.com", Role: models.OrganizationRoleAdmin,
+	})
+	if !invite.Success || len(mail.sent) != 1 || mail.sent[0].To != "bob@example.com" {
+		t.Fatalf("expected an invitation email, got resp=%v mail=%v", invite, mail.sent)
+	}
+	match := invitationTokenPattern.FindStringSubmatch(mail.sent[0].Body)
+	if match == nil {
+		t.Fatalf("expected the invitation token in the email: %s", mail.sent[0].Body)
+	}
+	token := match[1]
+
+	register := func(email string) *authv1.RegisterUserResponse {
+		resp, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{
+			Username: "bob", Email: email, Password: "password123", ClientId: "client-1", InvitationToken: token,
+		})
+		return resp
+	}
+	if resp := register("mallory@example.com"); resp.Success {
+		t.Fatalf("expected an invitation for another email to be rejected")
+	}
+	resp := register("bob@example.com")
+	if !resp.Success || resp.OrganizationId != orgID {
+		t.Fatalf("expected registration to join the organization, got %v", resp)
+	}
+	var member models.OrganizationMember
+	if err := db.Where("user_id = ?", resp.UserId).First(&member).Error; err != nil || member.Role != models.OrganizationRoleAdmin {
+		t.Fatalf("expected bob to be an admin member, got %+v (%v)", member, err)
+	}
+	if again, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{
+		Username: "bob2", Email: "BOB@example.com", Password: "password123", ClientId: "client-1", InvitationToken: token,
+	}); again.Success {
+		t.Fatalf("expected the invitation to be single-use")
+	}
+
+	bob := loginAs(t, svc, "bob@example.com", "password123", "client-1", "phone")
+	validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: bob.AccessToken})
+	if !validate.Valid || validate.OrganizationId != orgID {
+		t.Fatalf("expected bob's token to carry the organization, got %v", validate)
+	}
+	list, _ := svc.ListOrganizationMembers(context.Background(), &authv1.ListOrganizationMembersRequest{AccessToken: bob.AccessToken})
+	if !list.Success || len(list.Members) != 2 || list.Organization.Name != "Acme" {
+		t.Fatalf("expected two members, got %v", list)
+	}
+}
+
+func TestOrganizations_MembershipManagement(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	seedUser(t, db, "user-2", "client-1", "bob@example.com", "bob", "password123")
+	seedUser(t, db, "user-3", "client-1", "carol@example.com", "carol", "password123")
+
+	admin := NewAdminServiceServer(svc)
+	ctx := context.Background()
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	_, err := callAdmin(ctx, "CreateOrganization", func(ctx context.Context) (*authv1.OrganizationResponse, error) {
+		return admin.CreateOrganization(ctx, &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme"})
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected CreateOrganization to require the admin key, got %v", err)
+	}
+
+	created, _ := admin.CreateOrganization(ctx, &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme", OwnerUserId: "user-1"})
+	orgID := created.Organization.OrganizationId
+	admin.AddOrganizationMember(ctx, &authv1.AddOrganizationMemberRequest{OrganizationId: orgID, UserId: "user-2", Role: models.OrganizationRoleAdmin})
+	admin.AddOrganizationMember(ctx, &authv1.AddOrganizationMemberRequest{OrganizationId: orgID, UserId: "user-3"})
+	if dup, _ := admin.CreateOrganization(ctx, &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Other", OwnerUserId: "user-3"}); dup.Success {
+		t.Fatalf("expected a user to belong to one organization only")
+	}
+
+	alice := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	bob := loginAs(t, svc, "bob@example.com", "password123", "client-1", "laptop")
+	carol := loginAs(t, svc, "carol@example.com", "password123", "client-1", "laptop")
+
+	// Admins cannot touch owners or make anyone an owner; members manage nobody
+	if resp, _ := svc.RemoveOrganizationMember(context.Background(), &authv1.RemoveOrganizationMemberRequest{AccessToken: bob.AccessToken, UserId: "user-1"}); resp.Success {
+		t.Fatalf("expected an admin to be unable to remove an owner")
+	}
+	if resp, _ := svc.UpdateOrganizationMemberRole(context.Background(), &authv1.UpdateOrganizationMemberRoleRequest{AccessToken: bob.AccessToken, UserId: "user-2", Role: models.OrganizationRoleOwner}); resp.Success {
+		t.Fatalf("expected an admin to be unable to grant ownership")
+	}
+	if resp, _ := svc.InviteOrganizationMember(context.Background(), &authv1.InviteOrganizationMemberRequest{AccessToken: carol.AccessToken, Email: "dave@example.com"}); resp.Success {
+		t.Fatalf("expected a member to be unable to invite")
+	}
+
+	// The last owner can neither leave nor step down
+	if resp, _ := svc.RemoveOrganizationMember(context.Background(), &authv1.RemoveOrganizationMemberRequest{AccessToken: alice.AccessToken, UserId: "user-1"}); resp.Success {
+		t.Fatalf("expected the last owner to be unable to leave")
+	}
+	if resp, _ := svc.UpdateOrganizationMemberRole(context.Background(), &authv1.UpdateOrganizationMemberRoleRequest{AccessToken: alice.AccessToken, UserId: "user-1", Role: models.OrganizationRoleMember}); resp.Success {
+		t.Fatalf("expected the last owner to be unable to step down")
+	}
+
+	// Removing a member ends their tokens' access to the organization
+	if resp, _ := svc.RemoveOrganizationMember(context.Background(), &authv1.RemoveOrganizationMemberRequest{AccessToken: bob.AccessToken, UserId: "user-3"}); !resp.Success {
+		t.Fatalf("expected an admin to remove a member, got %v", resp)
+	}
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: carol.AccessToken}); validate.Valid {
+		t.Fatalf("expected a removed member's token to be rejected")
+	}
+	refreshed, _ := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{RefreshToken: carol.RefreshToken, ClientId: "client-1", ClientSecret: "secret"})
+	if claims, err := utils.ValidateJWTToken(refreshed.AccessToken); err != nil || claims.OrganizationID != "" {
+		t.Fatalf("expected a refreshed token without org_id, got %+v (%v)", claims, err)
+	}
+}
+
+func TestOrganizations_MembershipPerClient(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	pooled, _ := svc.RegisterClient(adminContext(t), &authv1.RegisterClientRequest{ClientName: "mobile", IdentityPoolId: "client-1"})
+	if !pooled.Success {
+		t.Fatalf("expected a pooled client, got %v", pooled)
+	}
+
+	admin := NewAdminServiceServer(svc)
+	web, _ := admin.CreateOrganization(context.Background(), &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme", OwnerUserId: "user-1"})
+	if !web.Success {
+		t.Fatalf("expected organization to be created, got %v", web)
+	}
+	orgClaim := func(clientID string) string {
+		login := loginAs(t, svc, "alice@example.com", "password123", clientID, "laptop")
+		validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: login.AccessToken})
+		if !validate.Valid {
+			t.Fatalf("expected a valid token for %s, got %v", clientID, validate)
+		}
+		return validate.OrganizationId
+	}
+
+	// An organization only shows up in tokens for its own client
+	if orgID := orgClaim(pooled.ClientId); orgID != "" {
+		t.Fatalf("expected no org_id for another client of the pool, got %q", orgID)
+	}
+
+	// The same user can join one organization in each client of the pool
+	mobile, _ := admin.CreateOrganization(context.Background(), &authv1.CreateOrganizationRequest{ClientId: pooled.ClientId, Name: "Acme Mobile", OwnerUserId: "user-1"})
+	if !mobile.Success {
+		t.Fatalf("expected the user to join an organization of the pooled client, got %v", mobile)
+	}
+	if orgID := orgClaim("client-1"); orgID != web.Organization.OrganizationId {
+		t.Fatalf("expected org_id %s for client-1, got %q", web.Organization.OrganizationId, orgID)
+	}
+	if orgID := orgClaim(pooled.ClientId); orgID != mobile.Organization.OrganizationId {
+		t.Fatalf("expected org_id %s for the pooled client, got %q", mobile.Organization.OrganizationId, orgID)
+	}
+}
+
+func TestInviteOrganizationMember_MailFailureDiscardsInvitation(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	svc.SetMailer(&captureMailer{err: errors.New("smtp unavailable")})
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	NewAdminServiceServer(svc).CreateOrganization(context.Background(), &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme", OwnerUserId: "user-1"})
+	owner := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	resp, _ := svc.InviteOrganizationMember(context.Background(), &authv1.InviteOrganizationMemberRequest{AccessToken: owner.AccessToken, Email: "bob@example.com"})
+	if resp.Success {
+		t.Fatalf("expected the invitation to fail when mail cannot be sent")
+	}
+	var count int64
+	db.Model(&models.Invitation{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected the unsent invitation to be discarded, found %d", count)
+	}
+}
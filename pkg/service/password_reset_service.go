@@ -0,0 +1,81 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// passwordResetRequestedMessage is the same whether or not an email was sent,
+// so the response cannot be used to probe for accounts
+const passwordResetRequestedMessage = "If the address belongs to an account, a password reset email has been sent"
+
+func (s *AuthServiceServerImpl) RequestPasswordReset(ctx context.Context, req *authv1.RequestPasswordResetRequest) (*authv1.RequestPasswordResetResponse, error) {
+	log.Printf("RequestPasswordReset request received for email: %s", req.Email)
+
+	if req.Email == "" || req.ClientId == "" {
+		return &authv1.RequestPasswordResetResponse{Success: false, Message: "Email and client ID are required"}, nil
+	}
+	client, err := s.repo.GetClientByID(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.RequestPasswordResetResponse{Success: false, Message: "Invalid client ID"}, nil
+		}
+		log.Printf("Error checking client existence: %v", err)
+		return &authv1.RequestPasswordResetResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, client.IdentityPool(), req.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error getting user by email: %v", err)
+			return &authv1.RequestPasswordResetResponse{Success: false, Message: "Internal server error"}, nil
+		}
+		log.Printf("Password reset email not sent: no user %s in pool %s", req.Email, client.IdentityPool())
+		return &authv1.RequestPasswordResetResponse{Success: true, Message: passwordResetRequestedMessage}, nil
+	}
+
+	// A failed send is only logged; reporting it would confirm the account exists
+	ttl := time.Duration(envInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute
+	if err := s.mailUserToken(ctx, user, models.UserTokenPasswordReset, ttl, passwordResetMessage); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+	return &authv1.RequestPasswordResetResponse{Success: true, Message: passwordResetRequestedMessage}, nil
+}
+
+func (s *AuthServiceServerImpl) ResetPassword(ctx context.Context, req *authv1.ResetPasswordRequest) (*authv1.ResetPasswordResponse, error) {
+	log.Printf("ResetPassword request received")
+
+	if req.Token == "" {
+		return &authv1.ResetPasswordResponse{Success: false, Message: "Token is required"}, nil
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		return &authv1.ResetPasswordResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing new password: %v", err)
+		return &authv1.ResetPasswordResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	userID, err := s.repo.ResetUserPassword(ctx, req.Token, hashedPassword)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserTokenInvalid) {
+			return &authv1.ResetPasswordResponse{Success: false, Message: "Invalid or expired token"}, nil
+		}
+		log.Printf("Error resetting password: %v", err)
+		return &authv1.ResetPasswordResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	s.recordSecurityEvent(ctx, models.SecurityEventPasswordReset, userID, "", "password reset with an emailed token; all sessions revoked")
+	log.Printf("Password reset for user: %s", userID)
+	return &authv1.ResetPasswordResponse{Success: true, Message: "Password reset successfully. Please log in again."}, nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+)
+
+var passwordResetTokenPattern = regexp.MustCompile(`password reset token: ([0-9a-f]+)`)
+
+func TestPasswordReset_RevokesSessions(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	unknown, _ := svc.RequestPasswordReset(context.Background(), &authv1.RequestPasswordResetRequest{Email: "nobody@example.com", ClientId: "client-1"})
+	known, _ := svc.RequestPasswordReset(context.Background(), &authv1.RequestPasswordResetRequest{Email: "alice@example.com", ClientId: "client-1"})
+	if !unknown.Success || unknown.Message != known.Message || len(mail.sent) != 1 {
+		t.Fatalf("expected identical responses and one email, got %v, %v and %v", unknown, known, mail.sent)
+	}
+	token := mailedToken(t, mail, "alice@example.com", passwordResetTokenPattern)
+
+	if weak, _ := svc.ResetPassword(context.Background(), &authv1.ResetPasswordRequest{Token: token, NewPassword: "short"}); weak.Success {
+		t.Fatalf("expected a weak password to be rejected")
+	}
+	reset, _ := svc.ResetPassword(context.Background(), &authv1.ResetPasswordRequest{Token: token, NewPassword: "new-password456"})
+	if !reset.Success {
+		t.Fatalf("expected the reset to succeed, got %v", reset)
+	}
+	if again, _ := svc.ResetPassword(context.Background(), &authv1.ResetPasswordRequest{Token: token, NewPassword: "another-password789"}); again.Success {
+		t.Fatalf("expected the reset token to be single-use")
+	}
+
+	if validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: session.AccessToken}); validate.Valid {
+		t.Fatalf("expected sessions from before the reset to be revoked")
+	}
+	if old, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"}); old.Success {
+		t.Fatalf("expected the old password to stop working")
+	}
+	loginAs(t, svc, "alice@example.com", "new-password456", "client-1", "laptop")
+
+	var events int64
+	db.Model(&models.SecurityEvent{}).Where("user_id = ? AND event_type = ?", "user-1", models.SecurityEventPasswordReset).Count(&events)
+	if events != 1 {
+		t.Fatalf("expected a password reset security event, found %d", events)
+	}
+}
+
+func TestPasswordReset_TokenBoundToPurpose(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	// A verification token must not double as a reset token
+	svc.SendVerificationEmail(context.Background(), &authv1.SendVerificationEmailRequest{Email: "alice@example.com", ClientId: "client-1"})
+	verification := mailedToken(t, mail, "alice@example.com", verificationTokenPattern)
+	if resp, _ := svc.ResetPassword(context.Background(), &authv1.ResetPasswordRequest{Token: verification, NewPassword: "new-password456"}); resp.Success {
+		t.Fatalf("expected a verification token to be rejected by ResetPassword")
+	}
+	if resp, _ := svc.VerifyEmail(context.Background(), &authv1.VerifyEmailRequest{Token: verification}); !resp.Success {
+		t.Fatalf("expected the verification token to remain usable, got %v", resp)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"authservice/pkg/jsonschema"
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxUsernameLength         = 100
+	maxDisplayNameLength      = 100
+	maxAvatarURLLength        = 2000
+	maxCustomAttributesLength = 16 << 10
+)
+
+// localePattern accepts BCP 47 language tags such as "en", "pt-BR" or "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+func (s *AuthServiceServerImpl) GetUserProfile(ctx context.Context, req *authv1.GetUserProfileRequest) (*authv1.UserProfileResponse, error) {
+	log.Printf("GetUserProfile request received")
+
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.UserProfileResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	return &authv1.UserProfileResponse{
+		Success: true,
+		Message: "Profile retrieved successfully",
+		Profile: userProfile(user),
+	}, nil
+}
+
+// UpdateUserProfile changes the fields set in the request; unset fields keep
+// their value and an empty string clears an optional field
+func (s *AuthServiceServerImpl) UpdateUserProfile(ctx context.Context, req *authv1.UpdateUserProfileRequest) (*authv1.UserProfileResponse, error) {
+	log.Printf("UpdateUserProfile request received")
+
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.UserProfileResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" || utf8.RuneCountInString(username) > maxUsernameLength {
+			return &authv1.UserProfileResponse{Success: false, Message: fmt.Sprintf("username must be 1 to %d characters", maxUsernameLength)}, nil
+		}
+		user.UserName = username
+	}
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			return &authv1.UserProfileResponse{Success: false, Message: fmt.Sprintf("display_name must be at most %d characters", maxDisplayNameLength)}, nil
+		}
+		user.DisplayName = displayName
+	}
+	if req.Locale != nil {
+		if *req.Locale != "" && (len(*req.Locale) > 35 || !localePattern.MatchString(*req.Locale)) {
+			return &authv1.UserProfileResponse{Success: false, Message: "locale must be a BCP 47 language tag"}, nil
+		}
+		user.Locale = *req.Locale
+	}
+	if req.AvatarUrl != nil {
+		if err := validateAvatarURL(*req.AvatarUrl); err != nil {
+			return &authv1.UserProfileResponse{Success: false, Message: err.Error()}, nil
+		}
+		user.AvatarURL = *req.AvatarUrl
+	}
+	if req.CustomAttributes != nil {
+		attributes, err := s.customAttributes(ctx, user.ClientID, *req.CustomAttributes)
+		if err != nil {
+			return &authv1.UserProfileResponse{Success: false, Message: err.Error()}, nil
+		}
+		user.CustomAttributes = attributes
+	}
+
+	if err := s.repo.UpdateUserProfile(ctx, user); err != nil {
+		log.Printf("Error updating user profile: %v", err)
+		return &authv1.UserProfileResponse{Success: false, Message: "Failed to update profile"}, nil
+	}
+
+	log.Printf("Profile updated for user: %s", user.UserID)
+	return &authv1.UserProfileResponse{
+		Success: true,
+		Message: "Profile updated successfully",
+		Profile: userProfile(user),
+	}, nil
+}
+
+// UpdateClientCustomAttributesSchema replaces the JSON Schema the client's
+// users' custom attributes must satisfy. Attributes stored earlier are not
+// revalidated; they are checked against the new schema on their next update.
+func (s *AuthServiceServerImpl) UpdateClientCustomAttributesSchema(ctx context.Context, req *authv1.UpdateClientCustomAttributesSchemaRequest) (*authv1.UpdateClientCustomAttributesSchemaResponse, error) {
+	log.Printf("UpdateClientCustomAttributesSchema request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" {
+		return &authv1.UpdateClientCustomAttributesSchemaResponse{Success: false, Message: "client_id and client_secret are required"}, nil
+	}
+
+	if _, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret); err != nil {
+		return &authv1.UpdateClientCustomAttributesSchemaResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	if err := validateCustomAttributesSchema(req.Schema); err != nil {
+		return &authv1.UpdateClientCustomAttributesSchemaResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := s.repo.UpdateClientCustomAttributesSchema(ctx, req.ClientId, req.Schema); err != nil {
+		log.Printf("Error updating custom attributes schema: %v", err)
+		return &authv1.UpdateClientCustomAttributesSchemaResponse{Success: false, Message: "Failed to update custom attributes schema"}, nil
+	}
+
+	return &authv1.UpdateClientCustomAttributesSchemaResponse{Success: true, Message: "Custom attributes schema updated successfully"}, nil
+}
+
+// validateCustomAttributesSchema checks a client's custom attributes schema
+// compiles; an empty schema accepts any JSON object
+func validateCustomAttributesSchema(schema string) error {
+	if schema == "" {
+		return nil
+	}
+	if _, err := jsonschema.Compile([]byte(schema)); err != nil {
+		return fmt.Errorf("invalid custom_attributes_schema: %w", err)
+	}
+	return nil
+}
+
+// customAttributes validates raw custom attributes against the schema of the
+// user's client and returns them compacted; an empty string clears them
+func (s *AuthServiceServerImpl) customAttributes(ctx context.Context, clientID, raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	if len(raw) > maxCustomAttributesLength {
+		return "", fmt.Errorf("custom_attributes must be at most %d bytes", maxCustomAttributesLength)
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, []byte(raw)); err != nil || compacted.Bytes()[0] != '{' {
+		return "", errors.New("custom_attributes must be a JSON object")
+	}
+
+	client, err := s.repo.GetClientByID(ctx, clientID)
+	if err != nil {
+		log.Printf("Error loading client %s: %v", clientID, err)
+		return "", errors.New("Internal server error")
+	}
+	if client.CustomAttributesSchema != "" {
+		schema, err := jsonschema.Compile([]byte(client.CustomAttributesSchema))
+		if err != nil {
+			log.Printf("Error compiling custom attributes schema of client %s: %v", clientID, err)
+			return "", errors.New("Internal server error")
+		}
+		if err := schema.Validate(compacted.Bytes()); err != nil {
+			return "", fmt.Errorf("custom_attributes do not match the client schema: %w", err)
+		}
+	}
+	return compacted.String(), nil
+}
+
+// tokenCustomAttributes returns the user's custom attributes for access
+// tokens issued to the client, or nil when the client does not embed them
+func (s *AuthServiceServerImpl) tokenCustomAttributes(ctx context.Context, user *models.User, clientID string) (json.RawMessage, error) {
+	if user.CustomAttributes == "" {
+		return nil, nil
+	}
+	client, err := s.repo.GetClientByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.EmbedCustomAttributes {
+		return nil, nil
+	}
+	return json.RawMessage(user.CustomAttributes), nil
+}
+
+// validateAvatarURL accepts an empty string or an absolute https URL
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return fmt.Errorf("avatar_url must be at most %d characters", maxAvatarURLLength)
+	}
+	parsed, err := url.Parse(avatarURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("avatar_url must be an https URL")
+	}
+	return nil
+}
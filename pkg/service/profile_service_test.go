@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestUpdateUserProfile_PartialUpdate(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	str := func(s string) *string { return &s }
+	update := func(req *authv1.UpdateUserProfileRequest) *authv1.UserProfileResponse {
+		req.AccessToken = session.AccessToken
+		resp, _ := svc.UpdateUserProfile(context.Background(), req)
+		return resp
+	}
+	if resp := update(&authv1.UpdateUserProfileRequest{AvatarUrl: str("http://example.com/a.png")}); resp.Success {
+		t.Fatalf("expected a non-https avatar URL to be rejected")
+	}
+	if resp := update(&authv1.UpdateUserProfileRequest{Locale: str("not a locale")}); resp.Success {
+		t.Fatalf("expected an invalid locale to be rejected")
+	}
+	if resp := update(&authv1.UpdateUserProfileRequest{Username: str("  ")}); resp.Success {
+		t.Fatalf("expected an empty username to be rejected")
+	}
+
+	resp := update(&authv1.UpdateUserProfileRequest{
+		DisplayName: str("Alice Liddell"),
+		Locale:      str("en-GB"),
+		AvatarUrl:   str("https://cdn.example.com/alice.png"),
+	})
+	if !resp.Success || resp.Profile.DisplayName != "Alice Liddell" || resp.Profile.Username != "alice" {
+		t.Fatalf("expected the profile to be updated, got %v", resp)
+	}
+
+	// Unset fields are left alone
+	resp = update(&authv1.UpdateUserProfileRequest{Locale: str("fr")})
+	if !resp.Success || resp.Profile.Locale != "fr" || resp.Profile.DisplayName != "Alice Liddell" || resp.Profile.AvatarUrl != "https://cdn.example.com/alice.png" {
+		t.Fatalf("expected only the locale to change, got %v", resp)
+	}
+
+	info, _ := svc.GetUserInfo(context.Background(), &authv1.GetUserInfoRequest{AccessToken: session.AccessToken})
+	if info.Name != "Alice Liddell" || info.Locale != "fr" || info.Picture != "https://cdn.example.com/alice.png" {
+		t.Fatalf("expected userinfo to reflect the profile, got %v", info)
+	}
+	profile, _ := svc.GetUserProfile(context.Background(), &authv1.GetUserProfileRequest{AccessToken: session.AccessToken})
+	if !profile.Success || profile.Profile.Locale != "fr" {
+		t.Fatalf("expected the stored profile, got %v", profile)
+	}
+}
+
+func TestCustomAttributes_SchemaAndTokenClaims(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	session := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	setSchema := func(schema string) *authv1.UpdateClientCustomAttributesSchemaResponse {
+		resp, _ := svc.UpdateClientCustomAttributesSchema(context.Background(), &authv1.UpdateClientCustomAttributesSchemaRequest{
+			ClientId: "client-1", ClientSecret: "secret", Schema: schema,
+		})
+		return resp
+	}
+	if resp := setSchema(`{"anyOf": []}`); resp.Success {
+		t.Fatalf("expected an unsupported schema to be rejected")
+	}
+	if resp := setSchema(`{"type": "object", "properties": {"tier": {"enum": ["free", "pro"]}}, "required": ["tier"]}`); !resp.Success {
+		t.Fatalf("expected the schema to be stored, got %v", resp)
+	}
+
+	setAttributes := func(attributes string) *authv1.UserProfileResponse {
+		resp, _ := svc.UpdateUserProfile(context.Background(), &authv1.UpdateUserProfileRequest{AccessToken: session.AccessToken, CustomAttributes: &attributes})
+		return resp
+	}
+	if resp := setAttributes(`["not", "an", "object"]`); resp.Success {
+		t.Fatalf("expected a non-object to be rejected")
+	}
+	if resp := setAttributes(`{"tier": "enterprise"}`); resp.Success {
+		t.Fatalf("expected attributes violating the schema to be rejected")
+	}
+	resp := setAttributes(`{ "tier": "pro" }`)
+	if !resp.Success || resp.Profile.CustomAttributes != `{"tier":"pro"}` {
+		t.Fatalf("expected the attributes to be stored compacted, got %v", resp)
+	}
+
+	// Attributes reach access tokens only for clients that embed them
+	claims, err := utils.ValidateJWTToken(loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop").AccessToken)
+	if err != nil || claims.CustomAttributes != nil {
+		t.Fatalf("expected no custom attributes claim, got %+v (%v)", claims, err)
+	}
+	if err := db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("embed_custom_attributes", true).Error; err != nil {
+		t.Fatalf("failed to enable embedding: %v", err)
+	}
+	claims, err = utils.ValidateJWTToken(loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop").AccessToken)
+	if err != nil || string(claims.CustomAttributes) != `{"tier":"pro"}` {
+		t.Fatalf("expected the custom attributes claim, got %+v (%v)", claims, err)
+	}
+}
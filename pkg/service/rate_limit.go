@@ -0,0 +1,134 @@
+package service
+
+import (
+	"authservice/pkg/ratelimit"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultRateLimits are the limits of the RPC spec, plus the HTTP endpoints
+// that check passwords or client secrets, applied when RATE_LIMITS is unset
+const defaultRateLimits = "GetToken=5/15m@client+user,ValidateToken=1000/1m@client,RegisterUser=10/1h@client," +
+	"/oauth/token=60/1m@client+ip,/oauth/authorize=20/15m@client+ip+user,/device=20/15m@ip+user"
+
+// rateLimitBucketIdle is how long the cleanup service keeps a shared bucket
+// nobody has drawn from. Rules with longer periods forget their callers early.
+const rateLimitBucketIdle = 24 * time.Hour
+
+// RateLimiterFromEnv returns the limiter for RATE_LIMITS (see
+// ratelimit.ParseRules), or nil when it is "none". Buckets live in memory
+// unless RATE_LIMIT_STORE=database shares them between replicas through db.
+func RateLimiterFromEnv(db *gorm.DB) (*ratelimit.Limiter, error) {
+	spec, ok := os.LookupEnv("RATE_LIMITS")
+	if !ok {
+		spec = defaultRateLimits
+	}
+	if spec == "none" {
+		return nil, nil
+	}
+	rules, err := ratelimit.ParseRules(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind := os.Getenv("RATE_LIMIT_STORE"); kind {
+	case "", "memory":
+		return ratelimit.New(ratelimit.NewMemoryStore(), rules), nil
+	case "database":
+		return ratelimit.New(NewRateLimitStore(db), rules), nil
+	default:
+		return nil, fmt.Errorf("unsupported RATE_LIMIT_STORE %q", kind)
+	}
+}
+
+// rateLimitStore keeps rate limit buckets in the database, so every replica
+// draws from the same ones
+type rateLimitStore struct {
+	repo *repository.AuthRepository
+}
+
+func NewRateLimitStore(db *gorm.DB) ratelimit.Store {
+	return rateLimitStore{repo: repository.NewAuthRepository(db)}
+}
+
+func (s rateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (time.Duration, error) {
+	return s.repo.TakeRateLimitToken(ctx, key, limit)
+}
+
+// RateLimitCaller identifies who a call is charged to: the client it names,
+// the caller's address, and the user of its access token. Without a valid
+// access token the email being signed in with stands in for the user, and
+// the token's client for a client the request does not name.
+func RateLimitCaller(ctx context.Context, req any) ratelimit.Caller {
+	caller := ratelimit.Caller{IP: clientIP(ctx)}
+	if r, ok := req.(interface{ GetClientId() string }); ok {
+		caller.ClientID = r.GetClientId()
+	}
+	if r, ok := req.(interface{ GetAccessToken() string }); ok && r.GetAccessToken() != "" {
+		// Only a verified token names the user; anyone could make one up
+		if claims, err := utils.ValidateJWTToken(r.GetAccessToken()); err == nil {
+			caller.UserID = claims.UserID
+			if caller.ClientID == "" {
+				caller.ClientID = claims.ClientID
+			}
+		}
+	}
+	if r, ok := req.(interface{ GetEmail() string }); ok && caller.UserID == "" && r.GetEmail() != "" {
+		caller.UserID = "email:" + normalizeEmail(r.GetEmail())
+	}
+	return caller
+}
+
+// RateLimitHTTPCaller identifies who an HTTP request is charged to, like
+// RateLimitCaller: the client_id it names or authenticates as, the caller's
+// address, and the user of its bearer token, or else the email of a sign-in
+// form.
+func RateLimitHTTPCaller(r *http.Request) ratelimit.Caller {
+	caller := ratelimit.Caller{IP: clientIP(withHTTPClientIP(r.Context(), r))}
+	form := peekForm(r)
+	caller.ClientID = form.Get("client_id")
+	if caller.ClientID == "" {
+		caller.ClientID = r.URL.Query().Get("client_id")
+	}
+	if clientID, _, ok := r.BasicAuth(); ok && caller.ClientID == "" {
+		caller.ClientID = clientID
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := utils.ValidateJWTToken(token); err == nil {
+			caller.UserID = claims.UserID
+		}
+	}
+	if email := form.Get("email"); caller.UserID == "" && email != "" {
+		caller.UserID = "email:" + normalizeEmail(email)
+	}
+	return caller
+}
+
+// peekForm parses a posted form without consuming it, so the handler can
+// still read the body, and its own size limit and errors still apply
+func peekForm(r *http.Request) url.Values {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return url.Values{}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxFormBytes))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return url.Values{}
+	}
+	form, _ := url.ParseQuery(string(body))
+	return form
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"authservice/pkg/ratelimit"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serveWithLimiter serves svc behind the limiter over an in-memory connection
+func serveWithLimiter(t *testing.T, svc *AuthServiceServerImpl, limiter *ratelimit.Limiter) authv1.AuthServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor(RateLimitCaller)))
+	authv1.RegisterAuthServiceServer(server, svc)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv1.NewAuthServiceClient(conn)
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	rules, err := ratelimit.ParseRules(" GetToken=5/15m@client+user, *=100/s ")
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("expected two rules, got %v (%v)", rules, err)
+	}
+	if rules[0].Method != "GetToken" || rules[0].Requests != 5 || rules[0].Per != 15*time.Minute || len(rules[0].KeyBy) != 2 {
+		t.Fatalf("unexpected first rule: %+v", rules[0])
+	}
+	if rules[1].Per != time.Second || len(rules[1].KeyBy) != 3 {
+		t.Fatalf("expected a bare unit and every dimension by default, got %+v", rules[1])
+	}
+	for _, spec := range []string{"GetToken", "GetToken=0/m", "GetToken=5/-1m", "GetToken=5/m@device"} {
+		if _, err := ratelimit.ParseRules(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestRateLimitInterceptor_PerClientWithRetryAfter(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+	rules, _ := ratelimit.ParseRules("GetToken=2/1m@client")
+	client := serveWithLimiter(t, svc, ratelimit.New(ratelimit.NewMemoryStore(), rules))
+
+	getToken := func(clientID string, header *metadata.MD) error {
+		_, err := client.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: clientID}, grpc.Header(header))
+		return err
+	}
+	var header metadata.MD
+	for i := 0; i < 2; i++ {
+		if err := getToken("client-1", &header); err != nil {
+			t.Fatalf("expected call %d to be allowed, got %v", i+1, err)
+		}
+	}
+	if err := getToken("client-1", &header); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the third call to be limited, got %v", err)
+	}
+	// The bucket refills while the calls run, so the wait is at most 30 seconds
+	retryAfter := header.Get(ratelimit.RetryAfterHeader)
+	if len(retryAfter) != 1 {
+		t.Fatalf("expected a retry-after header, got %v", header)
+	}
+	if seconds, err := strconv.Atoi(retryAfter[0]); err != nil || seconds < 1 || seconds > 30 {
+		t.Fatalf("expected to be told to retry within 30 seconds, got %q", retryAfter[0])
+	}
+	if err := getToken("client-2", &header); err != nil {
+		t.Fatalf("expected other clients to have their own budget, got %v", err)
+	}
+	if _, err := client.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: "x"}); err != nil {
+		t.Fatalf("expected methods without a rule to be unlimited, got %v", err)
+	}
+}
+
+func TestRateLimitInterceptor_SharedStore(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	rules, _ := ratelimit.ParseRules("*=3/1h@ip")
+
+	// Two replicas drawing from the same buckets enforce one budget
+	replicas := []authv1.AuthServiceClient{
+		serveWithLimiter(t, svc, ratelimit.New(NewRateLimitStore(db), rules)),
+		serveWithLimiter(t, svc, ratelimit.New(NewRateLimitStore(db), rules)),
+	}
+	var allowed int
+	for i := 0; i < 6; i++ {
+		_, err := replicas[i%2].ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: "x"})
+		if err == nil {
+			allowed++
+		} else if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("expected the replicas to share a budget of 3, got %d calls allowed", allowed)
+	}
+}
+
+func TestRateLimitCaller_NormalizesEmail(t *testing.T) {
+	caller := func(email string) string {
+		return RateLimitCaller(context.Background(), &authv1.GetTokenRequest{Email: email, ClientId: "client-1"}).UserID
+	}
+	if caller(" Alice@Example.com") != caller("alice@example.com") {
+		t.Fatalf("expected spellings of one address to share a bucket, got %q and %q", caller(" Alice@Example.com"), caller("alice@example.com"))
+	}
+}
+
+func TestRateLimitHTTPMiddleware(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+	rules, _ := ratelimit.ParseRules("/oauth/token=2/1m@client+ip")
+	handler := ratelimit.New(ratelimit.NewMemoryStore(), rules).HTTPMiddleware(NewHTTPHandler(svc), RateLimitHTTPCaller)
+
+	token := func(clientID string) int {
+		return postToken(handler, url.Values{"grant_type": {"password"}, "client_id": {clientID}, "client_secret": {"secret"}}).Code
+	}
+	// The handler still reads the form the limiter looked at
+	for i := 0; i < 2; i++ {
+		if code := token("client-1"); code != http.StatusBadRequest {
+			t.Fatalf("expected request %d to reach the token endpoint, got %d", i+1, code)
+		}
+	}
+	rec := postToken(handler, url.Values{"grant_type": {"password"}, "client_id": {"client-1"}, "client_secret": {"secret"}})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the third request to be limited with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+	if code := token("client-2"); code != http.StatusBadRequest {
+		t.Fatalf("expected other clients to have their own budget, got %d", code)
+	}
+}
@@ -0,0 +1,561 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	maxRoleNameLength = 100
+	maxResourceLength = 255
+)
+
+func (a *AdminServiceServerImpl) CreateRole(ctx context.Context, req *authv1.CreateRoleRequest) (*authv1.RoleResponse, error) {
+	log.Printf("CreateRole request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.RoleResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+	if err := validateRBACName(req.Name); err != nil {
+		return &authv1.RoleResponse{Success: false, Message: err.Error()}, nil
+	}
+	if _, err := a.auth.repo.GetClientByID(ctx, req.ClientId); err != nil {
+		return &authv1.RoleResponse{Success: false, Message: "Invalid client ID"}, nil
+	}
+
+	role := &models.Role{
+		RoleID:       utils.GenerateUUID(),
+		ClientID:     req.ClientId,
+		Name:         req.Name,
+		Description:  req.Description,
+		ParentRoleID: req.ParentRoleId,
+	}
+	if err := a.auth.validateParentRole(ctx, role); err != nil {
+		return &authv1.RoleResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := a.auth.repo.CreateRole(ctx, role); err != nil {
+		log.Printf("Error creating role: %v", err)
+		return &authv1.RoleResponse{Success: false, Message: "Failed to create role; the name may already be taken"}, nil
+	}
+
+	log.Printf("Role %s created for client %s", role.RoleID, role.ClientID)
+	return &authv1.RoleResponse{Success: true, Message: "Role created successfully", Role: roleInfo(role, nil)}, nil
+}
+
+func (a *AdminServiceServerImpl) UpdateRole(ctx context.Context, req *authv1.UpdateRoleRequest) (*authv1.RoleResponse, error) {
+	log.Printf("UpdateRole request received for role: %s", req.RoleId)
+
+	if req.RoleId == "" {
+		return &authv1.RoleResponse{Success: false, Message: "Role ID is required"}, nil
+	}
+	if err := validateRBACName(req.Name); err != nil {
+		return &authv1.RoleResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	role, err := a.auth.repo.GetRoleByID(ctx, req.RoleId)
+	if err != nil {
+		return &authv1.RoleResponse{Success: false, Message: "Role not found"}, nil
+	}
+	role.Name = req.Name
+	role.Description = req.Description
+	role.ParentRoleID = req.ParentRoleId
+	if err := a.auth.validateParentRole(ctx, role); err != nil {
+		return &authv1.RoleResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := a.auth.repo.UpdateRole(ctx, role); err != nil {
+		log.Printf("Error updating role: %v", err)
+		return &authv1.RoleResponse{Success: false, Message: "Failed to update role; the name may already be taken"}, nil
+	}
+
+	permissions, err := a.auth.repo.ListRolePermissionNames(ctx, []string{role.RoleID})
+	if err != nil {
+		log.Printf("Error listing role permissions: %v", err)
+		return &authv1.RoleResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	return &authv1.RoleResponse{Success: true, Message: "Role updated successfully", Role: roleInfo(role, permissions[role.RoleID])}, nil
+}
+
+func (a *AdminServiceServerImpl) DeleteRole(ctx context.Context, req *authv1.DeleteRoleRequest) (*authv1.RbacResponse, error) {
+	log.Printf("DeleteRole request received for role: %s", req.RoleId)
+
+	if req.RoleId == "" {
+		return &authv1.RbacResponse{Success: false, Message: "Role ID is required"}, nil
+	}
+	if _, err := a.auth.repo.GetRoleByID(ctx, req.RoleId); err != nil {
+		return &authv1.RbacResponse{Success: false, Message: "Role not found"}, nil
+	}
+
+	if err := a.auth.repo.DeleteRole(ctx, req.RoleId); err != nil {
+		if errors.Is(err, repository.ErrRoleHasChildren) {
+			return &authv1.RbacResponse{Success: false, Message: "Role has child roles; delete or re-parent them first"}, nil
+		}
+		log.Printf("Error deleting role: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Role %s deleted", req.RoleId)
+	return &authv1.RbacResponse{Success: true, Message: "Role deleted successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) ListRoles(ctx context.Context, req *authv1.ListRolesRequest) (*authv1.ListRolesResponse, error) {
+	log.Printf("ListRoles request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.ListRolesResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+
+	roles, err := a.auth.repo.ListRolesByClient(ctx, req.ClientId)
+	if err != nil {
+		log.Printf("Error listing roles: %v", err)
+		return &authv1.ListRolesResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	roleIDs := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.RoleID)
+	}
+	permissions, err := a.auth.repo.ListRolePermissionNames(ctx, roleIDs)
+	if err != nil {
+		log.Printf("Error listing role permissions: %v", err)
+		return &authv1.ListRolesResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	infos := make([]*authv1.Role, 0, len(roles))
+	for i := range roles {
+		infos = append(infos, roleInfo(&roles[i], permissions[roles[i].RoleID]))
+	}
+	return &authv1.ListRolesResponse{Success: true, Message: "Roles retrieved successfully", Roles: infos}, nil
+}
+
+func (a *AdminServiceServerImpl) CreatePermission(ctx context.Context, req *authv1.CreatePermissionRequest) (*authv1.PermissionResponse, error) {
+	log.Printf("CreatePermission request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.PermissionResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+	if err := validateRBACName(req.Name); err != nil {
+		return &authv1.PermissionResponse{Success: false, Message: err.Error()}, nil
+	}
+	if _, err := a.auth.repo.GetClientByID(ctx, req.ClientId); err != nil {
+		return &authv1.PermissionResponse{Success: false, Message: "Invalid client ID"}, nil
+	}
+
+	permission := &models.Permission{
+		PermissionID: utils.GenerateUUID(),
+		ClientID:     req.ClientId,
+		Name:         req.Name,
+		Description:  req.Description,
+	}
+	if err := a.auth.repo.CreatePermission(ctx, permission); err != nil {
+		log.Printf("Error creating permission: %v", err)
+		return &authv1.PermissionResponse{Success: false, Message: "Failed to create permission; the name may already be taken"}, nil
+	}
+
+	log.Printf("Permission %s created for client %s", permission.PermissionID, permission.ClientID)
+	return &authv1.PermissionResponse{Success: true, Message: "Permission created successfully", Permission: permissionInfo(permission)}, nil
+}
+
+func (a *AdminServiceServerImpl) DeletePermission(ctx context.Context, req *authv1.DeletePermissionRequest) (*authv1.RbacResponse, error) {
+	log.Printf("DeletePermission request received for permission: %s", req.PermissionId)
+
+	if req.PermissionId == "" {
+		return &authv1.RbacResponse{Success: false, Message: "Permission ID is required"}, nil
+	}
+	if _, err := a.auth.repo.GetPermissionByID(ctx, req.PermissionId); err != nil {
+		return &authv1.RbacResponse{Success: false, Message: "Permission not found"}, nil
+	}
+
+	if err := a.auth.repo.DeletePermission(ctx, req.PermissionId); err != nil {
+		log.Printf("Error deleting permission: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	return &authv1.RbacResponse{Success: true, Message: "Permission deleted successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) ListPermissions(ctx context.Context, req *authv1.ListPermissionsRequest) (*authv1.ListPermissionsResponse, error) {
+	log.Printf("ListPermissions request received for client: %s", req.ClientId)
+
+	if req.ClientId == "" {
+		return &authv1.ListPermissionsResponse{Success: false, Message: "Client ID is required"}, nil
+	}
+
+	permissions, err := a.auth.repo.ListPermissionsByClient(ctx, req.ClientId)
+	if err != nil {
+		log.Printf("Error listing permissions: %v", err)
+		return &authv1.ListPermissionsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	infos := make([]*authv1.Permission, 0, len(permissions))
+	for i := range permissions {
+		infos = append(infos, permissionInfo(&permissions[i]))
+	}
+	return &authv1.ListPermissionsResponse{Success: true, Message: "Permissions retrieved successfully", Permissions: infos}, nil
+}
+
+func (a *AdminServiceServerImpl) GrantPermission(ctx context.Context, req *authv1.RolePermissionRequest) (*authv1.RbacResponse, error) {
+	log.Printf("GrantPermission request received for role: %s", req.RoleId)
+
+	if err := a.auth.checkRolePermissionPair(ctx, req); err != nil {
+		return &authv1.RbacResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := a.auth.repo.GrantPermission(ctx, req.RoleId, req.PermissionId); err != nil {
+		log.Printf("Error granting permission: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	return &authv1.RbacResponse{Success: true, Message: "Permission granted successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) RevokePermission(ctx context.Context, req *authv1.RolePermissionRequest) (*authv1.RbacResponse, error) {
+	log.Printf("RevokePermission request received for role: %s", req.RoleId)
+
+	if req.RoleId == "" || req.PermissionId == "" {
+		return &authv1.RbacResponse{Success: false, Message: "Role ID and permission ID are required"}, nil
+	}
+
+	revoked, err := a.auth.repo.RevokePermission(ctx, req.RoleId, req.PermissionId)
+	if err != nil {
+		log.Printf("Error revoking permission: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if revoked == 0 {
+		return &authv1.RbacResponse{Success: false, Message: "Permission is not granted to this role"}, nil
+	}
+	return &authv1.RbacResponse{Success: true, Message: "Permission revoked successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) AssignRole(ctx context.Context, req *authv1.UserRoleRequest) (*authv1.RbacResponse, error) {
+	log.Printf("AssignRole request received for user: %s", req.UserId)
+
+	if req.UserId == "" || req.RoleId == "" {
+		return &authv1.RbacResponse{Success: false, Message: "User ID and role ID are required"}, nil
+	}
+	if len(req.Resource) > maxResourceLength {
+		return &authv1.RbacResponse{Success: false, Message: fmt.Sprintf("resource must be at most %d characters", maxResourceLength)}, nil
+	}
+
+	user, err := a.auth.repo.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return &authv1.RbacResponse{Success: false, Message: "User not found"}, nil
+	}
+	role, err := a.auth.repo.GetRoleByID(ctx, req.RoleId)
+	if err != nil {
+		return &authv1.RbacResponse{Success: false, Message: "Role not found"}, nil
+	}
+	// Roles are client-scoped; only users who can sign in to the client may hold them
+	if !a.auth.userBelongsToClient(ctx, user, role.ClientID) {
+		return &authv1.RbacResponse{Success: false, Message: "Role belongs to another client"}, nil
+	}
+
+	if err := a.auth.repo.AssignRole(ctx, &models.UserRole{UserID: user.UserID, RoleID: role.RoleID, Resource: req.Resource}); err != nil {
+		log.Printf("Error assigning role: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Role %s assigned to user %s", role.RoleID, user.UserID)
+	return &authv1.RbacResponse{Success: true, Message: "Role assigned successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) UnassignRole(ctx context.Context, req *authv1.UserRoleRequest) (*authv1.RbacResponse, error) {
+	log.Printf("UnassignRole request received for user: %s", req.UserId)
+
+	if req.UserId == "" || req.RoleId == "" {
+		return &authv1.RbacResponse{Success: false, Message: "User ID and role ID are required"}, nil
+	}
+
+	removed, err := a.auth.repo.UnassignRole(ctx, req.UserId, req.RoleId, req.Resource)
+	if err != nil {
+		log.Printf("Error unassigning role: %v", err)
+		return &authv1.RbacResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if removed == 0 {
+		return &authv1.RbacResponse{Success: false, Message: "Role is not assigned to this user on this resource"}, nil
+	}
+	return &authv1.RbacResponse{Success: true, Message: "Role unassigned successfully"}, nil
+}
+
+func (a *AdminServiceServerImpl) ListUserRoles(ctx context.Context, req *authv1.ListUserRolesRequest) (*authv1.ListUserRolesResponse, error) {
+	log.Printf("ListUserRoles request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.ListUserRolesResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	user, err := a.auth.repo.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return &authv1.ListUserRolesResponse{Success: false, Message: "User not found"}, nil
+	}
+	clientID := req.ClientId
+	if clientID == "" {
+		clientID = user.ClientID
+	}
+	assignments, roles, err := a.auth.loadUserRoles(ctx, user, clientID)
+	if err != nil {
+		log.Printf("Error listing user roles: %v", err)
+		return &authv1.ListUserRolesResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	infos := make([]*authv1.UserRoleAssignment, 0, len(assignments))
+	for _, assignment := range assignments {
+		infos = append(infos, &authv1.UserRoleAssignment{
+			RoleId:    assignment.RoleID,
+			RoleName:  roles[assignment.RoleID].Name,
+			Resource:  assignment.Resource,
+			CreatedAt: timestamppb.New(assignment.CreatedAt),
+		})
+	}
+	return &authv1.ListUserRolesResponse{Success: true, Message: "User roles retrieved successfully", Assignments: infos}, nil
+}
+
+func (s *AuthServiceServerImpl) CheckPermission(ctx context.Context, req *authv1.CheckPermissionRequest) (*authv1.CheckPermissionResponse, error) {
+	log.Printf("CheckPermission request received from client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" || req.UserId == "" || req.Permission == "" {
+		return &authv1.CheckPermissionResponse{Success: false, Message: "client_id, client_secret, user_id and permission are required"}, nil
+	}
+
+	client, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return &authv1.CheckPermissionResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+	// A client can only ask about users who can sign in to it
+	user, err := s.repo.GetUserByID(ctx, req.UserId)
+	if err != nil || !s.userBelongsToClient(ctx, user, client.ClientID) {
+		return &authv1.CheckPermissionResponse{Success: false, Message: "User not found"}, nil
+	}
+
+	grantedBy, err := s.checkPermission(ctx, user, client.ClientID, req.Permission, req.Resource)
+	if err != nil {
+		log.Printf("Error checking permission: %v", err)
+		return &authv1.CheckPermissionResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if grantedBy == "" {
+		return &authv1.CheckPermissionResponse{Success: true, Message: "Permission denied", Allowed: false}, nil
+	}
+	return &authv1.CheckPermissionResponse{Success: true, Message: "Permission granted", Allowed: true, GrantedBy: grantedBy}, nil
+}
+
+// checkPermission returns the name of the user's role in the client that
+// grants the permission on the resource, or "" when none does. A role
+// assignment grants the permissions of the role and of every ancestor it
+// inherits from.
+func (s *AuthServiceServerImpl) checkPermission(ctx context.Context, user *models.User, clientID, permission, resource string) (string, error) {
+	assignments, roles, err := s.loadUserRoles(ctx, user, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	// Expand each matching assignment to its role's ancestry, remembering
+	// which assigned role brought each one in
+	assignedBy := map[string]string{}
+	var roleIDs []string
+	for _, assignment := range assignments {
+		if !resourceMatches(assignment.Resource, resource) {
+			continue
+		}
+		for _, roleID := range roleAncestry(roles, assignment.RoleID) {
+			if _, seen := assignedBy[roleID]; !seen {
+				assignedBy[roleID] = roles[assignment.RoleID].Name
+				roleIDs = append(roleIDs, roleID)
+			}
+		}
+	}
+	if len(roleIDs) == 0 {
+		return "", nil
+	}
+
+	granted, err := s.repo.ListRolePermissionNames(ctx, roleIDs)
+	if err != nil {
+		return "", err
+	}
+	for _, roleID := range roleIDs {
+		for _, name := range granted[roleID] {
+			if name == permission {
+				return assignedBy[roleID], nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// loadUserRoles returns the user's assignments to roles of the client
+// together with all of the client's roles, keyed by ID. Users of a shared
+// identity pool may hold roles in several clients.
+func (s *AuthServiceServerImpl) loadUserRoles(ctx context.Context, user *models.User, clientID string) ([]models.UserRole, map[string]*models.Role, error) {
+	assignments, err := s.repo.ListUserRoles(ctx, user.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(assignments) == 0 {
+		return nil, nil, nil
+	}
+	clientRoles, err := s.repo.ListRolesByClient(ctx, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roles := make(map[string]*models.Role, len(clientRoles))
+	for i := range clientRoles {
+		roles[clientRoles[i].RoleID] = &clientRoles[i]
+	}
+	valid := assignments[:0]
+	for _, assignment := range assignments {
+		if roles[assignment.RoleID] != nil {
+			valid = append(valid, assignment)
+		}
+	}
+	return valid, roles, nil
+}
+
+// tokenRoles returns the role claims for a user's access token to the
+// client: the names of the client's roles assigned to the user on every
+// resource. Resource-scoped roles are left to CheckPermission. Disabled
+// unless EMBED_ROLE_CLAIMS is true.
+func (s *AuthServiceServerImpl) tokenRoles(ctx context.Context, user *models.User, clientID string) ([]string, error) {
+	if os.Getenv("EMBED_ROLE_CLAIMS") != "true" {
+		return nil, nil
+	}
+	assignments, roles, err := s.loadUserRoles(ctx, user, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, assignment := range assignments {
+		if assignment.Resource == "" {
+			names = append(names, roles[assignment.RoleID].Name)
+		}
+	}
+	return names, nil
+}
+
+// validateParentRole checks that a role's parent exists in the same client
+// and that making it the parent does not create a cycle
+func (s *AuthServiceServerImpl) validateParentRole(ctx context.Context, role *models.Role) error {
+	if role.ParentRoleID == "" {
+		return nil
+	}
+	if role.ParentRoleID == role.RoleID {
+		return errors.New("a role cannot inherit from itself")
+	}
+
+	parent, err := s.repo.GetRoleByID(ctx, role.ParentRoleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("parent role not found")
+		}
+		return err
+	}
+	if parent.ClientID != role.ClientID {
+		return errors.New("parent role belongs to another client")
+	}
+
+	roles, err := s.repo.ListRolesByClient(ctx, role.ClientID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*models.Role, len(roles))
+	for i := range roles {
+		byID[roles[i].RoleID] = &roles[i]
+	}
+	for _, ancestor := range roleAncestry(byID, parent.RoleID) {
+		if ancestor == role.RoleID {
+			return errors.New("parent role would create an inheritance cycle")
+		}
+	}
+	return nil
+}
+
+// checkRolePermissionPair checks a role and permission exist in the same client
+func (s *AuthServiceServerImpl) checkRolePermissionPair(ctx context.Context, req *authv1.RolePermissionRequest) error {
+	if req.RoleId == "" || req.PermissionId == "" {
+		return errors.New("Role ID and permission ID are required")
+	}
+	role, err := s.repo.GetRoleByID(ctx, req.RoleId)
+	if err != nil {
+		return errors.New("Role not found")
+	}
+	permission, err := s.repo.GetPermissionByID(ctx, req.PermissionId)
+	if err != nil {
+		return errors.New("Permission not found")
+	}
+	if role.ClientID != permission.ClientID {
+		return errors.New("Role and permission belong to different clients")
+	}
+	return nil
+}
+
+// roleAncestry returns the role followed by its ancestors, stopping at a
+// missing parent or a cycle
+func roleAncestry(roles map[string]*models.Role, roleID string) []string {
+	var chain []string
+	seen := map[string]bool{}
+	for roleID != "" && !seen[roleID] && roles[roleID] != nil {
+		seen[roleID] = true
+		chain = append(chain, roleID)
+		roleID = roles[roleID].ParentRoleID
+	}
+	return chain
+}
+
+// resourceMatches reports whether a role assigned on assigned covers
+// resource. An empty assignment covers every resource and a trailing *
+// matches by prefix.
+func resourceMatches(assigned, resource string) bool {
+	if assigned == "" || assigned == resource {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(assigned, "*"); ok {
+		return strings.HasPrefix(resource, prefix)
+	}
+	return false
+}
+
+// validateRBACName checks role and permission names; they are referenced by
+// services and JWT claims, so they cannot contain whitespace
+func validateRBACName(name string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if len(name) > maxRoleNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxRoleNameLength)
+	}
+	if strings.ContainsAny(name, " \t\r\n") {
+		return errors.New("name cannot contain whitespace")
+	}
+	return nil
+}
+
+func roleInfo(role *models.Role, permissions []string) *authv1.Role {
+	return &authv1.Role{
+		RoleId:       role.RoleID,
+		ClientId:     role.ClientID,
+		Name:         role.Name,
+		Description:  role.Description,
+		ParentRoleId: role.ParentRoleID,
+		Permissions:  permissions,
+		CreatedAt:    timestamppb.New(role.CreatedAt),
+	}
+}
+
+func permissionInfo(permission *models.Permission) *authv1.Permission {
+	return &authv1.Permission{
+		PermissionId: permission.PermissionID,
+		ClientId:     permission.ClientID,
+		Name:         permission.Name,
+		Description:  permission.Description,
+		CreatedAt:    timestamppb.New(permission.CreatedAt),
+	}
+}
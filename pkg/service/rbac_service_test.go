@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createRole(t *testing.T, admin *AdminServiceServerImpl, ctx context.Context, clientID, name, parentID string) string {
+	t.Helper()
+	resp, err := admin.CreateRole(ctx, &authv1.CreateRoleRequest{ClientId: clientID, Name: name, ParentRoleId: parentID})
+	if err != nil || !resp.Success {
+		t.Fatalf("failed to create role %s: err=%v resp=%v", name, err, resp)
+	}
+	return resp.Role.RoleId
+}
+
+func createPermission(t *testing.T, admin *AdminServiceServerImpl, ctx context.Context, clientID, name string) string {
+	t.Helper()
+	resp, err := admin.CreatePermission(ctx, &authv1.CreatePermissionRequest{ClientId: clientID, Name: name})
+	if err != nil || !resp.Success {
+		t.Fatalf("failed to create permission %s: err=%v resp=%v", name, err, resp)
+	}
+	return resp.Permission.PermissionId
+}
+
+func TestCheckPermission_InheritsFromParentRoles(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	ctx := context.Background()
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	viewer := createRole(t, admin, ctx, "client-1", "viewer", "")
+	editor := createRole(t, admin, ctx, "client-1", "editor", viewer)
+	read := createPermission(t, admin, ctx, "client-1", "documents:read")
+	write := createPermission(t, admin, ctx, "client-1", "documents:write")
+	admin.GrantPermission(ctx, &authv1.RolePermissionRequest{RoleId: viewer, PermissionId: read})
+	admin.GrantPermission(ctx, &authv1.RolePermissionRequest{RoleId: editor, PermissionId: write})
+
+	if resp, _ := admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: editor}); !resp.Success {
+		t.Fatalf("expected role assignment to succeed, got %v", resp)
+	}
+
+	for _, permission := range []string{"documents:read", "documents:write"} {
+		resp, _ := svc.CheckPermission(context.Background(), &authv1.CheckPermissionRequest{
+			ClientId: "client-1", ClientSecret: "secret", UserId: "user-1", Permission: permission,
+		})
+		if !resp.Success || !resp.Allowed || resp.GrantedBy != "editor" {
+			t.Fatalf("expected %s to be granted by editor, got %v", permission, resp)
+		}
+	}
+	resp, _ := svc.CheckPermission(context.Background(), &authv1.CheckPermissionRequest{
+		ClientId: "client-1", ClientSecret: "secret", UserId: "user-1", Permission: "documents:delete",
+	})
+	if !resp.Success || resp.Allowed {
+		t.Fatalf("expected documents:delete to be denied, got %v", resp)
+	}
+
+	// A role with children cannot be deleted, and a parent cannot become its own descendant
+	if del, _ := admin.DeleteRole(ctx, &authv1.DeleteRoleRequest{RoleId: viewer}); del.Success {
+		t.Fatalf("expected deleting a parent role to be rejected")
+	}
+	if upd, _ := admin.UpdateRole(ctx, &authv1.UpdateRoleRequest{RoleId: viewer, Name: "viewer", ParentRoleId: editor}); upd.Success {
+		t.Fatalf("expected an inheritance cycle to be rejected")
+	}
+}
+
+func TestCheckPermission_ResourceScopedAssignments(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	ctx := context.Background()
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "client-2")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	owner := createRole(t, admin, ctx, "client-1", "owner", "")
+	manage := createPermission(t, admin, ctx, "client-1", "project:manage")
+	admin.GrantPermission(ctx, &authv1.RolePermissionRequest{RoleId: owner, PermissionId: manage})
+	admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: owner, Resource: "project:42"})
+	admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: owner, Resource: "team:7/*"})
+
+	cases := map[string]bool{
+		"project:42":         true,
+		"project:43":         false,
+		"":                   false,
+		"team:7/project:100": true,
+		"team:8/project:100": false,
+	}
+	for resource, want := range cases {
+		resp, _ := svc.CheckPermission(context.Background(), &authv1.CheckPermissionRequest{
+			ClientId: "client-1", ClientSecret: "secret", UserId: "user-1", Permission: "project:manage", Resource: resource,
+		})
+		if !resp.Success || resp.Allowed != want {
+			t.Fatalf("resource %q: expected allowed=%v, got %v", resource, want, resp)
+		}
+	}
+
+	// Other clients can neither ask about the user nor hand out their roles
+	resp, _ := svc.CheckPermission(context.Background(), &authv1.CheckPermissionRequest{
+		ClientId: "client-2", ClientSecret: "secret", UserId: "user-1", Permission: "project:manage", Resource: "project:42",
+	})
+	if resp.Success {
+		t.Fatalf("expected another client's check to be rejected")
+	}
+	foreign := createRole(t, admin, ctx, "client-2", "owner", "")
+	if assign, _ := admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: foreign}); assign.Success {
+		t.Fatalf("expected cross-client role assignment to be rejected")
+	}
+
+	if unassign, _ := admin.UnassignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: owner, Resource: "project:42"}); !unassign.Success {
+		t.Fatalf("expected unassign to succeed, got %v", unassign)
+	}
+	list, _ := admin.ListUserRoles(ctx, &authv1.ListUserRolesRequest{UserId: "user-1"})
+	if !list.Success || len(list.Assignments) != 1 || list.Assignments[0].Resource != "team:7/*" {
+		t.Fatalf("expected one remaining assignment, got %v", list)
+	}
+}
+
+func TestRBAC_RequiresAdminAndEmbedsRoleClaims(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	admin := NewAdminServiceServer(svc)
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	_, err := callAdmin(context.Background(), "CreateRole", func(ctx context.Context) (*authv1.RoleResponse, error) {
+		return admin.CreateRole(ctx, &authv1.CreateRoleRequest{ClientId: "client-1", Name: "admin"})
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected CreateRole to require the admin key, got %v", err)
+	}
+
+	ctx := context.Background()
+	adminRole := createRole(t, admin, ctx, "client-1", "admin", "")
+	support := createRole(t, admin, ctx, "client-1", "support", "")
+	admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: adminRole})
+	admin.AssignRole(ctx, &authv1.UserRoleRequest{UserId: "user-1", RoleId: support, Resource: "ticket:1"})
+
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	claims, _ := utils.ValidateJWTToken(login.AccessToken)
+	if len(claims.Roles) != 0 {
+		t.Fatalf("expected no role claims by default, got %v", claims.Roles)
+	}
+
+	t.Setenv("EMBED_ROLE_CLAIMS", "true")
+	login = loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	claims, _ = utils.ValidateJWTToken(login.AccessToken)
+	if len(claims.Roles) != 1 || claims.Roles[0] != "admin" {
+		t.Fatalf("expected only the global role in the token, got %v", claims.Roles)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// clientIP returns the caller's IP address. The x-forwarded-for metadata is
+// only honoured when TRUST_PROXY_HEADERS=true, i.e. when the service sits
+// behind a proxy that overwrites it; otherwise clients could spoof it.
+func clientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(httpClientIPKey{}).(string); ok {
+		return ip
+	}
+
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("x-forwarded-for"); len(values) > 0 {
+				// The left-most entry is the original client
+				if ip := strings.TrimSpace(strings.Split(values[0], ",")[0]); ip != "" {
+					return ip
+				}
+			}
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+type httpClientIPKey struct{}
+
+// withHTTPClientIP carries the HTTP caller's address into ctx so code shared
+// with the gRPC handlers sees it through clientIP. X-Forwarded-For follows the
+// same TRUST_PROXY_HEADERS rule as the gRPC metadata.
+func withHTTPClientIP(ctx context.Context, r *http.Request) context.Context {
+	ip := ""
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		ip = strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+	}
+	if ip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip = host
+	}
+	return context.WithValue(ctx, httpClientIPKey{}, ip)
+}
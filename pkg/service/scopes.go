@@ -0,0 +1,116 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"strings"
+)
+
+// oidcScopes are requestable by every client; they only shape the ID token
+// and UserInfo response
+var oidcScopes = []string{scopeOpenID, "profile", "email"}
+
+// resolveUserScope intersects the scopes requested at login with the
+// client's allowed scopes. An empty request is granted every allowed scope,
+// so clients that declare none keep issuing unscoped tokens.
+func resolveUserScope(client *models.Client, requestedScope string) (string, error) {
+	if len(strings.Fields(requestedScope)) == 0 {
+		return strings.Join(client.AllowedScopeList(), " "), nil
+	}
+	return intersectScope(append(client.AllowedScopeList(), oidcScopes...), requestedScope)
+}
+
+// intersectScope keeps the requested scopes that are available, in request
+// order; a request left with nothing is an error rather than an unscoped token
+func intersectScope(available []string, requestedScope string) (string, error) {
+	granted := intersect(available, strings.Fields(requestedScope))
+	if len(granted) == 0 {
+		return "", newOAuthError("invalid_scope", "none of the requested scopes are allowed")
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// resolveAudience intersects the requested audiences with the allowed ones;
+// an empty request is granted all of them
+func resolveAudience(allowed, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	granted := intersect(allowed, requested)
+	if len(granted) == 0 {
+		return nil, newOAuthError("invalid_target", "none of the requested audiences are allowed")
+	}
+	return granted, nil
+}
+
+// resolveRefreshGrant narrows the scopes and audiences granted at login for
+// a refreshed access token; a refresh can never widen them. Sessions without
+// a recorded grant fall back to what the client allows.
+func (s *AuthServiceServerImpl) resolveRefreshGrant(ctx context.Context, session *models.Session, requestedScope string, requestedAudience []string) (string, []string, error) {
+	scope, audience := session.Scope, session.AudienceList()
+	if strings.TrimSpace(requestedScope) == "" && len(requestedAudience) == 0 {
+		return scope, audience, nil
+	}
+
+	var client *models.Client
+	if scope == "" || len(audience) == 0 {
+		var err error
+		if client, err = s.repo.GetClientByID(ctx, session.ClientID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if strings.TrimSpace(requestedScope) != "" {
+		var err error
+		if scope == "" {
+			scope, err = resolveUserScope(client, requestedScope)
+		} else {
+			scope, err = intersectScope(strings.Fields(scope), requestedScope)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if len(requestedAudience) > 0 {
+		allowed := audience
+		if len(allowed) == 0 {
+			allowed = client.AllowedAudienceList()
+		}
+		var err error
+		if audience, err = resolveAudience(allowed, requestedAudience); err != nil {
+			return "", nil, err
+		}
+	}
+	return scope, audience, nil
+}
+
+// checkTokenRequirements rejects a valid token that lacks a scope or the
+// audience the caller requires. Unscoped tokens satisfy no scope requirement.
+func checkTokenRequirements(claims *utils.Claims, requiredScope, requiredAudience string) *authv1.ValidateTokenResponse {
+	for _, scope := range strings.Fields(requiredScope) {
+		if !hasScope(claims.Scope, scope) {
+			return &authv1.ValidateTokenResponse{Valid: false, Message: "Token lacks required scope: " + scope}
+		}
+	}
+	if requiredAudience != "" && !containsString(claims.Audience, requiredAudience) {
+		return &authv1.ValidateTokenResponse{Valid: false, Message: "Token is not intended for this audience"}
+	}
+	return nil
+}
+
+// intersect returns the requested values that are also available, in
+// request order and without duplicates
+func intersect(available, requested []string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, value := range requested {
+		if !seen[value] && containsString(available, value) {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
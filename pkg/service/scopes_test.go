@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestGetToken_GrantsAllowedScopesAndAudiences(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Updates(map[string]interface{}{
+		"allowed_scopes":    "orders:read orders:write",
+		"allowed_audiences": "orders-api\nbilling-api",
+	})
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	login, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: "client-1",
+		Scope:    "orders:read admin",
+		Audience: []string{"orders-api", "payroll-api"},
+	})
+	if err != nil || !login.Success {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, login)
+	}
+	if login.Scope != "orders:read" || len(login.Audience) != 1 || login.Audience[0] != "orders-api" {
+		t.Fatalf("expected the request to be intersected with the client's grant, got scope=%q audience=%v", login.Scope, login.Audience)
+	}
+
+	cases := []struct {
+		scope, audience string
+		valid           bool
+	}{
+		{"", "", true},
+		{"orders:read", "orders-api", true},
+		{"orders:read orders:write", "", false},
+		{"", "billing-api", false},
+	}
+	for _, c := range cases {
+		resp, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{
+			AccessToken:      login.AccessToken,
+			RequiredScope:    c.scope,
+			RequiredAudience: c.audience,
+		})
+		if resp.Valid != c.valid {
+			t.Fatalf("scope=%q audience=%q: expected valid=%v, got %v", c.scope, c.audience, c.valid, resp)
+		}
+	}
+
+	resp, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: "client-1",
+		Scope:    "admin",
+	})
+	if resp.Success {
+		t.Fatalf("expected a request with no allowed scope to be rejected")
+	}
+}
+
+func TestRefreshToken_CanNarrowButNotWidenGrant(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("allowed_scopes", "orders:read orders:write")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	login, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:    "alice@example.com",
+		Password: "password123",
+		ClientId: "client-1",
+		Scope:    "orders:read",
+	})
+
+	refresh, _ := svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
+		RefreshToken: login.RefreshToken,
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+		Scope:        "orders:write",
+	})
+	if refresh.Success {
+		t.Fatalf("expected a refresh to be unable to widen the login's scope")
+	}
+
+	refresh, _ = svc.RefreshToken(context.Background(), &authv1.RefreshTokenRequest{
+		RefreshToken: login.RefreshToken,
+		ClientId:     "client-1",
+		ClientSecret: "secret",
+	})
+	if !refresh.Success || refresh.Scope != "orders:read" {
+		t.Fatalf("expected the refreshed token to keep the login's scope, got %v", refresh)
+	}
+	validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{
+		AccessToken:   refresh.AccessToken,
+		RequiredScope: "orders:read",
+	})
+	if !validate.Valid {
+		t.Fatalf("expected refreshed token to carry its scope, got %v", validate)
+	}
+}
+
+func TestValidateToken_UnscopedTokenFailsScopeRequirement(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	if login.Scope != "" || len(login.Audience) != 0 {
+		t.Fatalf("expected clients without a declared grant to issue unrestricted tokens, got %v", login)
+	}
+	resp, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{
+		AccessToken:   login.AccessToken,
+		RequiredScope: "orders:read",
+	})
+	if resp.Valid {
+		t.Fatalf("expected an unscoped token to fail a scope requirement")
+	}
+}
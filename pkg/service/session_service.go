@@ -0,0 +1,175 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthServiceServerImpl) ListSessions(ctx context.Context, req *authv1.ListSessionsRequest) (*authv1.ListSessionsResponse, error) {
+	log.Printf("ListSessions request received")
+
+	user, current, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.ListSessionsResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	sessions, err := s.repo.ListActiveSessionsByUser(ctx, user.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		return &authv1.ListSessionsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	return &authv1.ListSessionsResponse{
+		Success:  true,
+		Message:  "Sessions retrieved successfully",
+		Sessions: sessionInfos(sessions, current.SessionID),
+	}, nil
+}
+
+func (s *AuthServiceServerImpl) RevokeSession(ctx context.Context, req *authv1.RevokeSessionRequest) (*authv1.RevokeSessionResponse, error) {
+	log.Printf("RevokeSession request received")
+
+	if req.SessionId == "" {
+		return &authv1.RevokeSessionResponse{Success: false, Message: "Session ID is required"}, nil
+	}
+
+	user, _, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.RevokeSessionResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	// Scoped to the caller, so users can only revoke their own sessions
+	revoked, err := s.repo.DeleteUserSession(ctx, user.UserID, req.SessionId)
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		return &authv1.RevokeSessionResponse{Success: false, Message: "Internal server error"}, nil
+	}
+	if revoked == 0 {
+		return &authv1.RevokeSessionResponse{Success: false, Message: "Session not found"}, nil
+	}
+
+	log.Printf("Session %s revoked by user %s", req.SessionId, user.UserID)
+	return &authv1.RevokeSessionResponse{Success: true, Message: "Session revoked successfully"}, nil
+}
+
+func (s *AuthServiceServerImpl) RevokeOtherSessions(ctx context.Context, req *authv1.RevokeOtherSessionsRequest) (*authv1.RevokeOtherSessionsResponse, error) {
+	log.Printf("RevokeOtherSessions request received")
+
+	user, current, err := s.authenticateAccessToken(ctx, req.AccessToken)
+	if err != nil {
+		return &authv1.RevokeOtherSessionsResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	revoked, err := s.repo.DeleteOtherUserSessions(ctx, user.UserID, current.SessionID)
+	if err != nil {
+		log.Printf("Error revoking other sessions: %v", err)
+		return &authv1.RevokeOtherSessionsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Revoked %d other sessions for user %s", revoked, user.UserID)
+	return &authv1.RevokeOtherSessionsResponse{
+		Success:      true,
+		Message:      "Other sessions revoked successfully",
+		RevokedCount: revoked,
+	}, nil
+}
+
+func (a *AdminServiceServerImpl) ListUserSessions(ctx context.Context, req *authv1.ListUserSessionsRequest) (*authv1.ListSessionsResponse, error) {
+	log.Printf("ListUserSessions request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.ListSessionsResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	sessions, err := a.auth.repo.ListActiveSessionsByUser(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		return &authv1.ListSessionsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	return &authv1.ListSessionsResponse{
+		Success:  true,
+		Message:  "Sessions retrieved successfully",
+		Sessions: sessionInfos(sessions, ""),
+	}, nil
+}
+
+func (a *AdminServiceServerImpl) RevokeUserSessions(ctx context.Context, req *authv1.RevokeUserSessionsRequest) (*authv1.RevokeOtherSessionsResponse, error) {
+	log.Printf("RevokeUserSessions request received for user: %s", req.UserId)
+
+	if req.UserId == "" {
+		return &authv1.RevokeOtherSessionsResponse{Success: false, Message: "User ID is required"}, nil
+	}
+
+	var revoked int64
+	var err error
+	if req.SessionId != "" {
+		revoked, err = a.auth.repo.DeleteUserSession(ctx, req.UserId, req.SessionId)
+	} else {
+		// No session has an empty ID, so this revokes all of them
+		revoked, err = a.auth.repo.DeleteOtherUserSessions(ctx, req.UserId, "")
+	}
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		return &authv1.RevokeOtherSessionsResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Admin revoked %d sessions for user %s", revoked, req.UserId)
+	return &authv1.RevokeOtherSessionsResponse{
+		Success:      true,
+		Message:      "Sessions revoked successfully",
+		RevokedCount: revoked,
+	}, nil
+}
+
+// authenticateAccessToken resolves an access token to its user and live
+// session, applying the same checks as ValidateToken. Only the user's own
+// tokens qualify: client and delegated tokens are refused.
+func (s *AuthServiceServerImpl) authenticateAccessToken(ctx context.Context, accessToken string) (*models.User, *models.Session, error) {
+	if accessToken == "" {
+		return nil, nil, fmt.Errorf("access token is required")
+	}
+
+	claims, err := utils.ValidateJWTToken(accessToken)
+	if err != nil {
+		log.Printf("Error validating JWT token: %v", err)
+		return nil, nil, fmt.Errorf("invalid access token")
+	}
+	if claims.IsClientPrincipal() {
+		return nil, nil, fmt.Errorf("a user access token is required")
+	}
+	if claims.Act != nil {
+		return nil, nil, fmt.Errorf("delegated tokens cannot be used to manage the account")
+	}
+
+	user, session, resp := s.validateUserToken(ctx, claims)
+	if !resp.Valid {
+		return nil, nil, errors.New(resp.Message)
+	}
+	return user, session, nil
+}
+
+func sessionInfos(sessions []models.Session, currentSessionID string) []*authv1.SessionInfo {
+	infos := make([]*authv1.SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, &authv1.SessionInfo{
+			SessionId:   session.SessionID,
+			ClientId:    session.ClientID,
+			UserAgent:   session.UserAgent,
+			IpAddress:   session.IPAddress,
+			DeviceLabel: session.DeviceLabel,
+			CreatedAt:   timestamppb.New(session.CreatedAt),
+			LastUsedAt:  optionalTimestamp(session.LastUsedAt),
+			ExpiresAt:   timestamppb.New(session.ExpiresAt),
+			Current:     session.SessionID == currentSessionID,
+		})
+	}
+	return infos
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func loginAs(t *testing.T, svc *AuthServiceServerImpl, email, password, clientID, device string) *authv1.GetTokenResponse {
+	t.Helper()
+	resp, err := svc.GetToken(context.Background(), &authv1.GetTokenRequest{
+		Email:       email,
+		Password:    password,
+		ClientId:    clientID,
+		UserAgent:   "unit-test",
+		DeviceLabel: device,
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected login to succeed, got err=%v resp=%v", err, resp)
+	}
+	return resp
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	laptop := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	phone := loginAs(t, svc, "alice@example.com", "password123", "client-1", "phone")
+	tablet := loginAs(t, svc, "alice@example.com", "password123", "client-1", "tablet")
+
+	list, err := svc.ListSessions(context.Background(), &authv1.ListSessionsRequest{AccessToken: laptop.AccessToken})
+	if err != nil || !list.Success {
+		t.Fatalf("expected ListSessions to succeed, got err=%v resp=%v", err, list)
+	}
+	if len(list.Sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(list.Sessions))
+	}
+	for _, session := range list.Sessions {
+		if session.Current != (session.SessionId == laptop.SessionId) {
+			t.Fatalf("expected only the laptop session to be marked current")
+		}
+	}
+
+	revoke, err := svc.RevokeSession(context.Background(), &authv1.RevokeSessionRequest{
+		AccessToken: laptop.AccessToken,
+		SessionId:   phone.SessionId,
+	})
+	if err != nil || !revoke.Success {
+		t.Fatalf("expected RevokeSession to succeed, got err=%v resp=%v", err, revoke)
+	}
+	if resp, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: phone.AccessToken}); resp.Valid {
+		t.Fatalf("expected revoked phone session to be invalid")
+	}
+
+	others, err := svc.RevokeOtherSessions(context.Background(), &authv1.RevokeOtherSessionsRequest{AccessToken: laptop.AccessToken})
+	if err != nil || !others.Success || others.RevokedCount != 1 {
+		t.Fatalf("expected the tablet session to be revoked, got err=%v resp=%v", err, others)
+	}
+	if resp, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: tablet.AccessToken}); resp.Valid {
+		t.Fatalf("expected tablet session to be invalid")
+	}
+	if resp, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: laptop.AccessToken}); !resp.Valid {
+		t.Fatalf("expected current session to stay valid")
+	}
+}
+
+func TestAuthenticateAccessToken_SameChecksAsValidateToken(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	pooled, _ := svc.RegisterClient(adminContext(t), &authv1.RegisterClientRequest{ClientName: "mobile", IdentityPoolId: "client-1"})
+	if _, err := NewAdminServiceServer(svc).CreateOrganization(context.Background(), &authv1.CreateOrganizationRequest{ClientId: "client-1", Name: "Acme", OwnerUserId: "user-1"}); err != nil {
+		t.Fatalf("failed to create organization: %v", err)
+	}
+	alice := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	rejected := func(token string) bool {
+		validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: token})
+		_, _, err := svc.authenticateAccessToken(context.Background(), token)
+		return !validate.Valid && err != nil
+	}
+
+	// A token naming another client of the pool cannot ride on this session
+	forged, _, err := utils.GenerateJWTToken("user-1", "alice", pooled.ClientId, alice.SessionId)
+	if err != nil {
+		t.Fatalf("failed to generate jwt: %v", err)
+	}
+	if !rejected(forged) {
+		t.Fatalf("expected a token for another client's session to be rejected")
+	}
+
+	// Nor can a token keep acting in an organization the user has left
+	db.Where("user_id = ?", "user-1").Delete(&models.OrganizationMember{})
+	if !rejected(alice.AccessToken) {
+		t.Fatalf("expected a stale org_id to be rejected")
+	}
+}
+
+func TestRevokeSession_OtherUsersSession(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	seedUser(t, db, "user-2", "client-1", "bob@example.com", "bob", "password123")
+
+	alice := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	bob := loginAs(t, svc, "bob@example.com", "password123", "client-1", "laptop")
+
+	resp, err := svc.RevokeSession(context.Background(), &authv1.RevokeSessionRequest{
+		AccessToken: alice.AccessToken,
+		SessionId:   bob.SessionId,
+	})
+	if err != nil {
+		t.Fatalf("RevokeSession returned error: %v", err)
+	}
+	if resp.Success {
+		t.Fatalf("expected revoking another user's session to fail")
+	}
+}
+
+func TestAdminSessionRPCs(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	loginAs(t, svc, "alice@example.com", "password123", "client-1", "phone")
+
+	listSessions := func(ctx context.Context) (*authv1.ListSessionsResponse, error) {
+		return callAdmin(ctx, "ListUserSessions", func(ctx context.Context) (*authv1.ListSessionsResponse, error) {
+			return admin.ListUserSessions(ctx, &authv1.ListUserSessionsRequest{UserId: "user-1"})
+		})
+	}
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	if _, err := listSessions(context.Background()); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected admin RPC without credentials to be rejected, got %v", err)
+	}
+
+	list, err := listSessions(adminContext(t))
+	if err != nil || !list.Success || len(list.Sessions) != 2 {
+		t.Fatalf("expected two sessions, got err=%v resp=%v", err, list)
+	}
+
+	revoke, err := admin.RevokeUserSessions(context.Background(), &authv1.RevokeUserSessionsRequest{UserId: "user-1"})
+	if err != nil || !revoke.Success || revoke.RevokedCount != 2 {
+		t.Fatalf("expected all sessions to be revoked, got err=%v resp=%v", err, revoke)
+	}
+}
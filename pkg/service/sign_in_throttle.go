@@ -0,0 +1,124 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signInFailureMemory is how long failed sign-ins count against a key after
+// the last of them, and the longest a key can be blocked
+const signInFailureMemory = 24 * time.Hour
+
+// signInLockout is how long an account is first locked out once it reaches
+// the failure threshold
+func signInLockout() time.Duration {
+	return time.Duration(envInt("SIGN_IN_LOCKOUT_MINUTES", 15)) * time.Minute
+}
+
+// signInBackoff is how long a key is blocked after its nth failed sign-in.
+// The first half of the threshold is free; then the delay starts at a second
+// and doubles with every failure, up to the lockout. Each failure past the
+// threshold doubles the lockout, up to signInFailureMemory.
+func signInBackoff(threshold int, lockout time.Duration) func(failures int) time.Duration {
+	return func(failures int) time.Duration {
+		switch {
+		case failures >= threshold:
+			return min(lockout<<min(failures-threshold, 16), signInFailureMemory)
+		case failures > threshold/2:
+			return min(time.Second<<min(failures-threshold/2-1, 30), lockout)
+		}
+		return 0
+	}
+}
+
+// signInThrottleKeys names the counters a password sign-in is checked
+// against: the account, which need not exist, and the caller's address
+type signInThrottleKeys struct {
+	account string
+	ip      string
+}
+
+func newSignInThrottleKeys(ctx context.Context, client *models.Client, email string) signInThrottleKeys {
+	keys := signInThrottleKeys{account: accountThrottleKey(client.IdentityPool(), email)}
+	if ip := clientIP(ctx); ip != "" {
+		keys.ip = "ip:" + ip
+	}
+	return keys
+}
+
+func accountThrottleKey(identityPoolID, email string) string {
+	return "account:" + identityPoolID + ":" + normalizeEmail(email)
+}
+
+// normalizeEmail folds the spellings of an address that sign in to the same
+// account, so they share one throttle counter
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (k signInThrottleKeys) all() []string {
+	if k.ip == "" {
+		return []string{k.account}
+	}
+	return []string{k.account, k.ip}
+}
+
+// signInBlocked reports whether the account or address is blocked. A lookup
+// failure counts as blocked, so an outage cannot lift the limits.
+func (s *AuthServiceServerImpl) signInBlocked(ctx context.Context, keys signInThrottleKeys) bool {
+	throttles, err := s.repo.GetActiveSignInThrottles(ctx, keys.all())
+	if err != nil {
+		log.Printf("Error checking sign-in throttles: %v", err)
+		return true
+	}
+	return len(throttles) > 0
+}
+
+// recordSignInFailure counts a failed sign-in against the account and the
+// address. Reaching the threshold is recorded as a security event for users
+// that exist.
+func (s *AuthServiceServerImpl) recordSignInFailure(ctx context.Context, keys signInThrottleKeys, client *models.Client, user *models.User) {
+	lockout := signInLockout()
+	threshold := envInt("SIGN_IN_LOCKOUT_THRESHOLD", 10)
+	throttle, err := s.repo.RecordSignInFailure(ctx, keys.account, signInFailureMemory, signInBackoff(threshold, lockout))
+	if err != nil {
+		log.Printf("Error recording failed sign-in: %v", err)
+	} else if throttle.Failures >= threshold && user != nil {
+		log.Printf("Sign-in locked out for user %s after %d failed attempts", user.UserID, throttle.Failures)
+		s.recordSecurityEvent(ctx, models.SecurityEventSignInLockout, user.UserID, client.ClientID,
+			fmt.Sprintf("%d failed sign-in attempts; sign-in blocked until %s", throttle.Failures, throttle.BlockedUntil.UTC().Format(time.RFC3339)))
+	}
+
+	if keys.ip != "" {
+		ipThreshold := envInt("SIGN_IN_IP_LOCKOUT_THRESHOLD", 100)
+		if _, err := s.repo.RecordSignInFailure(ctx, keys.ip, signInFailureMemory, signInBackoff(ipThreshold, lockout)); err != nil {
+			log.Printf("Error recording failed sign-in: %v", err)
+		}
+	}
+}
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyHash             string
+)
+
+// dummyPasswordHash is a hash of a random password, compared against when no
+// user has the email so that the response takes as long as a wrong password
+func dummyPasswordHash() string {
+	dummyPasswordHashOnce.Do(func() {
+		secret, err := utils.GenerateRefreshToken()
+		if err == nil {
+			dummyHash, err = utils.HashPassword(secret)
+		}
+		if err != nil {
+			log.Printf("Error hashing dummy password: %v", err)
+		}
+	})
+	return dummyHash
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	authv1 "authservice/proto/auth/v1"
+
+	"google.golang.org/grpc/peer"
+)
+
+func TestSignInBackoff(t *testing.T) {
+	backoff := signInBackoff(10, 15*time.Minute)
+	for failures, want := range map[int]time.Duration{
+		1:  0,
+		5:  0,
+		6:  time.Second,
+		9:  8 * time.Second,
+		10: 15 * time.Minute,
+		12: time.Hour,
+		40: signInFailureMemory,
+	} {
+		if got := backoff(failures); got != want {
+			t.Errorf("expected %s after %d failures, got %s", want, failures, got)
+		}
+	}
+}
+
+func TestSignInThrottle_LocksOutAccountAndAddress(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("SIGN_IN_LOCKOUT_THRESHOLD", "4")
+	t.Setenv("SIGN_IN_IP_LOCKOUT_THRESHOLD", "8")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	admin := NewAdminServiceServer(svc)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	from := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}})
+	}
+	login := func(ctx context.Context, email, password string) string {
+		resp, _ := svc.GetToken(ctx, &authv1.GetTokenRequest{Email: email, Password: password, ClientId: "client-1"})
+		if resp.Success {
+			return "ok"
+		}
+		return resp.Message
+	}
+	// Lets the next attempt through without waiting out the backoff
+	skipBackoff := func() {
+		db.Model(&models.SignInThrottle{}).Where("1 = 1").Update("blocked_until", nil)
+	}
+
+	// Unknown emails are throttled exactly like existing ones
+	for email, ip := range map[string]string{"alice@example.com": "10.0.0.1", "nobody@example.com": "10.0.0.4"} {
+		for i := 1; i <= 3; i++ {
+			if got := login(from(ip), email, "wrong-password"); got != "Invalid credentials" {
+				t.Fatalf("expected failure %d for %s to be rejected as invalid, got %q", i, email, got)
+			}
+		}
+		if got := login(from("10.0.0.2"), email, "password123"); got != "Too many failed sign-in attempts, try again later" {
+			t.Fatalf("expected %s to be backed off from any address, got %q", email, got)
+		}
+	}
+
+	skipBackoff()
+	login(from("10.0.0.1"), "alice@example.com", "wrong-password")
+	user, _ := admin.GetUser(context.Background(), &authv1.GetUserRequest{UserId: "user-1"})
+	if user.SignInBlockedUntil == nil || time.Until(user.SignInBlockedUntil.AsTime()) < 14*time.Minute {
+		t.Fatalf("expected the threshold to lock sign-in out, got %v", user.SignInBlockedUntil)
+	}
+	var lockouts int64
+	db.Model(&models.SecurityEvent{}).Where("user_id = ? AND event_type = ?", "user-1", models.SecurityEventSignInLockout).Count(&lockouts)
+	if lockouts != 1 {
+		t.Fatalf("expected the lockout to be recorded, got %d events", lockouts)
+	}
+
+	if resp, _ := admin.UnlockSignIn(context.Background(), &authv1.AdminUserRequest{UserId: "user-1"}); !resp.Success {
+		t.Fatalf("expected the lockout to be lifted, got %v", resp)
+	}
+	if got := login(from("10.0.0.2"), "alice@example.com", "password123"); got != "ok" {
+		t.Fatalf("expected sign-in after the unlock, got %q", got)
+	}
+
+	// 10.0.0.1 has failed four times; spread over other accounts, the eighth
+	// failure locks the address out while other addresses are unaffected
+	for i := 0; i < 4; i++ {
+		skipBackoff()
+		login(from("10.0.0.1"), "mallory@example.com", "wrong-password")
+	}
+	if got := login(from("10.0.0.1"), "alice@example.com", "password123"); got != "Too many failed sign-in attempts, try again later" {
+		t.Fatalf("expected the address to be locked out, got %q", got)
+	}
+	if got := login(from("10.0.0.3"), "alice@example.com", "password123"); got != "ok" {
+		t.Fatalf("expected other addresses to sign in, got %q", got)
+	}
+}
+
+func TestSignInThrottle_EmailCaseSharesCounter(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	t.Setenv("SIGN_IN_LOCKOUT_THRESHOLD", "4")
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	// Each spelling comes from its own address so only the account counter adds up
+	for i, email := range []string{"Alice@example.com", "ALICE@EXAMPLE.COM", " alice@example.com "} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 1, byte(i+1)), Port: 4000}})
+		svc.GetToken(ctx, &authv1.GetTokenRequest{Email: email, Password: "wrong-password", ClientId: "client-1"})
+	}
+	resp, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: "password123", ClientId: "client-1"})
+	if resp.Message != "Too many failed sign-in attempts, try again later" {
+		t.Fatalf("expected failures under other spellings to back off the account, got %q", resp.Message)
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RFC 8693 grant and token type identifiers
+const (
+	tokenExchangeGrantType  = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeURIAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeURIJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+func (s *AuthServiceServerImpl) ExchangeToken(ctx context.Context, req *authv1.ExchangeTokenRequest) (*authv1.ExchangeTokenResponse, error) {
+	log.Printf("ExchangeToken request received from client: %s", req.ClientId)
+
+	if req.ClientId == "" || req.ClientSecret == "" || req.SubjectToken == "" {
+		return &authv1.ExchangeTokenResponse{Success: false, Message: "client_id, client_secret and subject_token are required"}, nil
+	}
+
+	client, err := s.repo.ValidateClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return &authv1.ExchangeTokenResponse{Success: false, Message: "Invalid client credentials"}, nil
+	}
+
+	resp, err := s.exchangeToken(ctx, client, req)
+	if err != nil {
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) {
+			return &authv1.ExchangeTokenResponse{Success: false, Message: oauthErr.Description}, nil
+		}
+		log.Printf("Error exchanging token: %v", err)
+		return &authv1.ExchangeTokenResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	return resp, nil
+}
+
+// exchangeToken issues a delegated token for the user behind the subject
+// token. The new token never carries more scope or audience than the subject
+// token, lives for at most TOKEN_EXCHANGE_TTL_MINUTES (default 5) and names
+// the actor in its act claim: the actor token's subject when one is given,
+// otherwise the requesting client. Every exchange is recorded as a
+// token_exchange security event before the token is returned.
+func (s *AuthServiceServerImpl) exchangeToken(ctx context.Context, client *models.Client, req *authv1.ExchangeTokenRequest) (*authv1.ExchangeTokenResponse, error) {
+	if !client.AllowTokenExchange {
+		return nil, newOAuthError("unauthorized_client", "client is not allowed to exchange tokens")
+	}
+	if !isExchangeableTokenType(req.SubjectTokenType) {
+		return nil, newOAuthError("invalid_request", "unsupported subject_token_type")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != tokenTypeURIAccessToken {
+		return nil, newOAuthError("invalid_request", "only access tokens can be requested")
+	}
+
+	subject, _ := s.validateAccessToken(ctx, req.SubjectToken)
+	if subject == nil {
+		return nil, newOAuthError("invalid_grant", "subject token is invalid")
+	}
+	if subject.IsClientPrincipal() {
+		return nil, newOAuthError("invalid_grant", "subject token must belong to a user")
+	}
+	session, err := s.sessionForClaims(ctx, subject)
+	if err != nil {
+		return nil, newOAuthError("invalid_grant", "subject token is invalid")
+	}
+
+	actor := &utils.ActorClaim{Subject: client.ClientID, ClientID: client.ClientID, Act: subject.Act}
+	if req.ActorToken != "" {
+		if req.ActorTokenType == "" || !isExchangeableTokenType(req.ActorTokenType) {
+			return nil, newOAuthError("invalid_request", "unsupported actor_token_type")
+		}
+		actorClaims, _ := s.validateAccessToken(ctx, req.ActorToken)
+		if actorClaims == nil {
+			return nil, newOAuthError("invalid_grant", "actor token is invalid")
+		}
+		actor.Subject = actorClaims.Subject
+		actor.ClientID = actorClaims.ClientID
+	}
+
+	scope, err := resolveExchangeScope(client, subject.Scope, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	audience, err := resolveExchangeAudience(client, subject.Audience, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never outlive the subject token
+	ttl := time.Duration(envInt("TOKEN_EXCHANGE_TTL_MINUTES", 5)) * time.Minute
+	if remaining := time.Until(subject.ExpiresAt.Time); remaining < ttl {
+		ttl = remaining
+	}
+
+	tokenID := utils.GenerateUUID()
+	accessToken, expiresAt, err := utils.GenerateExchangedToken(utils.ExchangedTokenParams{
+		TokenID:        tokenID,
+		UserID:         subject.UserID,
+		Username:       subject.Username,
+		ClientID:       subject.ClientID,
+		SessionID:      session.SessionID,
+		Scope:          scope,
+		Audience:       audience,
+		Actor:          actor,
+		TTL:            ttl,
+		OrganizationID: subject.OrganizationID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generating exchanged token: %w", err)
+	}
+
+	// The audit record is mandatory: no record, no token
+	event := &models.SecurityEvent{
+		EventID:   utils.GenerateUUID(),
+		EventType: models.SecurityEventTokenExchange,
+		UserID:    subject.UserID,
+		ClientID:  client.ClientID,
+		Details: fmt.Sprintf("token %s issued to client %s acting as %s (client %s); scope %q; audience %q; expires %s; ip %s",
+			tokenID, client.ClientID, actor.Subject, actor.ClientID, scope, strings.Join(audience, " "),
+			expiresAt.UTC().Format(time.RFC3339), clientIP(ctx)),
+	}
+	if err := s.repo.CreateSecurityEvent(ctx, event); err != nil {
+		return nil, fmt.Errorf("recording token exchange: %w", err)
+	}
+
+	log.Printf("Token exchanged for user %s by client %s (actor %s)", subject.UserID, client.ClientID, actor.Subject)
+	return &authv1.ExchangeTokenResponse{
+		Success:         true,
+		Message:         "Token exchanged successfully",
+		AccessToken:     accessToken,
+		IssuedTokenType: tokenTypeURIAccessToken,
+		ExpiresAt:       timestamppb.New(expiresAt),
+		Scope:           scope,
+		Audience:        audience,
+	}, nil
+}
+
+// isExchangeableTokenType accepts the token types our access tokens can be
+// presented as; empty means access_token
+func isExchangeableTokenType(tokenType string) bool {
+	return tokenType == "" || tokenType == tokenTypeURIAccessToken || tokenType == tokenTypeURIJWT
+}
+
+// resolveExchangeScope narrows the subject token's scope to the requested
+// scopes. Unscoped user tokens are unrestricted, so a request against one is
+// checked against the client's allowed scopes instead.
+func resolveExchangeScope(client *models.Client, subjectScope, requestedScope string) (string, error) {
+	requested := strings.Fields(requestedScope)
+	if len(requested) == 0 {
+		return subjectScope, nil
+	}
+
+	granted := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		allowed := hasScope(subjectScope, scope)
+		if subjectScope == "" {
+			allowed = client.AllowsScope(scope)
+		}
+		if !allowed {
+			return "", newOAuthError("invalid_scope", "scope exceeds the subject token: "+scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// resolveExchangeAudience narrows the subject token's audience; a subject
+// token without an audience may be narrowed to the client's allowed audiences
+func resolveExchangeAudience(client *models.Client, subjectAudience []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return subjectAudience, nil
+	}
+	available := subjectAudience
+	if len(available) == 0 {
+		available = client.AllowedAudienceList()
+	}
+
+	granted := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, audience := range requested {
+		if strings.TrimSpace(audience) == "" {
+			return nil, newOAuthError("invalid_target", "audience must not be empty")
+		}
+		if !containsString(available, audience) {
+			return nil, newOAuthError("invalid_target", "audience is not allowed: "+audience)
+		}
+		if !seen[audience] {
+			seen[audience] = true
+			granted = append(granted, audience)
+		}
+	}
+	return granted, nil
+}
+
+// actorSubject is the immediate actor of an exchanged token, empty otherwise
+func actorSubject(claims *utils.Claims) string {
+	if claims.Act == nil {
+		return ""
+	}
+	return claims.Act.Subject
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AuthServiceServerImpl) tokenFromTokenExchange(ctx context.Context, r *http.Request, client *tokenClient) (*tokenEndpointResponse, error) {
+	if !client.Authenticated {
+		return nil, newOAuthError("invalid_client", "token exchange requires client authentication")
+	}
+	if r.PostForm.Get("subject_token") == "" || r.PostForm.Get("subject_token_type") == "" {
+		return nil, newOAuthError("invalid_request", "subject_token and subject_token_type are required")
+	}
+
+	resp, err := s.exchangeToken(ctx, client.Client, &authv1.ExchangeTokenRequest{
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		Scope:              r.PostForm.Get("scope"),
+		Audience:           r.PostForm["audience"],
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenResp := newTokenEndpointResponse(resp.AccessToken, resp.ExpiresAt, "", resp.Scope)
+	tokenResp.IssuedTokenType = resp.IssuedTokenType
+	return tokenResp, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+)
+
+func TestExchangeToken_DelegatesWithActClaim(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "gateway")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("allowed_audiences", "orders-api\nbilling-api")
+	db.Model(&models.Client{}).Where("client_id = ?", "gateway").Updates(map[string]interface{}{
+		"allow_token_exchange": true,
+		"allowed_scopes":       "orders:read orders:write",
+	})
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+
+	resp, err := svc.ExchangeToken(context.Background(), &authv1.ExchangeTokenRequest{
+		ClientId:     "gateway",
+		ClientSecret: "secret",
+		SubjectToken: login.AccessToken,
+		Scope:        "orders:read",
+		Audience:     []string{"orders-api"},
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected exchange to succeed, got err=%v resp=%v", err, resp)
+	}
+	if resp.IssuedTokenType != tokenTypeURIAccessToken || resp.Scope != "orders:read" {
+		t.Fatalf("unexpected exchange response: %v", resp)
+	}
+	if time.Until(resp.ExpiresAt.AsTime()) > 5*time.Minute {
+		t.Fatalf("expected a short-lived token, expires at %v", resp.ExpiresAt.AsTime())
+	}
+
+	claims, err := utils.ValidateJWTToken(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("expected exchanged token to verify: %v", err)
+	}
+	if claims.SessionID != login.SessionId {
+		t.Fatalf("expected the token to reference the subject's session")
+	}
+	if claims.Act == nil || claims.Act.Subject != "gateway" || len(claims.Audience) != 1 || claims.Audience[0] != "orders-api" {
+		t.Fatalf("unexpected exchanged claims: %+v", claims)
+	}
+
+	validate, _ := svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: resp.AccessToken})
+	if !validate.Valid || validate.UserId != "user-1" || validate.Actor != "gateway" || validate.Scope != "orders:read" {
+		t.Fatalf("expected exchanged token to validate as delegated, got %v", validate)
+	}
+
+	// A token cannot be widened by exchanging it again
+	again, _ := svc.ExchangeToken(context.Background(), &authv1.ExchangeTokenRequest{
+		ClientId:     "gateway",
+		ClientSecret: "secret",
+		SubjectToken: resp.AccessToken,
+		Scope:        "orders:write",
+	})
+	if again.Success {
+		t.Fatalf("expected scope widening to be rejected")
+	}
+	again, _ = svc.ExchangeToken(context.Background(), &authv1.ExchangeTokenRequest{
+		ClientId:     "gateway",
+		ClientSecret: "secret",
+		SubjectToken: resp.AccessToken,
+		Audience:     []string{"billing-api"},
+	})
+	if again.Success {
+		t.Fatalf("expected audience widening to be rejected")
+	}
+
+	// Delegated tokens cannot manage the account
+	list, _ := svc.ListSessions(context.Background(), &authv1.ListSessionsRequest{AccessToken: resp.AccessToken})
+	if list.Success {
+		t.Fatalf("expected ListSessions to reject a delegated token")
+	}
+
+	var events []models.SecurityEvent
+	db.Where("event_type = ?", models.SecurityEventTokenExchange).Find(&events)
+	if len(events) != 1 || events[0].UserID != "user-1" || events[0].ClientID != "gateway" || !strings.Contains(events[0].Details, claims.ID) {
+		t.Fatalf("expected the exchange to be audited, got %+v", events)
+	}
+
+	// Ending the subject's session ends the delegated token too
+	svc.RevokeToken(context.Background(), &authv1.RevokeTokenRequest{RefreshToken: login.RefreshToken})
+	validate, _ = svc.ValidateToken(context.Background(), &authv1.ValidateTokenRequest{AccessToken: resp.AccessToken})
+	if validate.Valid {
+		t.Fatalf("expected delegated token to be invalid after logout")
+	}
+}
+
+func TestExchangeToken_ActorTokenAndClientPermission(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	seedClient(t, db, "support-console")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	seedUser(t, db, "agent-1", "support-console", "agent@example.com", "agent", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	agent := loginAs(t, svc, "agent@example.com", "password123", "support-console", "desk")
+
+	req := &authv1.ExchangeTokenRequest{
+		ClientId:       "support-console",
+		ClientSecret:   "secret",
+		SubjectToken:   login.AccessToken,
+		ActorToken:     agent.AccessToken,
+		ActorTokenType: tokenTypeURIAccessToken,
+	}
+	resp, _ := svc.ExchangeToken(context.Background(), req)
+	if resp.Success {
+		t.Fatalf("expected exchange to require allow_token_exchange")
+	}
+
+	db.Model(&models.Client{}).Where("client_id = ?", "support-console").Update("allow_token_exchange", true)
+	resp, _ = svc.ExchangeToken(context.Background(), req)
+	if !resp.Success {
+		t.Fatalf("expected exchange to succeed, got %v", resp)
+	}
+	claims, _ := utils.ValidateJWTToken(resp.AccessToken)
+	if claims.Act == nil || claims.Act.Subject != "agent-1" || claims.Act.ClientID != "support-console" {
+		t.Fatalf("expected the agent as actor, got %+v", claims.Act)
+	}
+
+	req.ActorTokenType = ""
+	resp, _ = svc.ExchangeToken(context.Background(), req)
+	if resp.Success {
+		t.Fatalf("expected actor_token without actor_token_type to be rejected")
+	}
+}
+
+func TestTokenEndpoint_TokenExchangeGrant(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	seedClient(t, db, "client-1")
+	db.Model(&models.Client{}).Where("client_id = ?", "client-1").Updates(map[string]interface{}{
+		"allow_token_exchange": true,
+		"allowed_audiences":    "orders-api",
+	})
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+	login := loginAs(t, svc, "alice@example.com", "password123", "client-1", "laptop")
+	handler := NewHTTPHandler(svc)
+
+	rec := postToken(handler, url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"client_id":          {"client-1"},
+		"client_secret":      {"secret"},
+		"subject_token":      {login.AccessToken},
+		"subject_token_type": {tokenTypeURIAccessToken},
+		"audience":           {"orders-api"},
+	})
+	var body map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || body["issued_token_type"] != tokenTypeURIAccessToken || body["access_token"] == "" {
+		t.Fatalf("expected token exchange response, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := body["refresh_token"]; ok {
+		t.Fatalf("exchanged tokens must not come with a refresh token")
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"authservice/pkg/mailer"
+	"authservice/pkg/models"
+	"authservice/pkg/repository"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// verificationRequestedMessage is the same whether or not an email was sent,
+// so the response cannot be used to probe for accounts
+const verificationRequestedMessage = "If the address belongs to an unverified account, a verification email has been sent"
+
+func (s *AuthServiceServerImpl) SendVerificationEmail(ctx context.Context, req *authv1.SendVerificationEmailRequest) (*authv1.SendVerificationEmailResponse, error) {
+	log.Printf("SendVerificationEmail request received for email: %s", req.Email)
+
+	if req.Email == "" || req.ClientId == "" {
+		return &authv1.SendVerificationEmailResponse{Success: false, Message: "Email and client ID are required"}, nil
+	}
+	client, err := s.repo.GetClientByID(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &authv1.SendVerificationEmailResponse{Success: false, Message: "Invalid client ID"}, nil
+		}
+		log.Printf("Error checking client existence: %v", err)
+		return &authv1.SendVerificationEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, client.IdentityPool(), req.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error getting user by email: %v", err)
+			return &authv1.SendVerificationEmailResponse{Success: false, Message: "Internal server error"}, nil
+		}
+		log.Printf("Verification email not sent: no user %s in pool %s", req.Email, client.IdentityPool())
+		return &authv1.SendVerificationEmailResponse{Success: true, Message: verificationRequestedMessage}, nil
+	}
+	if user.EmailVerified {
+		log.Printf("Verification email not sent: user %s is already verified", user.UserID)
+		return &authv1.SendVerificationEmailResponse{Success: true, Message: verificationRequestedMessage}, nil
+	}
+
+	if err := s.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		return &authv1.SendVerificationEmailResponse{Success: false, Message: "Failed to send verification email"}, nil
+	}
+	return &authv1.SendVerificationEmailResponse{Success: true, Message: verificationRequestedMessage}, nil
+}
+
+func (s *AuthServiceServerImpl) VerifyEmail(ctx context.Context, req *authv1.VerifyEmailRequest) (*authv1.VerifyEmailResponse, error) {
+	log.Printf("VerifyEmail request received")
+
+	if req.Token == "" {
+		return &authv1.VerifyEmailResponse{Success: false, Message: "Token is required"}, nil
+	}
+
+	userID, err := s.repo.VerifyUserEmail(ctx, req.Token)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserTokenInvalid) {
+			return &authv1.VerifyEmailResponse{Success: false, Message: "Invalid or expired token"}, nil
+		}
+		log.Printf("Error verifying email: %v", err)
+		return &authv1.VerifyEmailResponse{Success: false, Message: "Internal server error"}, nil
+	}
+
+	log.Printf("Email verified for user: %s", userID)
+	return &authv1.VerifyEmailResponse{Success: true, Message: "Email verified successfully", UserId: userID}, nil
+}
+
+// sendVerificationEmail mails the user a new verification token for their
+// current address, invalidating any sent before
+func (s *AuthServiceServerImpl) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	ttl := time.Duration(envInt("EMAIL_VERIFICATION_TTL_HOURS", 24)) * time.Hour
+	return s.mailUserToken(ctx, user, models.UserTokenEmailVerification, ttl, verificationMessage)
+}
+
+// mailUserToken stores a new token of the purpose bound to the user's current
+// address, replacing unused ones, and mails it in the message built by compose
+func (s *AuthServiceServerImpl) mailUserToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration,
+	compose func(user *models.User, token string, expiresAt time.Time) mailer.Message) error {
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	userToken := &models.UserToken{
+		TokenHash: utils.HashToken(token),
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.repo.CreateUserToken(ctx, userToken); err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, compose(user, token, userToken.ExpiresAt))
+}
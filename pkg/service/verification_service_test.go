@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"authservice/pkg/models"
+	"authservice/pkg/utils"
+	authv1 "authservice/proto/auth/v1"
+)
+
+var verificationTokenPattern = regexp.MustCompile(`verification token: ([0-9a-f]+)`)
+
+// mailedToken extracts the token from the most recent message sent to the address
+func mailedToken(t *testing.T, mail *captureMailer, to string, pattern *regexp.Regexp) string {
+	t.Helper()
+	for i := len(mail.sent) - 1; i >= 0; i-- {
+		if mail.sent[i].To != to {
+			continue
+		}
+		if match := pattern.FindStringSubmatch(mail.sent[i].Body); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatalf("expected a token mailed to %s, got %v", to, mail.sent)
+	return ""
+}
+
+func TestEmailVerification_RequiredBeforeLogin(t *testing.T) {
+	cleanup := withJWTSecret(t)
+	defer cleanup()
+	withRSASigningKey(t)
+
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	if err := db.Model(&models.Client{}).Where("client_id = ?", "client-1").Update("require_email_verification", true).Error; err != nil {
+		t.Fatalf("failed to require verification: %v", err)
+	}
+
+	registered, _ := svc.RegisterUser(context.Background(), &authv1.RegisterUserRequest{
+		Username: "alice", Email: "alice@example.com", Password: "password123", ClientId: "client-1",
+	})
+	if !registered.Success {
+		t.Fatalf("expected registration to succeed, got %v", registered)
+	}
+	token := mailedToken(t, mail, "alice@example.com", verificationTokenPattern)
+
+	login := func(password string) *authv1.GetTokenResponse {
+		resp, _ := svc.GetToken(context.Background(), &authv1.GetTokenRequest{Email: "alice@example.com", Password: password, ClientId: "client-1"})
+		return resp
+	}
+	if resp := login("wrong-password"); resp.Success || resp.Message != "Invalid credentials" {
+		t.Fatalf("expected a wrong password not to reveal the verification state, got %v", resp)
+	}
+	if resp := login("password123"); resp.Success || resp.Message != "Email address not verified" {
+		t.Fatalf("expected login to wait for verification, got %v", resp)
+	}
+
+	status := func() string {
+		var user models.User
+		db.Where("user_id = ?", registered.UserId).First(&user)
+		return user.Status
+	}
+	if got := status(); got != models.UserStatusPendingVerification {
+		t.Fatalf("expected the user to await verification, got %q", got)
+	}
+
+	verified, _ := svc.VerifyEmail(context.Background(), &authv1.VerifyEmailRequest{Token: token})
+	if !verified.Success || verified.UserId != registered.UserId {
+		t.Fatalf("expected the token to verify the user, got %v", verified)
+	}
+	if got := status(); got != models.UserStatusActive {
+		t.Fatalf("expected verification to activate the user, got %q", got)
+	}
+	if again, _ := svc.VerifyEmail(context.Background(), &authv1.VerifyEmailRequest{Token: token}); again.Success {
+		t.Fatalf("expected the verification token to be single-use")
+	}
+
+	resp := login("password123")
+	if !resp.Success {
+		t.Fatalf("expected login after verification, got %v", resp)
+	}
+	idClaims := &utils.IDTokenClaims{}
+	if err := utils.ParseSignedClaims(resp.IdToken, idClaims); err != nil || !idClaims.EmailVerified {
+		t.Fatalf("expected email_verified in the ID token, got %+v (%v)", idClaims, err)
+	}
+}
+
+func TestSendVerificationEmail_DoesNotRevealAccounts(t *testing.T) {
+	db := newTestDB(t)
+	svc := NewAuthServiceServer(db)
+	mail := &captureMailer{}
+	svc.SetMailer(mail)
+	seedClient(t, db, "client-1")
+	seedUser(t, db, "user-1", "client-1", "alice@example.com", "alice", "password123")
+
+	unknown, _ := svc.SendVerificationEmail(context.Background(), &authv1.SendVerificationEmailRequest{Email: "nobody@example.com", ClientId: "client-1"})
+	known, _ := svc.SendVerificationEmail(context.Background(), &authv1.SendVerificationEmailRequest{Email: "alice@example.com", ClientId: "client-1"})
+	if !unknown.Success || unknown.Message != known.Message {
+		t.Fatalf("expected identical responses, got %v and %v", unknown, known)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != "alice@example.com" {
+		t.Fatalf("expected a single email to the known user, got %v", mail.sent)
+	}
+	first := mailedToken(t, mail, "alice@example.com", verificationTokenPattern)
+
+	// Asking again replaces the earlier token
+	svc.SendVerificationEmail(context.Background(), &authv1.SendVerificationEmailRequest{Email: "alice@example.com", ClientId: "client-1"})
+	second := mailedToken(t, mail, "alice@example.com", verificationTokenPattern)
+	if resp, _ := svc.VerifyEmail(context.Background(), &authv1.VerifyEmailRequest{Token: first}); resp.Success {
+		t.Fatalf("expected the superseded token to be rejected")
+	}
+	if resp, _ := svc.VerifyEmail(context.Background(), &authv1.VerifyEmailRequest{Token: second}); !resp.Success {
+		t.Fatalf("expected the latest token to verify, got %v", resp)
+	}
+	if verifiedAgain, _ := svc.SendVerificationEmail(context.Background(), &authv1.SendVerificationEmailRequest{Email: "alice@example.com", ClientId: "client-1"}); !verifiedAgain.Success || len(mail.sent) != 2 {
+		t.Fatalf("expected no email for a verified user, got %v", mail.sent)
+	}
+}
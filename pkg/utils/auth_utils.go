@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -12,14 +15,46 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Principal types carried in the principal_type claim
+const (
+	PrincipalTypeUser   = "user"   // end user; tokens issued before this claim existed have it empty
+	PrincipalTypeClient = "client" // client acting on its own behalf (client credentials grant)
+)
+
 type Claims struct {
-	UserID       string `json:"user_id"`
-	Username     string `json:"username"`
-	ClientID     string `json:"client_id"`
-	RefreshToken string `json:"refresh_token"`
+	UserID        string `json:"user_id,omitempty"`
+	Username      string `json:"username,omitempty"`
+	ClientID      string `json:"client_id"`
+	PrincipalType string `json:"principal_type,omitempty"`
+	Scope         string `json:"scope,omitempty"`
+	// Roles lists the user's globally assigned roles when EMBED_ROLE_CLAIMS is set
+	Roles []string `json:"roles,omitempty"`
+	// OrganizationID is the organization (tenant) the user belongs to, if any
+	OrganizationID string `json:"org_id,omitempty"`
+	// CustomAttributes is the user's client-defined JSON object, for clients
+	// that embed it
+	CustomAttributes json.RawMessage `json:"custom_attributes,omitempty"`
+	// SessionID binds user tokens to the session they were issued for, so
+	// revoking the session revokes them without the token carrying its
+	// refresh token
+	SessionID string      `json:"sid,omitempty"`
+	Act       *ActorClaim `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ActorClaim is the RFC 8693 act claim naming the party acting on the
+// subject's behalf; Act nests the actors of earlier exchanges in the chain
+type ActorClaim struct {
+	Subject  string      `json:"sub"`
+	ClientID string      `json:"client_id,omitempty"`
+	Act      *ActorClaim `json:"act,omitempty"`
+}
+
+// IsClientPrincipal reports whether the token was issued to a client rather than a user
+func (c *Claims) IsClientPrincipal() bool {
+	return c.PrincipalType == PrincipalTypeClient
+}
+
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -33,29 +68,50 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GenerateJWTToken(userID, username, clientID, refreshToken string) (string, time.Time, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", time.Time{}, fmt.Errorf("JWT_SECRET not found in environment variables")
+func GenerateJWTToken(userID, username, clientID, sessionID string) (string, time.Time, error) {
+	return GenerateScopedJWTToken(userID, username, clientID, sessionID, TokenGrant{})
+}
+
+// TokenGrant is what a user access token grants beyond its subject
+type TokenGrant struct {
+	Scope    string   // empty leaves the token unscoped
+	Audience []string // empty leaves the token usable by any API
+	Roles    []string // optional role claims
+	// OrganizationID is the user's organization; empty when the user has none
+	OrganizationID string
+	// CustomAttributes is a JSON object; nil leaves the claim out
+	CustomAttributes json.RawMessage
+}
+
+// GenerateScopedJWTToken issues a user access token for a session, carrying the grant
+func GenerateScopedJWTToken(userID, username, clientID, sessionID string, grant TokenGrant) (string, time.Time, error) {
+	signingKey, err := currentKeyProvider().SigningKey()
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
 	expirationTime := time.Now().Add(24 * time.Hour) // 24 hours
 	claims := &Claims{
-		UserID:       userID,
-		Username:     username,
-		ClientID:     clientID,
-		RefreshToken: refreshToken,
+		UserID:           userID,
+		Username:         username,
+		ClientID:         clientID,
+		SessionID:        sessionID,
+		PrincipalType:    PrincipalTypeUser,
+		Scope:            grant.Scope,
+		Roles:            grant.Roles,
+		OrganizationID:   grant.OrganizationID,
+		CustomAttributes: grant.CustomAttributes,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  grant.Audience,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "auth-service",
+			Issuer:    Issuer(),
 			Subject:   userID,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	tokenString, err := SignClaims(signingKey, claims)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -63,26 +119,111 @@ func GenerateJWTToken(userID, username, clientID, refreshToken string) (string,
 	return tokenString, expirationTime, nil
 }
 
-func ValidateJWTToken(tokenString string) (*Claims, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, fmt.Errorf("JWT_SECRET not found in environment variables")
+// GenerateClientToken issues an access token whose subject is the client
+// itself. There is no refresh token; clients simply request a new one.
+func GenerateClientToken(clientID, scope string, audience []string, ttl time.Duration) (string, time.Time, error) {
+	signingKey, err := currentKeyProvider().SigningKey()
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
+	expirationTime := time.Now().Add(ttl)
+	claims := &Claims{
+		ClientID:      clientID,
+		PrincipalType: PrincipalTypeClient,
+		Scope:         scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer(),
+			Subject:   clientID,
+		},
+	}
 
+	tokenString, err := SignClaims(signingKey, claims)
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
 	}
 
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+	return tokenString, expirationTime, nil
+}
+
+// ExchangedTokenParams describes a token issued by RFC 8693 token exchange
+type ExchangedTokenParams struct {
+	TokenID   string
+	UserID    string
+	Username  string
+	ClientID  string
+	SessionID string
+	Scope     string
+	Audience  []string
+	Actor     *ActorClaim
+	TTL       time.Duration
+	// OrganizationID carries the subject token's org_id over
+	OrganizationID string
+}
+
+// GenerateExchangedToken issues a delegated user access token. It is bound to
+// the subject's session by ID, so revoking that session revokes it too.
+func GenerateExchangedToken(params ExchangedTokenParams) (string, time.Time, error) {
+	signingKey, err := currentKeyProvider().SigningKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expirationTime := time.Now().Add(params.TTL)
+	claims := &Claims{
+		UserID:         params.UserID,
+		Username:       params.Username,
+		ClientID:       params.ClientID,
+		PrincipalType:  PrincipalTypeUser,
+		Scope:          params.Scope,
+		SessionID:      params.SessionID,
+		Act:            params.Actor,
+		OrganizationID: params.OrganizationID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        params.TokenID,
+			Audience:  params.Audience,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer(),
+			Subject:   params.UserID,
+		},
+	}
+
+	tokenString, err := SignClaims(signingKey, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expirationTime, nil
+}
+
+// SignClaims signs the claims with the given key and sets the kid header
+func SignClaims(signingKey *SigningKey, claims jwt.Claims) (string, error) {
+	method, err := signingKey.SigningMethod()
+	if err != nil {
+		return "", err
+	}
+	material, err := signingKey.signingMaterial()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	if signingKey.KeyID != "" {
+		token.Header["kid"] = signingKey.KeyID
+	}
+	return token.SignedString(material)
+}
+
+func ValidateJWTToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := ParseSignedClaims(tokenString, claims); err != nil {
+		return nil, err
 	}
 
 	// JWT is valid if it has:
@@ -94,6 +235,34 @@ func ValidateJWTToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// ParseSignedClaims verifies the token signature against the key named by its
+// kid header and decodes it into claims
+func ParseSignedClaims(tokenString string, claims jwt.Claims) error {
+	provider := currentKeyProvider()
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := provider.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// Reject algorithm substitution, e.g. HS256 signed with a public key
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verificationMaterial()
+	}, jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}))
+
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
+
 func GenerateRefreshToken() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -102,6 +271,31 @@ func GenerateRefreshToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// HashToken returns the keyed hash under which refresh tokens and client
+// secrets are stored: HMAC-SHA256 with the TOKEN_HASH_PEPPER server secret,
+// which CheckTokenHashPepper requires at startup. Changing the pepper
+// invalidates every stored token and secret.
+func HashToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("TOKEN_HASH_PEPPER")))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckTokenHashPepper fails when TOKEN_HASH_PEPPER is unset. Without the
+// pepper a leaked database would expose caller-chosen client secrets to an
+// offline guessing attack, so the server refuses to start.
+func CheckTokenHashPepper() error {
+	if os.Getenv("TOKEN_HASH_PEPPER") == "" {
+		return fmt.Errorf("TOKEN_HASH_PEPPER not found in environment variables")
+	}
+	return nil
+}
+
+// TokenMatchesHash reports in constant time whether token hashes to storedHash
+func TokenMatchesHash(token, storedHash string) bool {
+	return hmac.Equal([]byte(HashToken(token)), []byte(storedHash))
+}
+
 func GenerateUUID() string {
 	return uuid.NewString()
 }
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultIssuer is used for the iss claim when neither JWT_ISSUER nor
+// PUBLIC_BASE_URL is configured. Discovery is not served then, so it never
+// has to match a discovery URL.
+const defaultIssuer = "auth-service"
+
+// Issuer returns the iss claim of every token we sign. OIDC relying parties
+// require it to be the https URL the discovery document is served under, so
+// it defaults to PUBLIC_BASE_URL when JWT_ISSUER is unset.
+func Issuer() string {
+	if issuer := strings.TrimSpace(os.Getenv("JWT_ISSUER")); issuer != "" {
+		return issuer
+	}
+	if baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("PUBLIC_BASE_URL")), "/"); baseURL != "" {
+		return baseURL
+	}
+	return defaultIssuer
+}
+
+// ErrSymmetricSigningKey is returned when an ID token would be signed with an
+// HS256 secret: relying parties verify ID tokens against the JWKS, which never
+// publishes the secret, so they could not check the signature
+var ErrSymmetricSigningKey = errors.New("ID tokens require an asymmetric signing key")
+
+// IDTokenClaims is an OpenID Connect ID token (OIDC Core section 2)
+type IDTokenClaims struct {
+	Nonce             string           `json:"nonce,omitempty"`
+	AuthTime          *jwt.NumericDate `json:"auth_time,omitempty"`
+	AtHash            string           `json:"at_hash,omitempty"`
+	PreferredUsername string           `json:"preferred_username,omitempty"`
+	Email             string           `json:"email,omitempty"`
+	EmailVerified     bool             `json:"email_verified"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenParams describes the authentication an ID token asserts
+type IDTokenParams struct {
+	UserID        string
+	Username      string
+	Email         string
+	EmailVerified bool
+	ClientID      string // audience
+	Nonce         string
+	AuthTime      time.Time
+	AccessToken   string // issued alongside, for at_hash
+	TTL           time.Duration
+}
+
+// GenerateIDToken signs an ID token for the user with the current signing
+// key, which must be asymmetric
+func GenerateIDToken(params IDTokenParams) (string, error) {
+	signingKey, err := currentKeyProvider().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	if signingKey.Algorithm == AlgorithmHS256 {
+		return "", ErrSymmetricSigningKey
+	}
+
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce:             params.Nonce,
+		AuthTime:          jwt.NewNumericDate(params.AuthTime),
+		PreferredUsername: params.Username,
+		Email:             params.Email,
+		EmailVerified:     params.EmailVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(),
+			Subject:   params.UserID,
+			Audience:  jwt.ClaimStrings{params.ClientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(params.TTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if params.AccessToken != "" {
+		claims.AtHash = AccessTokenHash(params.AccessToken, signingKey.Algorithm)
+	}
+
+	return SignClaims(signingKey, claims)
+}
+
+// AccessTokenHash computes at_hash: the base64url encoded left half of the
+// access token's hash, using the hash of the ID token's signing algorithm
+// (OIDC Core section 3.1.3.6). EdDSA uses SHA-512, as for Ed25519.
+func AccessTokenHash(accessToken, algorithm string) string {
+	var h hash.Hash
+	if algorithm == AlgorithmEdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// SigningAlgorithm returns the algorithm of the current signing key, as
+// advertised in the discovery document
+func SigningAlgorithm() (string, error) {
+	signingKey, err := currentKeyProvider().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	return signingKey.Algorithm, nil
+}
@@ -0,0 +1,523 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey is a key used to sign and/or verify JWTs, identified by its kid.
+// HS256 keys carry a shared Secret; asymmetric keys carry a Public key and,
+// when they may still sign, a Private key.
+type SigningKey struct {
+	KeyID     string
+	Algorithm string
+	Secret    []byte
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+}
+
+// KeyProvider supplies the keys used by GenerateJWTToken and ValidateJWTToken.
+type KeyProvider interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (*SigningKey, error)
+	// VerificationKey returns the key for the given kid (kid may be empty for legacy tokens)
+	VerificationKey(kid string) (*SigningKey, error)
+	// PublicKeys returns every asymmetric key that may still verify tokens
+	PublicKeys() ([]*SigningKey, error)
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider = &EnvKeyProvider{}
+)
+
+// SetKeyProvider replaces the provider used for signing and verifying tokens.
+// Passing nil restores the environment based provider.
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	if provider == nil {
+		provider = &EnvKeyProvider{}
+	}
+	keyProvider = provider
+}
+
+func currentKeyProvider() KeyProvider {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	return keyProvider
+}
+
+// SigningMethod returns the jwt signing method matching the key's algorithm
+func (k *SigningKey) SigningMethod() (jwt.SigningMethod, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", k.Algorithm)
+	}
+}
+
+func (k *SigningKey) signingMaterial() (interface{}, error) {
+	if k.Algorithm == AlgorithmHS256 {
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("signing key %q has no secret", k.KeyID)
+		}
+		return k.Secret, nil
+	}
+	if k.Private == nil {
+		return nil, fmt.Errorf("signing key %q cannot sign", k.KeyID)
+	}
+	return k.Private, nil
+}
+
+func (k *SigningKey) verificationMaterial() (interface{}, error) {
+	if k.Algorithm == AlgorithmHS256 {
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("signing key %q has no secret", k.KeyID)
+		}
+		return k.Secret, nil
+	}
+	if k.Public == nil {
+		return nil, fmt.Errorf("signing key %q has no public key", k.KeyID)
+	}
+	return k.Public, nil
+}
+
+// NewAsymmetricSigningKey builds a signing key from a PEM encoded private key.
+// When keyID is empty the RFC 7638 thumbprint of the public key is used.
+func NewAsymmetricSigningKey(algorithm, keyID, privateKeyPEM string) (*SigningKey, error) {
+	private, err := ParsePrivateKeyPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		KeyID:     keyID,
+		Algorithm: algorithm,
+		Private:   private,
+		Public:    private.Public(),
+	}
+	if err := key.checkKeyType(); err != nil {
+		return nil, err
+	}
+
+	if key.KeyID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.KeyID = thumbprint
+	}
+	return key, nil
+}
+
+// NewVerificationKey builds a verify-only key from a PEM encoded public key
+func NewVerificationKey(algorithm, keyID, publicKeyPEM string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode public key PEM")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	key := &SigningKey{KeyID: keyID, Algorithm: algorithm, Public: public}
+	if err := key.checkKeyType(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *SigningKey) checkKeyType() error {
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		if k.Algorithm != AlgorithmRS256 {
+			return fmt.Errorf("RSA key cannot be used with %s", k.Algorithm)
+		}
+		if public.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key must be at least 2048 bits")
+		}
+	case *ecdsa.PublicKey:
+		if k.Algorithm != AlgorithmES256 {
+			return fmt.Errorf("EC key cannot be used with %s", k.Algorithm)
+		}
+		if public.Curve != elliptic.P256() {
+			return fmt.Errorf("ES256 requires a P-256 key")
+		}
+	case ed25519.PublicKey:
+		if k.Algorithm != AlgorithmEdDSA {
+			return fmt.Errorf("Ed25519 key cannot be used with %s", k.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", k.Public)
+	}
+	return nil
+}
+
+// GenerateSigningKey creates a fresh key for the given algorithm. Asymmetric
+// keys are identified by their thumbprint, HS256 keys by a random kid.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		kid := make([]byte, 16)
+		if _, err := rand.Read(kid); err != nil {
+			return nil, err
+		}
+		return &SigningKey{KeyID: hex.EncodeToString(kid), Algorithm: algorithm, Secret: secret}, nil
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{Algorithm: algorithm, Private: private, Public: private.Public()}
+	if key.KeyID, err = key.Thumbprint(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// MarshalPrivateKey serializes the key's private material for storage: a
+// PKCS#8 PEM block for asymmetric keys, base64 for HS256 secrets
+func (k *SigningKey) MarshalPrivateKey() (string, error) {
+	if k.Algorithm == AlgorithmHS256 {
+		return base64.StdEncoding.EncodeToString(k.Secret), nil
+	}
+	if k.Private == nil {
+		return "", fmt.Errorf("signing key %q has no private key", k.KeyID)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key
+func ParsePrivateKeyPEM(privateKeyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key PEM")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format")
+}
+
+// sealedKeyPrefix marks private key material encrypted with
+// SIGNING_KEY_ENCRYPTION_KEY; material without it is stored in plaintext
+const sealedKeyPrefix = "aes256gcm:"
+
+// signingKeyCipher returns the AES-256-GCM cipher keyed with
+// SIGNING_KEY_ENCRYPTION_KEY, a base64 encoded 32 byte key, or nil when the
+// setting is empty
+func signingKeyCipher() (cipher.AEAD, error) {
+	encoded := strings.TrimSpace(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("SIGNING_KEY_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SigningKeyEncryptionEnabled reports whether private signing keys are
+// encrypted at rest, failing when SIGNING_KEY_ENCRYPTION_KEY is malformed
+func SigningKeyEncryptionEnabled() (bool, error) {
+	aead, err := signingKeyCipher()
+	return aead != nil, err
+}
+
+// IsSealedPrivateKey reports whether stored private key material is encrypted
+func IsSealedPrivateKey(stored string) bool {
+	return strings.HasPrefix(stored, sealedKeyPrefix)
+}
+
+// SealPrivateKey encrypts the output of MarshalPrivateKey for storage. The
+// ciphertext is bound to keyID, so it cannot be moved to another key's row.
+// Without SIGNING_KEY_ENCRYPTION_KEY the material is returned unchanged.
+func SealPrivateKey(keyID, privateKey string) (string, error) {
+	aead, err := signingKeyCipher()
+	if err != nil || aead == nil {
+		return privateKey, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(privateKey), []byte(keyID))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenPrivateKey decrypts material sealed by SealPrivateKey. Plaintext
+// material, stored before encryption was configured, is returned as is.
+func OpenPrivateKey(keyID, stored string) (string, error) {
+	if !IsSealedPrivateKey(stored) {
+		return stored, nil
+	}
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	if aead == nil {
+		return "", fmt.Errorf("signing key %q is encrypted but SIGNING_KEY_ENCRYPTION_KEY is not set", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedKeyPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("signing key %q has malformed encrypted material", keyID)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("signing key %q cannot be decrypted with SIGNING_KEY_ENCRYPTION_KEY", keyID)
+	}
+	return string(plaintext), nil
+}
+
+// MarshalPublicKeyPEM encodes the key's public half as a PKIX PEM block
+func (k *SigningKey) MarshalPublicKeyPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.Public)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public JWK for an asymmetric key. HS256 keys are never published.
+func (k *SigningKey) JWK() (JWK, error) {
+	jwk := JWK{Kid: k.KeyID, Use: "sig", Alg: k.Algorithm}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encode(public)
+	default:
+		return JWK{}, fmt.Errorf("key %q has no publishable public key", k.KeyID)
+	}
+	return jwk, nil
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of the key's public half
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, err := k.JWK()
+	if err != nil {
+		return "", err
+	}
+
+	// Required members only, in lexicographic order
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	default:
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// PublicJWKS returns the key set resource servers use to verify tokens locally
+func PublicJWKS() (*JWKS, error) {
+	keys, err := currentKeyProvider().PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		jwk, err := key.JWK()
+		if err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// EnvKeyProvider reads a single signing key from the environment:
+//
+//	JWT_SIGNING_ALG        HS256 (default), RS256, ES256 or EdDSA
+//	JWT_SECRET             shared secret for HS256
+//	JWT_PRIVATE_KEY        PEM private key for asymmetric algorithms
+//	JWT_PRIVATE_KEY_FILE   path to the PEM private key (alternative to JWT_PRIVATE_KEY)
+//	JWT_KEY_ID             optional kid; defaults to the key thumbprint
+type EnvKeyProvider struct {
+	mu     sync.Mutex
+	pemKey string
+	cached *SigningKey
+}
+
+func (p *EnvKeyProvider) SigningKey() (*SigningKey, error) {
+	algorithm := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALG"))
+	if algorithm == "" {
+		algorithm = AlgorithmHS256
+	}
+
+	if algorithm == AlgorithmHS256 {
+		jwtSecret := os.Getenv("JWT_SECRET")
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("JWT_SECRET not found in environment variables")
+		}
+		return &SigningKey{
+			KeyID:     os.Getenv("JWT_KEY_ID"),
+			Algorithm: AlgorithmHS256,
+			Secret:    []byte(jwtSecret),
+		}, nil
+	}
+
+	privateKeyPEM := os.Getenv("JWT_PRIVATE_KEY")
+	if privateKeyPEM == "" {
+		if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read JWT_PRIVATE_KEY_FILE: %w", err)
+			}
+			privateKeyPEM = string(contents)
+		}
+	}
+	if privateKeyPEM == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE is required for %s", algorithm)
+	}
+
+	// Parsing PEM on every call is wasteful, so cache until the env changes
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cacheKey := algorithm + "|" + os.Getenv("JWT_KEY_ID") + "|" + privateKeyPEM
+	if p.cached != nil && p.pemKey == cacheKey {
+		return p.cached, nil
+	}
+
+	key, err := NewAsymmetricSigningKey(algorithm, os.Getenv("JWT_KEY_ID"), privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	p.pemKey = cacheKey
+	p.cached = key
+	return key, nil
+}
+
+func (p *EnvKeyProvider) VerificationKey(kid string) (*SigningKey, error) {
+	key, err := p.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+	// Tokens issued before kid headers were introduced carry no kid
+	if kid != "" && key.KeyID != "" && kid != key.KeyID {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+func (p *EnvKeyProvider) PublicKeys() ([]*SigningKey, error) {
+	key, err := p.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm == AlgorithmHS256 {
+		return nil, nil
+	}
+	return []*SigningKey{key}, nil
+}